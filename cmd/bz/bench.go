@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	devtools "github.com/boardzilla/boardzilla-devtools/internal"
+	"github.com/gookit/color"
+)
+
+// benchNoise is how many milliseconds slower than the baseline a call can
+// get before it counts as a regression, however small the baseline, since
+// timings that short are mostly noise.
+const benchNoise = 0.5
+
+// benchResult is what bench -json writes and -baseline reads.
+type benchResult struct {
+	Replays    int                       `json:"replays"`
+	SaveStates []string                  `json:"saveStates"`
+	Methods    []*devtools.MethodProfile `json:"methods"`
+}
+
+func (b *bz) bench() error {
+	benchCmd := flag.NewFlagSet("bench", flag.ExitOnError)
+	root := benchCmd.String("root", "", "game root")
+	skipBuild := benchCmd.Bool("skip-build", false, "use the existing game build")
+	replays := benchCmd.Int("n", 10, "times to replay each save state")
+	warmup := benchCmd.Int("warmup", 1, "replays of each save state to make before timing, while the game warms up")
+	jsonFile := benchCmd.String("json", "", "write the results to this file, to use as a -baseline later")
+	baseline := benchCmd.String("baseline", "", "fail if p50 or p90 of a call is slower than in these earlier -json results")
+	tolerance := benchCmd.Float64("tolerance", 0.2, "how much slower than the baseline a call may get, as a fraction")
+	budgets := benchCmd.String("max", "", "fail if p99 of a call is over a budget, like processMove=20ms,initialState=100ms")
+	if err := benchCmd.Parse(os.Args[2:]); err != nil {
+		return err
+	}
+	limits, err := parseBudgets(*budgets)
+	if err != nil {
+		return err
+	}
+	var base *benchResult
+	if *baseline != "" {
+		data, err := os.ReadFile(*baseline)
+		if err != nil {
+			return err
+		}
+		base = &benchResult{}
+		if err := json.Unmarshal(data, base); err != nil {
+			return fmt.Errorf("%s: %w", *baseline, err)
+		}
+	}
+
+	runner, _, err := b.startRunner(*root, *skipBuild)
+	if err != nil {
+		return err
+	}
+	defer runner.Close()
+	withPlayerState, err := runner.HasExport("getPlayerState")
+	if err != nil {
+		return err
+	}
+	saveStatesPath := path.Join(b.root, ".save-states")
+	names := benchCmd.Args()
+	if len(names) == 0 {
+		if names, err = saveStateNames(saveStatesPath); err != nil {
+			return err
+		}
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("no save states to replay, save some in the dev UI first")
+	}
+	saveStates := []*devtools.SaveStateData{}
+	for _, name := range names {
+		saveState, err := devtools.ReadSaveStateFile(path.Join(saveStatesPath, name))
+		if err != nil {
+			return err
+		}
+		saveStates = append(saveStates, saveState)
+	}
+
+	profile := devtools.NewProfile()
+	runner.Profile = profile
+	color.Printf("Replaying <bold>%d</> save states %d times\n", len(saveStates), *replays)
+	for i := -*warmup; i < *replays; i++ {
+		if i == 0 {
+			profile.Reset()
+		}
+		for j, saveState := range saveStates {
+			if err := devtools.ProfileSaveState(runner, saveState, withPlayerState); err != nil {
+				return fmt.Errorf("%s: %w", names[j], err)
+			}
+		}
+	}
+
+	result := &benchResult{Replays: *replays, SaveStates: names, Methods: profile.Summary()}
+	fmt.Printf("\n  %-18s %7s %9s %9s %9s %9s %10s %10s\n", "", "calls", "p50", "p90", "p99", "max", "size p50", "size max")
+	for _, m := range result.Methods {
+		d := m.Duration
+		fmt.Printf("  %-18s %7d %9s %9s %9s %9s %10s %10s\n", m.Method, m.Calls, formatMillis(d.P50), formatMillis(d.P90), formatMillis(d.P99), formatMillis(d.Max), devtools.FormatBytes(int(m.Size.P50)), devtools.FormatBytes(int(m.Size.Max)))
+	}
+	fmt.Println()
+
+	if *jsonFile != "" {
+		data, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(*jsonFile, append(data, '\n'), 0600); err != nil {
+			return err
+		}
+		color.Printf("<gray>Wrote %s</>\n", *jsonFile)
+	}
+
+	failures := 0
+	for _, m := range result.Methods {
+		if limit, ok := limits[m.Method]; ok && m.Duration.P99 > limit {
+			failures++
+			color.Printf("<red>OVER BUDGET</> <bold>%s</> p99 %s, the budget is %s\n", m.Method, formatMillis(m.Duration.P99), formatMillis(limit))
+		}
+		if base == nil {
+			continue
+		}
+		for _, was := range base.Methods {
+			if was.Method != m.Method {
+				continue
+			}
+			for _, p := range []struct {
+				name     string
+				was, now float64
+			}{{"p50", was.Duration.P50, m.Duration.P50}, {"p90", was.Duration.P90, m.Duration.P90}} {
+				if p.now > p.was*(1+*tolerance)+benchNoise {
+					failures++
+					color.Printf("<red>REGRESSED</>   <bold>%s</> %s %s → %s\n", m.Method, p.name, formatMillis(p.was), formatMillis(p.now))
+				}
+			}
+		}
+	}
+	if failures != 0 {
+		return fmt.Errorf("%d timings over their budget or baseline", failures)
+	}
+	if base != nil || len(limits) != 0 {
+		color.Printf("<green>All timings within their budgets and baseline</>\n")
+	}
+	return nil
+}
+
+// parseBudgets reads budgets like processMove=20ms,initialState=100ms into
+// milliseconds by method.
+func parseBudgets(s string) (map[string]float64, error) {
+	budgets := map[string]float64{}
+	if s == "" {
+		return budgets, nil
+	}
+	for _, part := range strings.Split(s, ",") {
+		method, limit, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return nil, fmt.Errorf("budget %q is not of the form <method>=<duration>", part)
+		}
+		if !slices.Contains(devtools.ProfiledMethods(), method) {
+			return nil, fmt.Errorf("budget %q: %s is not timed, use one of %s", part, method, strings.Join(devtools.ProfiledMethods(), ", "))
+		}
+		d, err := time.ParseDuration(limit)
+		if err != nil {
+			return nil, fmt.Errorf("budget %q: %w", part, err)
+		}
+		budgets[method] = float64(d) / float64(time.Millisecond)
+	}
+	return budgets, nil
+}
+
+func formatMillis(ms float64) string {
+	if ms < 1 {
+		return fmt.Sprintf("%.0fµs", ms*1000)
+	}
+	return fmt.Sprintf("%.1fms", ms)
+}
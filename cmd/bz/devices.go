@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"net"
+
+	devtools "github.com/boardzilla/boardzilla-devtools/internal"
+	"github.com/gookit/color"
+)
+
+// printDeviceURLs lists the URLs other devices on the network can use to
+// reach the dev server, with QR codes for the main URL and for each seat.
+func printDeviceURLs(server *devtools.Server, manifest *devtools.ManifestV1, host string, showQR bool) error {
+	hosts := []string{}
+	ip := net.ParseIP(host)
+	if host != "" && (ip == nil || !ip.IsUnspecified()) {
+		if host == "localhost" || (ip != nil && ip.IsLoopback()) {
+			return nil
+		}
+		hosts = append(hosts, host)
+	} else {
+		addrs, err := devtools.LANAddresses()
+		if err != nil {
+			return err
+		}
+		for _, addr := range addrs {
+			hosts = append(hosts, addr.String())
+		}
+	}
+	if len(hosts) == 0 {
+		return nil
+	}
+
+	color.Println("📱 Reachable from other devices at")
+	for _, h := range hosts {
+		color.Printf("   <bold>%s</>\n", server.URL(h))
+	}
+	if !showQR {
+		return nil
+	}
+	main := hosts[0]
+	if err := printQR("Scan to open", server.URL(main)); err != nil {
+		return err
+	}
+	// the shell seats dev users in roster order
+	for i, user := range server.DevUsers()[:manifest.MaximumPlayers] {
+		if err := printQR(fmt.Sprintf("Scan to play as seat %d, %s", i+1, user.Name), server.UserURL(main, user.ID)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func printQR(title, u string) error {
+	qr, err := devtools.EncodeQR(u)
+	if err != nil {
+		return err
+	}
+	color.Printf("\n<bold>%s</> <gray>%s</>\n", title, u)
+	fmt.Print(qr.Terminal())
+	return nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	devtools "github.com/boardzilla/boardzilla-devtools/internal"
+	"github.com/gookit/color"
+)
+
+// maxStackLines is how much of a failure's stack trace is shown.
+const maxStackLines = 8
+
+func (b *bz) fuzz() error {
+	fuzzCmd := flag.NewFlagSet("fuzz", flag.ExitOnError)
+	root := fuzzCmd.String("root", "", "game root")
+	skipBuild := fuzzCmd.Bool("skip-build", false, "use the existing game build")
+	games := fuzzCmd.Int("games", 200, "number of games to play")
+	maxMoves := fuzzCmd.Int("max-moves", 200, "moves after which a game is stopped")
+	playersFlag := fuzzCmd.String("players", "", "player counts to cycle through, like 3, 2-4 or 2,4 (default the manifest's minimum to maximum)")
+	settings := fuzzCmd.String("settings", "{}", "game settings as JSON")
+	seedPrefix := fuzzCmd.String("seed", "fuzz", "prefix of each game's random seed, which is followed by the game number")
+	mutate := fuzzCmd.Float64("mutate", 0.2, "chance an enumerated move is mutated before it is made")
+	allErrors := fuzzCmd.Bool("all-errors", false, "count every error the game throws as a failure, not only ones like TypeError")
+	if err := fuzzCmd.Parse(os.Args[2:]); err != nil {
+		return err
+	}
+	if !json.Valid([]byte(*settings)) {
+		return fmt.Errorf("-settings must be JSON")
+	}
+
+	runner, manifest, err := b.startRunner(*root, *skipBuild)
+	if err != nil {
+		return err
+	}
+	fuzzer := &devtools.Fuzzer{
+		Runner: runner,
+		NewRunner: func() (*devtools.Runner, error) {
+			return devtools.NewRunnerForManifest(b.root, manifest)
+		},
+		MutateRate: *mutate,
+		AllErrors:  *allErrors,
+		MaxMoves:   *maxMoves,
+	}
+	defer func() { fuzzer.Runner.Close() }()
+	playerCounts, err := parsePlayerCounts(*playersFlag, manifest)
+	if err != nil {
+		return err
+	}
+	devUsers, err := devtools.LoadDevUsers(b.root, manifest)
+	if err != nil {
+		return err
+	}
+	saveStatesPath := path.Join(b.root, ".save-states")
+	if fuzzer.Corpus, err = recordedMoves(saveStatesPath); err != nil {
+		return err
+	}
+	if fuzzer.Enumerate, err = runner.HasExport("enumerateMoves"); err != nil {
+		return err
+	}
+	switch {
+	case fuzzer.Enumerate:
+		color.Printf("Fuzzing <bold>%d</> games with moves from enumerateMoves, mutating %.0f%%\n", *games, 100**mutate)
+	case len(fuzzer.Corpus) != 0:
+		color.Printf("Fuzzing <bold>%d</> games by mutating <bold>%d</> moves recorded in save states\n", *games, len(fuzzer.Corpus))
+	default:
+		return fmt.Errorf("requires the game to export enumerateMoves or save states with moves to mutate")
+	}
+
+	failures := map[string]int{}
+	order := []string{}
+	errored := 0
+	for i := 0; i < *games; i++ {
+		players := playerCounts[i%len(playerCounts)]
+		setup := &devtools.SetupState{
+			RandomSeed: *seedPrefix + "-" + strconv.Itoa(i),
+			Players:    devtools.SeatDevUsers(devUsers[:players]),
+			Settings:   json.RawMessage(*settings),
+		}
+		fuzzer.Rand = rand.New(rand.NewSource(int64(i))) // #nosec G404
+		failure, err := fuzzer.Fuzz(setup)
+		if err != nil {
+			errored++
+			color.Printf("<yellow>ERROR</> <bold>%s</> %s\n", setup.RandomSeed, strings.SplitN(err.Error(), "\n", 2)[0])
+			continue
+		}
+		if failure == nil {
+			continue
+		}
+		signature := failure.Signature()
+		failures[signature]++
+		if failures[signature] > 1 {
+			continue
+		}
+		order = append(order, signature)
+		failure = fuzzer.Minimize(failure)
+		color.Printf("<red>FAIL</>  <bold>%s</>, %d players\n", setup.RandomSeed, players)
+		lines := strings.Split(strings.TrimSpace(failure.Error), "\n")
+		for j, line := range lines {
+			if strings.Contains(line, "([eval]") {
+				// the rest is the headless runner
+				break
+			}
+			if j == maxStackLines {
+				color.Printf("      <gray>...</>\n")
+				break
+			}
+			color.Printf("      %s\n", line)
+		}
+		if failure.Move == nil {
+			color.Printf("      <gray>in initialState</>\n")
+			continue
+		}
+		data, err := json.Marshal(failure.Playout().SaveState(manifest.StateVersion))
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(saveStatesPath, 0700); err != nil {
+			return err
+		}
+		name := setup.RandomSeed
+		if err := devtools.WriteSaveStateFile(path.Join(saveStatesPath, name), data); err != nil {
+			return err
+		}
+		if len(failure.Updates) > len(failure.Moves)+1 {
+			color.Printf("      <gray>saved as save state %s, whose last move leaves the invalid state</>\n", name)
+		} else {
+			color.Printf("      <gray>saved as save state %s with %d moves, then position %d moves %s</>\n", name, len(failure.Moves), failure.Move.Position, string(failure.Move.Data))
+		}
+	}
+
+	color.Printf("\nPlayed <bold>%d</> games: %d moves made, %d rejected\n", *games, fuzzer.Moves, fuzzer.Rejected)
+	if errored != 0 {
+		color.Printf("<yellow>%d games could not be fuzzed</>\n", errored)
+	}
+	if len(order) != 0 {
+		for _, signature := range order {
+			color.Printf("  <red>%4d</> %s\n", failures[signature], signature)
+		}
+		return fmt.Errorf("found %d distinct failures", len(order))
+	}
+	color.Printf("No failures found ✅\n")
+	return nil
+}
+
+// recordedMoves is the move data in every save state in saveStatesPath.
+func recordedMoves(saveStatesPath string) ([]json.RawMessage, error) {
+	names, err := saveStateNames(saveStatesPath)
+	if err != nil {
+		return nil, err
+	}
+	moves := []json.RawMessage{}
+	for _, name := range names {
+		saveState, err := devtools.ReadSaveStateFile(path.Join(saveStatesPath, name))
+		if err != nil {
+			return nil, err
+		}
+		for _, h := range saveState.History {
+			moves = append(moves, h.Data)
+		}
+	}
+	return moves, nil
+}
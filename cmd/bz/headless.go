@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+
+	devtools "github.com/boardzilla/boardzilla-devtools/internal"
+)
+
+// startRunner builds the game at root unless skipBuild is set and starts a
+// headless runner for it.
+func (b *bz) startRunner(root string, skipBuild bool) (*devtools.Runner, *devtools.ManifestV1, error) {
+	if root == "" {
+		return nil, nil, fmt.Errorf("requires -root <game root>")
+	}
+	gameRoot, err := filepath.Abs(root)
+	if err != nil {
+		return nil, nil, err
+	}
+	b.root = gameRoot
+	builder, err := devtools.NewBuilder(gameRoot)
+	if err != nil {
+		return nil, nil, err
+	}
+	manifest, err := builder.Manifest()
+	if err != nil {
+		return nil, nil, fmt.Errorf("error getting manifest json: %w", err)
+	}
+	if !skipBuild {
+		if stdout, stderr, err := builder.Build(devtools.Dev, devtools.Game); err != nil {
+			return nil, nil, fmt.Errorf("error during build: %w\n\nout: %s\n\nerr: %s", err, stdout, stderr)
+		}
+	}
+	runner, err := devtools.NewRunnerForManifest(gameRoot, manifest)
+	if err != nil {
+		return nil, nil, err
+	}
+	return runner, manifest, nil
+}
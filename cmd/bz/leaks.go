@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+
+	devtools "github.com/boardzilla/boardzilla-devtools/internal"
+	"github.com/gookit/color"
+)
+
+func (b *bz) leaks() error {
+	leaksCmd := flag.NewFlagSet("leaks", flag.ExitOnError)
+	root := leaksCmd.String("root", "", "game root")
+	skipBuild := leaksCmd.Bool("skip-build", false, "use the existing game build")
+	playouts := leaksCmd.Int("playouts", 20, "number of random playouts, using the game's enumerateMoves export")
+	maxMoves := leaksCmd.Int("max-moves", 200, "moves after which a playout is stopped")
+	if err := leaksCmd.Parse(os.Args[2:]); err != nil {
+		return err
+	}
+
+	runner, manifest, err := b.startRunner(*root, *skipBuild)
+	if err != nil {
+		return err
+	}
+	defer runner.Close()
+	devUsers, err := devtools.LoadDevUsers(b.root, manifest)
+	if err != nil {
+		return err
+	}
+	saveStatesPath := path.Join(b.root, ".save-states")
+	states, scenarios, err := replayInputs(leaksCmd.Args(), saveStatesPath, path.Join(b.root, scenariosDir))
+	if err != nil {
+		return err
+	}
+	if len(manifest.PrivateState) != 0 {
+		color.Printf("Checking private state at <bold>%v</>\n", manifest.PrivateState)
+	} else {
+		color.Printf("<gray>No privateState in the manifest, looking for values shown to some players and not others</>\n")
+	}
+
+	checked := 0
+	leaking := 0
+	check := func(snapshot *devtools.Snapshot, label string) bool {
+		checked++
+		leaks := leakReport(snapshot, manifest.PrivateState)
+		if len(leaks) == 0 {
+			color.Printf("<green>OK</>   <bold>%s</>\n", label)
+			return false
+		}
+		leaking++
+		color.Printf("<red>LEAK</> <bold>%s</>\n", label)
+		for _, l := range leaks {
+			color.Printf("     %s\n", l)
+		}
+		return true
+	}
+
+	for _, name := range states {
+		snapshot, err := devtools.SnapshotSaveState(runner, path.Join(saveStatesPath, name))
+		if err != nil {
+			return err
+		}
+		check(snapshot, "save state "+name)
+	}
+	for _, file := range scenarios {
+		snapshot, err := devtools.SnapshotScenario(runner, manifest, devUsers, file)
+		if err != nil {
+			return err
+		}
+		check(snapshot, "scenario "+filepath.Base(file))
+	}
+
+	if *playouts > 0 {
+		canEnumerate, err := runner.HasExport("enumerateMoves")
+		if err != nil {
+			return err
+		}
+		if !canEnumerate {
+			color.Printf("<gray>Skipping random playouts, the game does not export enumerateMoves</>\n")
+			*playouts = 0
+		}
+	}
+	for i := 0; i < *playouts; i++ {
+		players := manifest.MinimumPlayers + i%(manifest.MaximumPlayers-manifest.MinimumPlayers+1)
+		setup := &devtools.SetupState{
+			RandomSeed: "leaks-" + strconv.Itoa(i),
+			Players:    devtools.SeatDevUsers(devUsers[:players]),
+			Settings:   json.RawMessage("{}"),
+		}
+		playout, err := devtools.RandomPlayout(runner, setup, rand.New(rand.NewSource(int64(i))), *maxMoves) // #nosec G404
+		if err != nil {
+			return fmt.Errorf("playout with seed %s: %w", setup.RandomSeed, err)
+		}
+		snapshot, err := devtools.NewSnapshot(setup.RandomSeed, playout.Moves, playout.Updates)
+		if err != nil {
+			return err
+		}
+		label := fmt.Sprintf("playout %s, %d players, %d moves", setup.RandomSeed, players, len(playout.Moves))
+		if !check(snapshot, label) {
+			continue
+		}
+		// keep the leaking game so it can be opened in the dev UI
+		data, err := json.Marshal(playout.SaveState(manifest.StateVersion))
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(saveStatesPath, 0700); err != nil {
+			return err
+		}
+		if err := devtools.WriteSaveStateFile(path.Join(saveStatesPath, setup.RandomSeed), data); err != nil {
+			return err
+		}
+		color.Printf("     <gray>saved as save state %s</>\n", setup.RandomSeed)
+	}
+
+	if checked == 0 {
+		return fmt.Errorf("nothing to check, add save states or scenarios or export enumerateMoves")
+	}
+	if leaking != 0 {
+		return fmt.Errorf("%d of %d games leak hidden information", leaking, checked)
+	}
+	color.Printf("No leaks found in <bold>%d</> games ✅\n", checked)
+	return nil
+}
+
+// leakReport checks every step of a snapshot, listing each way information
+// leaks once with the step it first appeared at.
+func leakReport(snapshot *devtools.Snapshot, privatePaths []string) []string {
+	type channel struct{ ownerPath, path string }
+	first := map[channel]string{}
+	positions := map[channel]map[string]bool{}
+	order := []channel{}
+	for i, step := range snapshot.Steps {
+		for _, l := range devtools.FindLeaks(step.Players, privatePaths) {
+			c := channel{l.OwnerPath, l.Path}
+			if _, ok := first[c]; !ok {
+				at := "at the start"
+				if i > 0 {
+					at = fmt.Sprintf("after move %d by position %d", i, step.Move.Position)
+				}
+				first[c] = fmt.Sprintf("%s, %s", l, at)
+				positions[c] = map[string]bool{}
+				order = append(order, c)
+			}
+			positions[c][fmt.Sprintf("%d→%d", l.Owner, l.Viewer)] = true
+		}
+	}
+	report := []string{}
+	for _, c := range order {
+		line := first[c]
+		if n := len(positions[c]) - 1; n > 0 {
+			line += fmt.Sprintf(" (%d more like it)", n)
+		}
+		report = append(report, line)
+	}
+	return report
+}
@@ -21,6 +21,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"runtime/debug"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -82,10 +83,20 @@ func getTextInput(prompt, placeholder, _ string, validator func(string) error) (
 func printHelp() {
 	fmt.Println("usage: bz [command]")
 	fmt.Println("")
-	fmt.Println("run -root <game root>                          Run the devtools for a game")
+	fmt.Println("run -root <game root> [-host <host>] [-https]  Run the devtools for a game, -help for options")
 	fmt.Println("info -root <game root>                         Get info about the game at root")
 	fmt.Println("submit -root <game root> -version <version>    Submit a game")
 	fmt.Println("new")
+	fmt.Println("migrate -root <game root> [states...]          Upgrade save states to the current format and game state version")
+	fmt.Println("scenario run -root <game root> [files...]      Play scenario files headlessly and check their assertions")
+	fmt.Println("snapshot -root <game root> [-update] [...]     Compare each player's view of save states and scenarios to snapshots")
+	fmt.Println("leaks -root <game root> [-playouts n] [...]    Look for hidden information shown to other players")
+	fmt.Println("simulate -root <game root> [-n games] [...]    Play many headless games and report win rates, scores and game lengths")
+	fmt.Println("bench -root <game root> [-n replays] [...]     Time the game's calls replaying save states, failing over budgets or a baseline")
+	fmt.Println("fuzz -root <game root> [-games n] [...]        Play games with generated moves looking for ones that crash processMove")
+	fmt.Println("matrix -root <game root> [-seeds n] [...]      Play every player count with several seeds and settings, showing a pass/fail grid")
+	fmt.Println("seed-search -root <game root> <predicate>...   Find seeds whose initial state matches, with links to start them")
+	fmt.Println("verify -root <game root>                       Check the built game exports the functions the platform calls and returns valid updates")
 	fmt.Println("version                                        Shows version installed")
 	fmt.Println("")
 }
@@ -157,6 +168,26 @@ func (b *bz) exec() error {
 		return b.submit()
 	case "new":
 		return b.new()
+	case "migrate":
+		return b.migrate()
+	case "scenario":
+		return b.scenario()
+	case "snapshot":
+		return b.snapshot()
+	case "leaks":
+		return b.leaks()
+	case "simulate":
+		return b.simulate()
+	case "fuzz":
+		return b.fuzz()
+	case "matrix":
+		return b.matrix()
+	case "seed-search":
+		return b.seedSearch()
+	case "verify":
+		return b.verify()
+	case "bench":
+		return b.bench()
 	default:
 		fmt.Printf("Unrecognized command: %s\n\n", command)
 		printHelp()
@@ -242,7 +273,14 @@ Date     %s`, version, commit, date)
 func (b *bz) run() error {
 	runCmd := flag.NewFlagSet("run", flag.ExitOnError)
 	root := runCmd.String("root", "", "game root")
+	host := runCmd.String("host", "", "interface to bind to, all interfaces if empty")
 	port := runCmd.Int("port", 8080, "port for server")
+	https := runCmd.Bool("https", false, "serve over https with a locally generated certificate")
+	noQR := runCmd.Bool("no-qr", false, "do not print QR codes for other devices")
+	latency := runCmd.Duration("latency", 0, "simulated network latency")
+	jitter := runCmd.Duration("jitter", 0, "simulated network jitter")
+	drop := runCmd.Float64("drop", 0, "simulated rate of dropped events and assets, from 0 to 1")
+	crossOrigin := runCmd.Bool("cross-origin", false, "serve the ui and game frames sandboxed from their own origins on the next two ports")
 	if err := runCmd.Parse(os.Args[2:]); err != nil {
 		return err
 	}
@@ -264,7 +302,27 @@ func (b *bz) run() error {
 	if err != nil {
 		log.Fatal(fmt.Errorf("error getting manifest json: %w", err))
 	}
-	server, err := devtools.NewServer(gameRoot, manifest, *port)
+	var certs *devtools.Certificates
+	if *https {
+		if certs, err = loadCertificates(*host); err != nil {
+			log.Fatal(fmt.Errorf("error setting up https: %w", err))
+		}
+	}
+	if *drop < 0 || *drop > 1 {
+		return fmt.Errorf("-drop must be between 0 and 1")
+	}
+	server, err := devtools.NewServer(gameRoot, manifest, devtools.ServerOptions{
+		Host:         *host,
+		Port:         *port,
+		Certificates: certs,
+		Network: devtools.NetworkConditions{
+			Enabled:   *latency != 0 || *jitter != 0 || *drop != 0,
+			LatencyMs: int(latency.Milliseconds()),
+			JitterMs:  int(jitter.Milliseconds()),
+			DropRate:  *drop,
+		},
+		CrossOrigin: *crossOrigin,
+	})
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -376,13 +434,57 @@ func (b *bz) run() error {
 	color.Printf("Running dev builder on port <bold>%d</> at game root <bold>%s</>\n", *port, gameRoot)
 
 	// Block main goroutine forever.
-	color.Printf("🦖 Ready on <bold>:%d</>\n", *port)
+	urlHost := *host
+	if urlHost == "" {
+		urlHost = "localhost"
+	}
+	if certs != nil {
+		if certs.Created {
+			color.Yellowln("🔐 Generated a new certificate authority for https")
+		}
+		caHost := urlHost
+		if addrs, err := devtools.LANAddresses(); err == nil && len(addrs) != 0 {
+			caHost = addrs[0].String()
+		}
+		fmt.Println(certs.TrustInstructions(server.CAURL(caHost)))
+	}
+	if err := printDeviceURLs(server, manifest, *host, !*noQR); err != nil {
+		color.Printf("<yellow>Unable to list network addresses: %s</>\n", err)
+	}
+	if *crossOrigin {
+		color.Printf("🧱 Serving the UI frame on port <bold>%d</> and the game frame on port <bold>%d</>\n", *port+1, *port+2)
+	}
+	color.Printf("🦖 Ready on <bold>%s</>\n", server.URL(urlHost))
 	if err := server.Serve(); err != nil {
 		log.Fatal(err)
 	}
 	return nil
 }
 
+// loadCertificates loads or creates a certificate valid for localhost,
+// this machine's hostname and LAN addresses, and host if given.
+func loadCertificates(host string) (*devtools.Certificates, error) {
+	dir, err := devtools.CertificatesDir()
+	if err != nil {
+		return nil, err
+	}
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	if hostname, err := os.Hostname(); err == nil {
+		hosts = append(hosts, hostname, hostname+".local")
+	}
+	addrs, err := devtools.LANAddresses()
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		hosts = append(hosts, addr.String())
+	}
+	if host != "" && !slices.Contains(hosts, host) {
+		hosts = append(hosts, host)
+	}
+	return devtools.LoadOrCreateCertificates(dir, hosts)
+}
+
 func (b *bz) getGameName() (string, error) {
 	packageJSONPath := path.Join(b.root, "package.json")
 	_, err := os.Stat(packageJSONPath)
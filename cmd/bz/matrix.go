@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+
+	devtools "github.com/boardzilla/boardzilla-devtools/internal"
+	"github.com/gookit/color"
+)
+
+func (b *bz) matrix() error {
+	matrixCmd := flag.NewFlagSet("matrix", flag.ExitOnError)
+	root := matrixCmd.String("root", "", "game root")
+	skipBuild := matrixCmd.Bool("skip-build", false, "use the existing game build")
+	playersFlag := matrixCmd.String("players", "", "player counts to test, like 3, 2-4 or 2,4 (default the manifest's minimum to maximum)")
+	seedsFlag := matrixCmd.String("seeds", "3", "how many seeds to test, or the seeds to test separated by commas")
+	seedPrefix := matrixCmd.String("seed", "matrix", "prefix of each generated seed, which is followed by the seed number")
+	settings := matrixCmd.String("settings", "", "test only these game settings, given as JSON (default the presets in .bz/presets.json)")
+	maxMoves := matrixCmd.Int("moves", 20, "moves to play from the initial state")
+	bot := matrixCmd.String("bot", "", "command run in the game root for each seat to script the moves, see Bots (default random moves from the game's enumerateMoves export)")
+	if err := matrixCmd.Parse(os.Args[2:]); err != nil {
+		return err
+	}
+	seeds := parseSeeds(*seedsFlag, *seedPrefix)
+	if len(seeds) == 0 {
+		return fmt.Errorf("no seeds in %q", *seedsFlag)
+	}
+
+	runner, manifest, err := b.startRunner(*root, *skipBuild)
+	if err != nil {
+		return err
+	}
+	defer func() { runner.Close() }()
+	playerCounts, err := parsePlayerCounts(*playersFlag, manifest)
+	if err != nil {
+		return err
+	}
+	devUsers, err := devtools.LoadDevUsers(b.root, manifest)
+	if err != nil {
+		return err
+	}
+	presets := []*devtools.SettingsPreset{{Name: "settings", Settings: json.RawMessage(*settings)}}
+	if *settings == "" {
+		if presets, err = devtools.LoadSettingsPresets(b.root); err != nil {
+			return err
+		}
+	} else if !json.Valid([]byte(*settings)) {
+		return fmt.Errorf("-settings must be JSON")
+	}
+
+	var bots *devtools.BotPicker
+	botCommand := strings.Fields(*bot)
+	canEnumerate, err := runner.HasExport("enumerateMoves")
+	if err != nil {
+		return err
+	}
+	cells := len(playerCounts) * len(seeds) * len(presets)
+	switch {
+	case len(botCommand) != 0:
+		bots = &devtools.BotPicker{Dir: b.root, Command: botCommand}
+		defer bots.Close()
+		color.Printf("Testing <bold>%d</> combinations with up to %d moves by <bold>%s</>\n", cells, *maxMoves, *bot)
+	case canEnumerate:
+		color.Printf("Testing <bold>%d</> combinations with up to %d random moves\n", cells, *maxMoves)
+	default:
+		color.Printf("Testing the initial state of <bold>%d</> combinations <gray>(export enumerateMoves or pass -bot to play moves too)</>\n", cells)
+	}
+
+	// results by preset, then seed, then player count
+	results := make([][][]*devtools.MatrixCell, len(presets))
+	for i, preset := range presets {
+		results[i] = make([][]*devtools.MatrixCell, len(seeds))
+		for j, seed := range seeds {
+			for _, players := range playerCounts {
+				setup := &devtools.SetupState{
+					RandomSeed: seed,
+					Players:    devtools.SeatDevUsers(devUsers[:players]),
+					Settings:   preset.Settings,
+				}
+				rng := rand.New(rand.NewSource(int64(j))) // #nosec G404
+				var picker devtools.MovePicker
+				switch {
+				case bots != nil:
+					picker = bots
+				case canEnumerate:
+					picker = &devtools.RandomPicker{Runner: runner, Rand: rng}
+				}
+				cell, playout := devtools.PlayMatrixCell(runner, setup, preset.Name, picker, rng, *maxMoves)
+				if bots != nil && playout.Finished {
+					if err := bots.Finish(playout.Updates[len(playout.Updates)-1]); err != nil {
+						fmt.Printf("error: %#v\n", err)
+					}
+				}
+				results[i][j] = append(results[i][j], cell)
+				if runner.Exited() {
+					// the game crashed the runner, start another for the next cell
+					runner.Close()
+					if runner, err = devtools.NewRunnerForManifest(b.root, manifest); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+
+	presetWidth, seedWidth := 0, 0
+	for _, preset := range presets {
+		presetWidth = max(presetWidth, len(preset.Name))
+	}
+	for _, seed := range seeds {
+		seedWidth = max(seedWidth, len(seed))
+	}
+	fmt.Printf("\n  %-*s  %-*s", presetWidth, "", seedWidth, "")
+	for _, players := range playerCounts {
+		fmt.Printf(" %8s", strconv.Itoa(players)+"p")
+	}
+	fmt.Println()
+	// failing cells are numbered by their error so the same error shares a
+	// number
+	distinct := []string{}
+	firstCells := map[string]*devtools.MatrixCell{}
+	counts := map[string]int{}
+	failed := 0
+	for i, preset := range presets {
+		for j, seed := range seeds {
+			fmt.Printf("  %-*s  %-*s", presetWidth, preset.Name, seedWidth, seed)
+			for _, cell := range results[i][j] {
+				if cell.Error == "" {
+					color.Printf(" <green>%8s</>", "pass")
+					continue
+				}
+				n := 0
+				for n < len(distinct) && distinct[n] != cell.Error {
+					n++
+				}
+				if n == len(distinct) {
+					distinct = append(distinct, cell.Error)
+					firstCells[cell.Error] = cell
+				}
+				counts[cell.Error]++
+				failed++
+				color.Printf(" <red>%8s</>", "FAIL "+strconv.Itoa(n+1))
+			}
+			fmt.Println()
+		}
+	}
+	fmt.Println()
+	if failed == 0 {
+		color.Printf("All <bold>%d</> combinations passed ✅\n", cells)
+		return nil
+	}
+	for n, e := range distinct {
+		first := firstCells[e]
+		color.Printf("<red>%d</> %s\n", n+1, e)
+		color.Printf("  <gray>in %d cells, first with %d players, seed %s, %s</>\n", counts[e], first.Players, first.Seed, first.Preset)
+	}
+	return fmt.Errorf("%d of %d combinations failed", failed, cells)
+}
+
+// parseSeeds reads -seeds, a count of seeds to generate from prefix or a
+// list of seeds separated by commas.
+func parseSeeds(spec, prefix string) []string {
+	seeds := []string{}
+	if n, err := strconv.Atoi(spec); err == nil {
+		for i := 0; i < n; i++ {
+			seeds = append(seeds, prefix+"-"+strconv.Itoa(i))
+		}
+		return seeds
+	}
+	for _, seed := range strings.Split(spec, ",") {
+		if seed = strings.TrimSpace(seed); seed != "" {
+			seeds = append(seeds, seed)
+		}
+	}
+	return seeds
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	devtools "github.com/boardzilla/boardzilla-devtools/internal"
+	"github.com/gookit/color"
+)
+
+func (b *bz) migrate() error {
+	migrateCmd := flag.NewFlagSet("migrate", flag.ExitOnError)
+	root := migrateCmd.String("root", "", "game root")
+	skipBuild := migrateCmd.Bool("skip-build", false, "use the existing game build")
+	if err := migrateCmd.Parse(os.Args[2:]); err != nil {
+		return err
+	}
+
+	runner, manifest, err := b.startRunner(*root, *skipBuild)
+	if err != nil {
+		return err
+	}
+	defer runner.Close()
+
+	saveStatesPath := path.Join(b.root, ".save-states")
+	names := migrateCmd.Args()
+	if len(names) == 0 {
+		entries, err := os.ReadDir(saveStatesPath)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+				continue
+			}
+			names = append(names, e.Name())
+		}
+	}
+
+	canMigrate, err := runner.HasExport("migrateState")
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		target := path.Join(saveStatesPath, name)
+		if err := devtools.UpgradeSaveStateFile(target); err != nil {
+			return fmt.Errorf("upgrading %s: %w", name, err)
+		}
+		data, err := os.ReadFile(target) // #nosec G304
+		if err != nil {
+			return err
+		}
+		saveState := &devtools.SaveStateData{}
+		if err := json.Unmarshal(data, saveState); err != nil {
+			return fmt.Errorf("reading %s: %w", name, err)
+		}
+		if saveState.GameStateVersion >= manifest.StateVersion {
+			color.Printf("<bold>%s</> is up to date at state version <cyan>%d</>\n", name, saveState.GameStateVersion)
+			continue
+		}
+		if !canMigrate {
+			return fmt.Errorf("%s is at state version %d but the game does not export migrateState to reach %d", name, saveState.GameStateVersion, manifest.StateVersion)
+		}
+		from, to := saveState.GameStateVersion, manifest.StateVersion
+		state, err := runner.MigrateState(saveState.InitialState.State, from, to)
+		if err != nil {
+			return fmt.Errorf("migrating initial state of %s: %w", name, err)
+		}
+		saveState.InitialState.State = state
+		for _, h := range saveState.History {
+			state, err := runner.MigrateState(h.State, from, to)
+			if err != nil {
+				return fmt.Errorf("migrating history %d of %s: %w", h.Seq, name, err)
+			}
+			h.State = state
+		}
+		saveState.GameStateVersion = to
+		migrated, err := json.Marshal(saveState)
+		if err != nil {
+			return err
+		}
+		if err := devtools.WriteSaveStateFile(target, migrated); err != nil {
+			return err
+		}
+		color.Printf("Migrated <bold>%s</> from state version <cyan>%d</> to <cyan>%d</> ✅\n", name, from, to)
+	}
+	return nil
+}
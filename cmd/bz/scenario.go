@@ -0,0 +1,118 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	devtools "github.com/boardzilla/boardzilla-devtools/internal"
+	"github.com/gookit/color"
+)
+
+const scenariosDir = ".bz/scenarios"
+
+func (b *bz) scenario() error {
+	if len(os.Args) < 3 || os.Args[2] != "run" {
+		return fmt.Errorf("usage: bz scenario run -root <game root> [files...]")
+	}
+	scenarioCmd := flag.NewFlagSet("scenario run", flag.ExitOnError)
+	root := scenarioCmd.String("root", "", "game root")
+	skipBuild := scenarioCmd.Bool("skip-build", false, "use the existing game build")
+	junit := scenarioCmd.String("junit", "", "write results as JUnit XML to this file")
+	if err := scenarioCmd.Parse(os.Args[3:]); err != nil {
+		return err
+	}
+
+	runner, manifest, err := b.startRunner(*root, *skipBuild)
+	if err != nil {
+		return err
+	}
+	defer func() { runner.Close() }()
+	devUsers, err := devtools.LoadDevUsers(b.root, manifest)
+	if err != nil {
+		return err
+	}
+	files, err := jsonFiles(scenarioCmd.Args(), path.Join(b.root, scenariosDir))
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no scenarios given and none in %s", scenariosDir)
+	}
+
+	failed := 0
+	cases := []devtools.JUnitCase{}
+	for _, file := range files {
+		result, err := devtools.RunScenario(runner, manifest, devUsers, file)
+		if err != nil {
+			// report it with the others so -junit still covers every file
+			result = &devtools.ScenarioResult{File: file, Name: strings.TrimSuffix(filepath.Base(file), filepath.Ext(file)), Failures: []string{err.Error()}}
+			if runner.Exited() {
+				runner.Close()
+				if runner, err = devtools.NewRunnerForManifest(b.root, manifest); err != nil {
+					return err
+				}
+			}
+		}
+		cases = append(cases, devtools.JUnitCase{Name: result.Name, ClassName: file, Duration: result.Duration, Failures: result.Failures})
+		if result.Passed() {
+			color.Printf("<green>PASS</> <bold>%s</> <gray>%s</>\n", result.Name, file)
+			continue
+		}
+		failed++
+		color.Printf("<red>FAIL</> <bold>%s</> <gray>%s</>\n", result.Name, file)
+		for _, f := range result.Failures {
+			color.Printf("     %s\n", f)
+		}
+	}
+
+	if *junit != "" {
+		f, err := os.Create(*junit)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if err := devtools.WriteJUnit(f, "scenarios", cases); err != nil {
+			return err
+		}
+	}
+	if failed != 0 {
+		return fmt.Errorf("%d of %d scenarios failed", failed, len(files))
+	}
+	color.Printf("All <bold>%d</> scenarios passed ✅\n", len(files))
+	return nil
+}
+
+// jsonFiles expands args, which may be files or directories of .json
+// files, into a sorted list of files. Without args it lists defaultDir. An
+// arg that doesn't exist is kept as a file, for reading it to fail on.
+func jsonFiles(args []string, defaultDir string) ([]string, error) {
+	if len(args) == 0 {
+		if _, err := os.Stat(defaultDir); os.IsNotExist(err) {
+			return nil, nil
+		}
+		args = []string{defaultDir}
+	}
+	files := []string{}
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if err != nil || !info.IsDir() {
+			files = append(files, arg)
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(arg, "*.json"))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(matches)
+		files = append(files, matches...)
+	}
+	return files, nil
+}
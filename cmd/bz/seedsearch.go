@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+
+	devtools "github.com/boardzilla/boardzilla-devtools/internal"
+	"github.com/gookit/color"
+)
+
+func (b *bz) seedSearch() error {
+	searchCmd := flag.NewFlagSet("seed-search", flag.ExitOnError)
+	root := searchCmd.String("root", "", "game root")
+	skipBuild := searchCmd.Bool("skip-build", false, "use the existing game build")
+	players := searchCmd.Int("players", 0, "number of players (default the manifest's default)")
+	settings := searchCmd.String("settings", "{}", "game settings as JSON")
+	n := searchCmd.Int("n", 5, "number of seeds to find")
+	tries := searchCmd.Int("max", 10000, "seeds to try before giving up")
+	seedPrefix := searchCmd.String("seed", "search", "prefix of each seed tried, which is followed by the seed number")
+	baseURL := searchCmd.String("url", "", "the URL bz run printed, with its token, for the links")
+	open := searchCmd.Bool("open", false, "open the links to the seeds found in the browser")
+	if err := searchCmd.Parse(os.Args[2:]); err != nil {
+		return err
+	}
+	if searchCmd.NArg() == 0 {
+		return fmt.Errorf("usage: bz seed-search -root <game root> [options] <predicate>...")
+	}
+	predicates := []*devtools.Predicate{}
+	for _, arg := range searchCmd.Args() {
+		p, err := devtools.ParsePredicate(arg)
+		if err != nil {
+			return err
+		}
+		predicates = append(predicates, p)
+	}
+	if !json.Valid([]byte(*settings)) {
+		return fmt.Errorf("-settings must be JSON")
+	}
+	if *open && *baseURL == "" {
+		return fmt.Errorf("-open needs the URL bz run printed in -url")
+	}
+
+	runner, manifest, err := b.startRunner(*root, *skipBuild)
+	if err != nil {
+		return err
+	}
+	defer func() { runner.Close() }()
+	if *players == 0 {
+		*players = manifest.DefaultPlayers
+		if *players == 0 {
+			*players = manifest.MinimumPlayers
+		}
+	}
+	if *players < manifest.MinimumPlayers || *players > manifest.MaximumPlayers {
+		return fmt.Errorf("the game is for %d to %d players, not %d", manifest.MinimumPlayers, manifest.MaximumPlayers, *players)
+	}
+	devUsers, err := devtools.LoadDevUsers(b.root, manifest)
+	if err != nil {
+		return err
+	}
+
+	color.Printf("Searching up to <bold>%d</> seeds with %d players for %d that match\n", *tries, *players, *n)
+	found := []string{}
+	errored := 0
+	for i := 0; i < *tries && len(found) < *n; i++ {
+		setup := &devtools.SetupState{
+			RandomSeed: *seedPrefix + "-" + strconv.Itoa(i),
+			Players:    devtools.SeatDevUsers(devUsers[:*players]),
+			Settings:   json.RawMessage(*settings),
+		}
+		update, err := runner.InitialState(setup)
+		if err != nil {
+			// a seed that crashes node counts as errored like one that throws
+			var gameError *devtools.GameError
+			if !errors.As(err, &gameError) && !runner.Exited() {
+				return err
+			}
+			if errored == 0 {
+				color.Printf("<yellow>initialState failed with seed %s:</> %s\n", setup.RandomSeed, strings.SplitN(err.Error(), "\n", 2)[0])
+			}
+			errored++
+			if runner.Exited() {
+				runner.Close()
+				if runner, err = devtools.NewRunnerForManifest(b.root, manifest); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		matched := true
+		for _, p := range predicates {
+			matched = matched && p.Match(update)
+		}
+		if !matched {
+			continue
+		}
+		found = append(found, setup.RandomSeed)
+		link, err := seedLink(*baseURL, *players, setup.RandomSeed, *settings)
+		if err != nil {
+			return err
+		}
+		color.Printf("<green>%-16s</> %s\n", setup.RandomSeed, link)
+		if *open {
+			if err := openBrowser(link); err != nil {
+				return err
+			}
+		}
+	}
+	if errored != 0 {
+		color.Printf("<yellow>initialState failed with %d seeds</>\n", errored)
+	}
+	if len(found) == 0 {
+		return fmt.Errorf("none of the seeds tried match")
+	}
+	if len(found) < *n {
+		color.Printf("<gray>Only %d seeds matched, raise -max to try more</>\n", len(found))
+	}
+	if *baseURL == "" {
+		color.Printf("<gray>Add a seed's parameters to the end of the URL bz run printed, or pass that URL with -url</>\n")
+	}
+	return nil
+}
+
+// seedLink is a link to the dev shell that starts a game with seed, as in
+// Links to a setup in the README. baseURL is the URL bz run printed, so its
+// access token is kept. Without one only the query is returned.
+func seedLink(baseURL string, players int, seed, settings string) (string, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return "", fmt.Errorf("-url: %w", err)
+	}
+	q := u.Query()
+	q.Set("players", strconv.Itoa(players))
+	q.Set("seed", seed)
+	if settings := strings.TrimSpace(settings); settings != "{}" {
+		q.Set("settings", settings)
+	}
+	u.RawQuery = q.Encode()
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	if baseURL == "" {
+		return "&" + u.RawQuery, nil
+	}
+	return u.String(), nil
+}
+
+func openBrowser(link string) error {
+	// #nosec G204
+	switch runtime.GOOS {
+	case "darwin":
+		return exec.Command("open", link).Start()
+	case "windows":
+		return exec.Command("rundll32", "url.dll,FileProtocolHandler", link).Start()
+	}
+	return exec.Command("xdg-open", link).Start()
+}
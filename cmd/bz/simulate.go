@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	devtools "github.com/boardzilla/boardzilla-devtools/internal"
+	"github.com/gookit/color"
+)
+
+// maxErrorsShown is how many distinct errors the simulation table lists.
+const maxErrorsShown = 10
+
+func (b *bz) simulate() error {
+	simulateCmd := flag.NewFlagSet("simulate", flag.ExitOnError)
+	root := simulateCmd.String("root", "", "game root")
+	skipBuild := simulateCmd.Bool("skip-build", false, "use the existing game build")
+	n := simulateCmd.Int("n", 100, "number of games to play")
+	playersFlag := simulateCmd.String("players", "", "player counts to cycle through, like 3, 2-4 or 2,4 (default the manifest's minimum to maximum)")
+	workers := simulateCmd.Int("workers", runtime.NumCPU(), "games played at once")
+	maxMoves := simulateCmd.Int("max-moves", 1000, "moves after which a game is counted as unfinished")
+	bot := simulateCmd.String("bot", "", "command run in the game root for each seat, see Bots (default random moves from the game's enumerateMoves export)")
+	settings := simulateCmd.String("settings", "{}", "game settings as JSON")
+	seedPrefix := simulateCmd.String("seed", "sim", "prefix of each game's random seed, which is followed by the game number")
+	csvFile := simulateCmd.String("csv", "", "write a row per game to this CSV file")
+	jsonFile := simulateCmd.String("json", "", "write the summary and every game to this JSON file")
+	if err := simulateCmd.Parse(os.Args[2:]); err != nil {
+		return err
+	}
+	if !json.Valid([]byte(*settings)) {
+		return fmt.Errorf("-settings must be JSON")
+	}
+	if *workers < 1 {
+		*workers = 1
+	}
+
+	runner, manifest, err := b.startRunner(*root, *skipBuild)
+	if err != nil {
+		return err
+	}
+	playerCounts, err := parsePlayerCounts(*playersFlag, manifest)
+	if err != nil {
+		runner.Close()
+		return err
+	}
+	devUsers, err := devtools.LoadDevUsers(b.root, manifest)
+	if err != nil {
+		runner.Close()
+		return err
+	}
+	botCommand := strings.Fields(*bot)
+	if len(botCommand) == 0 {
+		canEnumerate, err := runner.HasExport("enumerateMoves")
+		if err != nil {
+			runner.Close()
+			return err
+		}
+		if !canEnumerate {
+			runner.Close()
+			return fmt.Errorf("requires -bot <command> or the game to export enumerateMoves")
+		}
+		color.Printf("Simulating <bold>%d</> games with random moves on <bold>%d</> workers\n", *n, *workers)
+	} else {
+		color.Printf("Simulating <bold>%d</> games with <bold>%s</> on <bold>%d</> workers\n", *n, *bot, *workers)
+	}
+
+	jobs := make(chan int)
+	go func() {
+		for i := 0; i < *n; i++ {
+			jobs <- i
+		}
+		close(jobs)
+	}()
+	games := make([]*devtools.SimulatedGame, *n)
+	done := make(chan struct{}, *n)
+	wg := sync.WaitGroup{}
+	runners := []*devtools.Runner{runner}
+	for len(runners) < *workers {
+		r, err := devtools.NewRunnerForManifest(b.root, manifest)
+		if err != nil {
+			for _, r := range runners {
+				r.Close()
+			}
+			return err
+		}
+		runners = append(runners, r)
+	}
+	for _, workerRunner := range runners {
+		wg.Add(1)
+		go func(runner *devtools.Runner) {
+			defer wg.Done()
+			var bots *devtools.BotPicker
+			if len(botCommand) != 0 {
+				bots = &devtools.BotPicker{Dir: b.root, Command: botCommand}
+				defer bots.Close()
+			}
+			for i := range jobs {
+				players := playerCounts[i%len(playerCounts)]
+				setup := &devtools.SetupState{
+					RandomSeed: *seedPrefix + "-" + strconv.Itoa(i),
+					Players:    devtools.SeatDevUsers(devUsers[:players]),
+					Settings:   json.RawMessage(*settings),
+				}
+				rng := rand.New(rand.NewSource(int64(i))) // #nosec G404
+				var picker devtools.MovePicker = &devtools.RandomPicker{Runner: runner, Rand: rng}
+				if bots != nil {
+					picker = bots
+				}
+				game, playout := devtools.Simulate(runner, setup, picker, rng, *maxMoves)
+				if bots != nil && playout.Finished {
+					if err := bots.Finish(playout.Updates[len(playout.Updates)-1]); err != nil {
+						fmt.Printf("error: %#v\n", err)
+					}
+				}
+				games[i] = game
+				done <- struct{}{}
+				if runner.Exited() {
+					// the game crashed the runner, start another for the next game
+					runner.Close()
+					var err error
+					if runner, err = devtools.NewRunnerForManifest(b.root, manifest); err != nil {
+						fmt.Printf("error: %#v\n", err)
+						return
+					}
+				}
+			}
+			runner.Close()
+		}(workerRunner)
+	}
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	played := 0
+	for range done {
+		played++
+		if played%100 == 0 || played == *n {
+			fmt.Printf("\r%d/%d", played, *n)
+		}
+	}
+	fmt.Println()
+	if played != *n {
+		return fmt.Errorf("only %d of %d games could be played", played, *n)
+	}
+
+	summary := devtools.Summarize(games)
+	printSimulationSummary(summary)
+	if *csvFile != "" {
+		f, err := os.Create(*csvFile)
+		if err != nil {
+			return err
+		}
+		if err := devtools.WriteSimulationCSV(f, games, playerCounts[len(playerCounts)-1]); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		color.Printf("<gray>Wrote %s</>\n", *csvFile)
+	}
+	if *jsonFile != "" {
+		data, err := json.MarshalIndent(map[string]interface{}{"summary": summary, "games": games}, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(*jsonFile, append(data, '\n'), 0600); err != nil {
+			return err
+		}
+		color.Printf("<gray>Wrote %s</>\n", *jsonFile)
+	}
+	return nil
+}
+
+// parsePlayerCounts reads a list of player counts like 3, 2-4 or 2,4,
+// defaulting to every count the manifest allows. They are returned in
+// increasing order.
+func parsePlayerCounts(spec string, manifest *devtools.ManifestV1) ([]int, error) {
+	if spec == "" {
+		spec = fmt.Sprintf("%d-%d", manifest.MinimumPlayers, manifest.MaximumPlayers)
+	}
+	seen := map[int]bool{}
+	for _, part := range strings.Split(spec, ",") {
+		from, to, isRange := strings.Cut(strings.TrimSpace(part), "-")
+		if !isRange {
+			to = from
+		}
+		low, err := strconv.Atoi(from)
+		if err != nil {
+			return nil, fmt.Errorf("bad player count %q", part)
+		}
+		high, err := strconv.Atoi(to)
+		if err != nil {
+			return nil, fmt.Errorf("bad player count %q", part)
+		}
+		for players := low; players <= high; players++ {
+			if players < manifest.MinimumPlayers || players > manifest.MaximumPlayers {
+				return nil, fmt.Errorf("the game is for %d to %d players, not %d", manifest.MinimumPlayers, manifest.MaximumPlayers, players)
+			}
+			seen[players] = true
+		}
+	}
+	counts := []int{}
+	for players := manifest.MinimumPlayers; players <= manifest.MaximumPlayers; players++ {
+		if seen[players] {
+			counts = append(counts, players)
+		}
+	}
+	if len(counts) == 0 {
+		return nil, fmt.Errorf("no player counts in %q", spec)
+	}
+	return counts, nil
+}
+
+func printSimulationSummary(summary *devtools.SimulationSummary) {
+	color.Printf("\n<bold>%d</> games: <green>%d finished</>, <yellow>%d stopped at the move limit</>, <red>%d errors</>\n", summary.Games, summary.Finished, summary.Unfinished, summary.Errors)
+	if m := summary.MoveCounts; m != nil {
+		color.Printf("Moves per finished game: p10 %g  p50 %g  p90 %g  p99 %g  max %g\n", m.P10, m.P50, m.P90, m.P99, m.Max)
+	}
+	for _, pc := range summary.ByPlayers {
+		color.Printf("\n<bold>%d players</> <gray>(%d finished games, a fair win rate is %.1f%%)</>\n", pc.Players, pc.Finished, 100/float64(pc.Players))
+		fmt.Printf("  %-6s %6s %16s %10s %8s %8s %8s\n", "seat", "wins", "win rate", "mean score", "p10", "p50", "p90")
+		for _, seat := range pc.Seats {
+			winRate := fmt.Sprintf("%.1f%% ±%.1f", 100*seat.WinRate, 100*seat.WinRateMargin)
+			if seat.Scores == nil {
+				fmt.Printf("  %-6d %6d %16s %10s %8s %8s %8s\n", seat.Position, seat.Wins, winRate, "-", "-", "-", "-")
+				continue
+			}
+			fmt.Printf("  %-6d %6d %16s %10.2f %8g %8g %8g\n", seat.Position, seat.Wins, winRate, *seat.MeanScore, seat.Scores.P10, seat.Scores.P50, seat.Scores.P90)
+		}
+	}
+	if len(summary.ErrorCounts) != 0 {
+		color.Printf("\n<red>Errors</>\n")
+		for i, e := range summary.ErrorCounts {
+			if i == maxErrorsShown {
+				color.Printf("  <gray>and %d more</>\n", len(summary.ErrorCounts)-i)
+				break
+			}
+			fmt.Printf("  %6d  %s\n", e.Count, e.Error)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	devtools "github.com/boardzilla/boardzilla-devtools/internal"
+	"github.com/gookit/color"
+)
+
+const (
+	snapshotsDir      = ".bz/snapshots"
+	maxDiffsPerSource = 20
+)
+
+func (b *bz) snapshot() error {
+	snapshotCmd := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	root := snapshotCmd.String("root", "", "game root")
+	skipBuild := snapshotCmd.Bool("skip-build", false, "use the existing game build")
+	update := snapshotCmd.Bool("update", false, "write the current player views as the new snapshots")
+	dir := snapshotCmd.String("dir", snapshotsDir, "directory of snapshots, relative to the game root")
+	if err := snapshotCmd.Parse(os.Args[2:]); err != nil {
+		return err
+	}
+
+	runner, manifest, err := b.startRunner(*root, *skipBuild)
+	if err != nil {
+		return err
+	}
+	defer runner.Close()
+	devUsers, err := devtools.LoadDevUsers(b.root, manifest)
+	if err != nil {
+		return err
+	}
+
+	saveStatesPath := path.Join(b.root, ".save-states")
+	states, scenarios, err := replayInputs(snapshotCmd.Args(), saveStatesPath, path.Join(b.root, scenariosDir))
+	if err != nil {
+		return err
+	}
+	if len(states)+len(scenarios) == 0 {
+		return fmt.Errorf("no save states or scenarios to snapshot")
+	}
+
+	snapshotsPath := path.Join(b.root, *dir)
+	if err := os.MkdirAll(snapshotsPath, 0700); err != nil {
+		return err
+	}
+	type source struct {
+		golden string
+		take   func() (*devtools.Snapshot, error)
+	}
+	sources := []source{}
+	for _, name := range states {
+		name := name
+		sources = append(sources, source{"savestate-" + name + ".json", func() (*devtools.Snapshot, error) {
+			return devtools.SnapshotSaveState(runner, path.Join(saveStatesPath, name))
+		}})
+	}
+	for _, file := range scenarios {
+		file := file
+		sources = append(sources, source{"scenario-" + filepath.Base(file), func() (*devtools.Snapshot, error) {
+			return devtools.SnapshotScenario(runner, manifest, devUsers, file)
+		}})
+	}
+
+	changed := 0
+	for _, s := range sources {
+		snapshot, err := s.take()
+		if err != nil {
+			return err
+		}
+		current, err := snapshot.Marshal()
+		if err != nil {
+			return err
+		}
+		goldenPath := path.Join(snapshotsPath, s.golden)
+		golden, err := os.ReadFile(goldenPath) // #nosec G304
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err == nil && bytes.Equal(golden, current) {
+			color.Printf("<green>OK</>      <bold>%s</>\n", s.golden)
+			continue
+		}
+		if *update {
+			if err := os.WriteFile(goldenPath, current, 0600); err != nil {
+				return err
+			}
+			color.Printf("<cyan>UPDATED</> <bold>%s</>\n", s.golden)
+			continue
+		}
+		changed++
+		if os.IsNotExist(err) {
+			color.Printf("<red>MISSING</> <bold>%s</>\n", s.golden)
+			continue
+		}
+		diffs, err := devtools.DiffSnapshots(golden, current)
+		if err != nil {
+			return fmt.Errorf("%s: %w", s.golden, err)
+		}
+		color.Printf("<red>CHANGED</> <bold>%s</>\n", s.golden)
+		for i, d := range diffs {
+			if i == maxDiffsPerSource {
+				color.Printf("        <gray>and %d more</>\n", len(diffs)-i)
+				break
+			}
+			color.Printf("        %s\n", d)
+		}
+	}
+	if changed != 0 {
+		return fmt.Errorf("%d of %d snapshots differ, check the changes and run with -update to accept them", changed, len(sources))
+	}
+	return nil
+}
+
+// replayInputs splits args into the names of save states in saveStatesPath
+// and scenario files or directories. Without args it is every save state
+// and every scenario in scenariosPath.
+func replayInputs(args []string, saveStatesPath, scenariosPath string) ([]string, []string, error) {
+	if len(args) == 0 {
+		states, err := saveStateNames(saveStatesPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		scenarios, err := jsonFiles(nil, scenariosPath)
+		return states, scenarios, err
+	}
+	states := []string{}
+	scenarioArgs := []string{}
+	for _, arg := range args {
+		if info, err := os.Stat(path.Join(saveStatesPath, arg)); err == nil && !info.IsDir() {
+			states = append(states, arg)
+		} else {
+			scenarioArgs = append(scenarioArgs, arg)
+		}
+	}
+	if len(scenarioArgs) == 0 {
+		return states, nil, nil
+	}
+	scenarios, err := jsonFiles(scenarioArgs, scenariosPath)
+	return states, scenarios, err
+}
+
+// saveStateNames lists the save states in saveStatesPath.
+func saveStateNames(saveStatesPath string) ([]string, error) {
+	entries, err := os.ReadDir(saveStatesPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, e := range entries {
+		if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		names = append(names, e.Name())
+	}
+	return names, nil
+}
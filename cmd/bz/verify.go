@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	devtools "github.com/boardzilla/boardzilla-devtools/internal"
+	"github.com/gookit/color"
+)
+
+func (b *bz) verify() error {
+	verifyCmd := flag.NewFlagSet("verify", flag.ExitOnError)
+	root := verifyCmd.String("root", "", "game root")
+	skipBuild := verifyCmd.Bool("skip-build", false, "use the existing game build")
+	settings := verifyCmd.String("settings", "{}", "game settings as JSON")
+	seed := verifyCmd.String("seed", "verify", "random seed of the setups")
+	if err := verifyCmd.Parse(os.Args[2:]); err != nil {
+		return err
+	}
+	if !json.Valid([]byte(*settings)) {
+		return fmt.Errorf("-settings must be JSON")
+	}
+
+	runner, manifest, err := b.startRunner(*root, *skipBuild)
+	if err != nil {
+		return err
+	}
+	defer func() { runner.Close() }()
+	devUsers, err := devtools.LoadDevUsers(b.root, manifest)
+	if err != nil {
+		return err
+	}
+
+	failures := 0
+	exports, exportsCheck := devtools.VerifyExports(runner)
+	if !exportsCheck.Passed() {
+		for _, problem := range exportsCheck.Problems {
+			color.Printf("<red>✗</> %s\n", problem)
+		}
+		if exports == nil || len(exports.Exports) == 0 {
+			return fmt.Errorf("game.js does not export the game")
+		}
+		failures += len(exportsCheck.Problems)
+	} else {
+		where := "game"
+		if exports.ViaDefault {
+			where = "game.default"
+		}
+		color.Printf("<green>✓</> game.js exports %s on %s\n", strings.Join(exports.Exports, ", "), where)
+	}
+
+	for players := manifest.MinimumPlayers; players <= manifest.MaximumPlayers; players++ {
+		setup := &devtools.SetupState{
+			RandomSeed: *seed,
+			Players:    devtools.SeatDevUsers(devUsers[:players]),
+			Settings:   json.RawMessage(*settings),
+		}
+		checks, err := devtools.VerifySetup(runner, exports, setup)
+		if err != nil {
+			return fmt.Errorf("%d players: %w", players, err)
+		}
+		color.Printf("\n<bold>%d players</>\n", players)
+		for _, c := range checks {
+			switch {
+			case !c.Passed():
+				failures += len(c.Problems)
+				color.Printf("  <red>✗</> %s\n", c.Name)
+				for _, problem := range c.Problems {
+					color.Printf("      %s\n", problem)
+				}
+			case c.Skipped != "":
+				color.Printf("  <gray>- %s skipped, %s</>\n", c.Name, c.Skipped)
+			default:
+				color.Printf("  <green>✓</> %s\n", c.Name)
+			}
+		}
+		if runner.Exited() {
+			// the game crashed the runner, start another for the next count
+			runner.Close()
+			if runner, err = devtools.NewRunnerForManifest(b.root, manifest); err != nil {
+				return err
+			}
+		}
+	}
+	fmt.Println()
+	if failures != 0 {
+		return fmt.Errorf("found %d problems with the game artifact", failures)
+	}
+	color.Printf("The game artifact meets the contract ✅\n")
+	return nil
+}
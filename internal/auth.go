@@ -0,0 +1,89 @@
+package internal
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+)
+
+const (
+	tokenHeader = "X-Bz-Token"
+	tokenCookie = "bz_token"
+	tokenParam  = "token"
+)
+
+func newAccessToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func (s *Server) validToken(t string) bool {
+	return t != "" && subtle.ConstantTimeCompare([]byte(t), []byte(s.token)) == 1
+}
+
+func (s *Server) requestToken(r *http.Request) string {
+	if t := r.Header.Get(tokenHeader); t != "" {
+		return t
+	}
+	if c, err := r.Cookie(tokenCookie); err == nil {
+		return c.Value
+	}
+	return r.URL.Query().Get(tokenParam)
+}
+
+// rememberToken stores a valid token passed in the query string as a
+// cookie so the shell's own requests carry it from then on.
+func (s *Server) rememberToken(w http.ResponseWriter, r *http.Request) {
+	t := r.URL.Query().Get(tokenParam)
+	if !s.validToken(t) {
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     tokenCookie,
+		Value:    t,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   s.certs != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// requireToken rejects any request that can change server state unless it
+// carries the access token printed at startup.
+func (s *Server) requireToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			if !s.validToken(s.requestToken(r)) {
+				http.Error(w, "missing or invalid access token", http.StatusUnauthorized)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// sameOrigin refuses cross-origin requests. No Access-Control-Allow-Origin
+// header is sent outside of the frames' static assets, so browsers will also
+// block reading responses from another origin.
+func sameOrigin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || requestFrame(r) != 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		u, err := url.Parse(origin)
+		if err != nil || u.Host != r.Host {
+			http.Error(w, "cross-origin requests are not allowed", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
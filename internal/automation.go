@@ -0,0 +1,460 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gookit/color"
+)
+
+// automation backs the /api/session endpoints. A session being set up is
+// kept here until it starts, after that the journal is the session so the
+// API and the shell can both make moves in the same game, until the shell
+// sets up another or leaves it.
+type automation struct {
+	runner *Runner
+	draft  *SetupState
+	lock   sync.Mutex
+}
+
+type sessionResponse struct {
+	// Phase is "new" while setting up, then "started" or "finished".
+	Phase          string          `json:"phase"`
+	RandomSeed     string          `json:"randomSeed"`
+	Settings       json.RawMessage `json:"settings"`
+	Players        []*Player       `json:"players"`
+	HistoryLength  int             `json:"historyLength"`
+	CurrentPlayers []int           `json:"currentPlayers,omitempty"`
+	Winners        []int           `json:"winners,omitempty"`
+	// State is the latest GameUpdate once started.
+	State json.RawMessage `json:"state,omitempty"`
+}
+
+type sessionUpdatedEvent struct {
+	Type string `json:"type"`
+}
+
+type gameStatus struct {
+	Phase          string `json:"phase"`
+	CurrentPlayers []int  `json:"currentPlayers"`
+	Winners        []int  `json:"winners"`
+}
+
+// gameRunner starts the headless runner on first use. It is dropped when the
+// game is rebuilt so the next call picks up the new build.
+func (a *automation) gameRunner(s *Server) (*Runner, error) {
+	if a.runner == nil {
+		runner, err := NewRunnerForManifest(s.gameRoot, s.manifest)
+		if err != nil {
+			return nil, err
+		}
+		runner.Profile = s.profile
+		a.runner = runner
+	}
+	return a.runner, nil
+}
+
+func (a *automation) resetRunner() {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.runner != nil {
+		if err := a.runner.Close(); err != nil {
+			fmt.Printf("error: %#v\n", err)
+		}
+		a.runner = nil
+	}
+}
+
+// latestUpdate is the GameUpdate the session is currently at.
+func latestUpdate(saveState *SaveStateData) json.RawMessage {
+	if len(saveState.History) == 0 {
+		return saveState.InitialState.State
+	}
+	return saveState.History[len(saveState.History)-1].State
+}
+
+func (s *Server) seatDevUsers(userIDs []string) ([]*Player, error) {
+	users := []*DevUser{}
+	for _, id := range userIDs {
+		u := s.devUser(id)
+		if u == nil {
+			return nil, fmt.Errorf("no dev user with id %q", id)
+		}
+		users = append(users, u)
+	}
+	return SeatDevUsers(users), nil
+}
+
+func (s *Server) sessionState() (*sessionResponse, error) {
+	if draft := s.automation.draft; draft != nil {
+		return &sessionResponse{
+			Phase:      "new",
+			RandomSeed: draft.RandomSeed,
+			Settings:   draft.Settings,
+			Players:    draft.Players,
+		}, nil
+	}
+	// once the shell has left a session it is no longer played
+	saveState, err := s.journal.Current()
+	if err != nil {
+		return nil, err
+	}
+	update := latestUpdate(saveState)
+	var gameUpdate struct {
+		Game gameStatus `json:"game"`
+	}
+	if err := json.Unmarshal(update, &gameUpdate); err != nil {
+		return nil, err
+	}
+	return &sessionResponse{
+		Phase:          gameUpdate.Game.Phase,
+		RandomSeed:     saveState.RandomSeed,
+		Settings:       saveState.Settings,
+		Players:        saveState.Players,
+		HistoryLength:  len(saveState.History),
+		CurrentPlayers: gameUpdate.Game.CurrentPlayers,
+		Winners:        gameUpdate.Game.Winners,
+		State:          update,
+	}, nil
+}
+
+func (s *Server) writeSession(w http.ResponseWriter, status int) {
+	session, err := s.sessionState()
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.Error(w, "no session, create one with POST /api/session", http.StatusNotFound)
+			return
+		}
+		fmt.Printf("error: %#v\n", err)
+		w.WriteHeader(500)
+		return
+	}
+	w.Header().Add("Content-type", "application/json")
+	w.Header().Add("Cache-control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(session); err != nil {
+		fmt.Printf("error: %#v\n", err)
+	}
+}
+
+// withDraft runs f on the session being set up, refusing if it has
+// already started.
+func (s *Server) withDraft(w http.ResponseWriter, f func(draft *SetupState) error) {
+	s.automation.lock.Lock()
+	defer s.automation.lock.Unlock()
+	if s.automation.draft == nil {
+		http.Error(w, "the session has started, create a new one with POST /api/session", http.StatusConflict)
+		return
+	}
+	if err := f(s.automation.draft); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.writeSession(w, 200)
+}
+
+func (s *Server) automationRoutes(r chi.Router) {
+	r.Get("/session", func(w http.ResponseWriter, r *http.Request) {
+		s.automation.lock.Lock()
+		defer s.automation.lock.Unlock()
+		s.writeSession(w, 200)
+	})
+
+	r.Post("/session", func(w http.ResponseWriter, r *http.Request) {
+		var createRequest struct {
+			Players    *int            `json:"players"`
+			UserIDs    []string        `json:"userIDs"`
+			RandomSeed string          `json:"randomSeed"`
+			Settings   json.RawMessage `json:"settings"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&createRequest); err != nil && err != io.EOF {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		userIDs := createRequest.UserIDs
+		if userIDs == nil {
+			count := s.manifest.DefaultPlayers
+			if count == 0 {
+				count = s.manifest.MinimumPlayers
+			}
+			if createRequest.Players != nil {
+				count = *createRequest.Players
+			}
+			if count < 0 || count > len(s.devUsers) {
+				http.Error(w, fmt.Sprintf("players must be between 0 and %d", len(s.devUsers)), http.StatusBadRequest)
+				return
+			}
+			for _, u := range s.devUsers[:count] {
+				userIDs = append(userIDs, u.ID)
+			}
+		}
+		players, err := s.seatDevUsers(userIDs)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if createRequest.RandomSeed == "" {
+			// the same form of seed the shell generates
+			createRequest.RandomSeed = strconv.FormatFloat(rand.Float64(), 'f', -1, 64) // #nosec G404
+		}
+		if createRequest.Settings == nil {
+			createRequest.Settings = json.RawMessage("{}")
+		}
+		s.automation.lock.Lock()
+		defer s.automation.lock.Unlock()
+		s.automation.draft = &SetupState{
+			RandomSeed: createRequest.RandomSeed,
+			Players:    players,
+			Settings:   createRequest.Settings,
+		}
+		s.writeSession(w, 201)
+	})
+
+	r.Put("/session/players", func(w http.ResponseWriter, r *http.Request) {
+		var playersRequest struct {
+			UserIDs []string `json:"userIDs"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&playersRequest); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.withDraft(w, func(draft *SetupState) error {
+			players, err := s.seatDevUsers(playersRequest.UserIDs)
+			if err != nil {
+				return err
+			}
+			draft.Players = players
+			return nil
+		})
+	})
+
+	r.Put("/session/settings", func(w http.ResponseWriter, r *http.Request) {
+		settings := json.RawMessage{}
+		if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.withDraft(w, func(draft *SetupState) error {
+			draft.Settings = settings
+			return nil
+		})
+	})
+
+	r.Put("/session/seed", func(w http.ResponseWriter, r *http.Request) {
+		var seedRequest struct {
+			RandomSeed string `json:"randomSeed"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&seedRequest); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.withDraft(w, func(draft *SetupState) error {
+			if seedRequest.RandomSeed == "" {
+				return fmt.Errorf("requires randomSeed")
+			}
+			draft.RandomSeed = seedRequest.RandomSeed
+			return nil
+		})
+	})
+
+	r.Post("/session/start", func(w http.ResponseWriter, r *http.Request) {
+		s.automation.lock.Lock()
+		defer s.automation.lock.Unlock()
+		draft := s.automation.draft
+		if draft == nil {
+			http.Error(w, "the session has started, create a new one with POST /api/session", http.StatusConflict)
+			return
+		}
+		if len(draft.Players) < s.manifest.MinimumPlayers || len(draft.Players) > s.manifest.MaximumPlayers {
+			http.Error(w, fmt.Sprintf("requires between %d and %d players", s.manifest.MinimumPlayers, s.manifest.MaximumPlayers), http.StatusBadRequest)
+			return
+		}
+		runner, err := s.automation.gameRunner(s)
+		if err != nil {
+			fmt.Printf("error: %#v\n", err)
+			w.WriteHeader(500)
+			return
+		}
+		update, err := runner.InitialState(draft)
+		if err != nil {
+			s.writeRunnerError(w, err)
+			return
+		}
+		entry := &JournalEntry{
+			Type:             "setup",
+			GameStateVersion: &s.manifest.StateVersion,
+			RandomSeed:       draft.RandomSeed,
+			Settings:         draft.Settings,
+			Players:          draft.Players,
+			InitialState: &InitialStateHistoryItem{
+				State:    update,
+				Players:  draft.Players,
+				Settings: draft.Settings,
+			},
+		}
+		if err := s.journal.Record(entry); err != nil {
+			fmt.Printf("error: %#v\n", err)
+			w.WriteHeader(500)
+			return
+		}
+		s.checkInvariants(entry)
+		s.checkStateSize(entry)
+		s.automation.draft = nil
+		color.Printf("🤖 Session started with <bold>%d</> players\n", len(draft.Players))
+		s.broadcast(&sessionUpdatedEvent{Type: "sessionUpdated"})
+		s.bots.poke()
+		s.writeSession(w, 201)
+	})
+
+	r.Post("/session/moves", func(w http.ResponseWriter, r *http.Request) {
+		move := &Move{}
+		if err := json.NewDecoder(r.Body).Decode(move); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.automation.lock.Lock()
+		defer s.automation.lock.Unlock()
+		if s.automation.draft != nil {
+			http.Error(w, "the session has not started, start it with POST /api/session/start", http.StatusConflict)
+			return
+		}
+		session, err := s.sessionState()
+		if err != nil {
+			if os.IsNotExist(err) {
+				http.Error(w, "no session, create one with POST /api/session", http.StatusNotFound)
+				return
+			}
+			fmt.Printf("error: %#v\n", err)
+			w.WriteHeader(500)
+			return
+		}
+		if session.Phase == "finished" {
+			http.Error(w, "the game is finished", http.StatusConflict)
+			return
+		}
+		if err := s.recordMove(session, move); err != nil {
+			s.writeRunnerError(w, err)
+			return
+		}
+		s.writeSession(w, 201)
+	})
+
+	r.Get("/session/players/{position}/state", func(w http.ResponseWriter, r *http.Request) {
+		position, err := strconv.Atoi(chi.URLParam(r, "position"))
+		if err != nil {
+			http.Error(w, "position must be a number", http.StatusBadRequest)
+			return
+		}
+		s.automation.lock.Lock()
+		defer s.automation.lock.Unlock()
+		if s.automation.draft != nil {
+			http.Error(w, "the session has not started, start it with POST /api/session/start", http.StatusConflict)
+			return
+		}
+		session, err := s.sessionState()
+		if err != nil {
+			if os.IsNotExist(err) {
+				http.Error(w, "no session, create one with POST /api/session", http.StatusNotFound)
+				return
+			}
+			fmt.Printf("error: %#v\n", err)
+			w.WriteHeader(500)
+			return
+		}
+		var update struct {
+			Players []json.RawMessage `json:"players"`
+		}
+		if err := json.Unmarshal(session.State, &update); err != nil {
+			fmt.Printf("error: %#v\n", err)
+			w.WriteHeader(500)
+			return
+		}
+		for _, p := range update.Players {
+			var playerState struct {
+				Position int `json:"position"`
+			}
+			if err := json.Unmarshal(p, &playerState); err != nil {
+				fmt.Printf("error: %#v\n", err)
+				w.WriteHeader(500)
+				return
+			}
+			if playerState.Position == position {
+				w.Header().Add("Content-type", "application/json")
+				w.Header().Add("Cache-control", "no-store")
+				if _, err := w.Write(p); err != nil {
+					fmt.Printf("error: %#v\n", err)
+				}
+				return
+			}
+		}
+		http.Error(w, fmt.Sprintf("no player state for position %d", position), http.StatusNotFound)
+	})
+}
+
+// recordMove makes move in the session with the headless runner and records
+// it for the shell to pick up. The caller holds the automation lock.
+func (s *Server) recordMove(session *sessionResponse, move *Move) error {
+	var previous struct {
+		Game json.RawMessage `json:"game"`
+	}
+	if err := json.Unmarshal(session.State, &previous); err != nil {
+		return err
+	}
+	runner, err := s.automation.gameRunner(s)
+	if err != nil {
+		return err
+	}
+	update, err := runner.ProcessMove(previous.Game, move)
+	if err != nil {
+		return err
+	}
+	entry := &JournalEntry{
+		Type: "move",
+		Move: &HistoryItem{
+			Seq:      session.HistoryLength,
+			State:    update,
+			Data:     move.Data,
+			Position: move.Position,
+		},
+	}
+	if err := s.journal.Record(entry); err != nil {
+		return err
+	}
+	s.checkInvariants(entry)
+	s.checkStateSize(entry)
+	s.broadcast(&sessionUpdatedEvent{Type: "sessionUpdated"})
+	s.bots.poke()
+	return nil
+}
+
+// writeRunnerError reports the game throwing as a client error so tests can
+// assert on illegal moves, anything else is the server's fault. The caller
+// holds s.automation.lock.
+func (s *Server) writeRunnerError(w http.ResponseWriter, err error) {
+	var gameError *GameError
+	if errors.As(err, &gameError) {
+		w.Header().Add("Content-type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		if err := json.NewEncoder(w).Encode(map[string]string{"error": gameError.Message}); err != nil {
+			fmt.Printf("error: %#v\n", err)
+		}
+		return
+	}
+	// most likely node exited, start a new one next time
+	fmt.Printf("error: %#v\n", err)
+	if s.automation.runner != nil {
+		if err := s.automation.runner.Close(); err != nil {
+			fmt.Printf("error: %#v\n", err)
+		}
+		s.automation.runner = nil
+	}
+	w.WriteHeader(500)
+}
@@ -0,0 +1,320 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gookit/color"
+)
+
+const (
+	// botThinkTime is how long a bot waits before moving, so its moves can
+	// be followed in the shell.
+	botThinkTime = 500 * time.Millisecond
+	// botTimeout is how long a bot has to reply before it is restarted.
+	botTimeout = 10 * time.Second
+	// botAttempts is how many moves a bot may try before giving up its turn.
+	botAttempts = 3
+)
+
+// BotEvent is a line sent to a bot, shaped like the GameUpdateEvent and
+// GameFinishedEvent the UI receives.
+type BotEvent struct {
+	Type           string          `json:"type"`
+	State          json.RawMessage `json:"state"`
+	Position       int             `json:"position"`
+	CurrentPlayers []int           `json:"currentPlayers,omitempty"`
+	Winners        []int           `json:"winners,omitempty"`
+	// Error is why the game rejected the bot's last move, which it should
+	// replace with another.
+	Error string `json:"error,omitempty"`
+}
+
+// botReply is the line a bot answers each gameUpdate with.
+type botReply struct {
+	Data json.RawMessage `json:"data"`
+}
+
+// Bot is a running bot process, speaking JSON lines over stdin and stdout.
+type Bot struct {
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	lines   chan []byte
+	stopped chan struct{}
+	// exitErr is how the bot exited, set before lines is closed
+	exitErr error
+}
+
+// bots plays the seats taken by dev users with a bot command, starting
+// each bot when it is first needed and again whenever it fails.
+type bots struct {
+	running map[string]*Bot
+	pokes   chan struct{}
+	// finished is the last finished GameUpdate bots were told about.
+	finished json.RawMessage
+	lock     sync.Mutex
+}
+
+func newBots() *bots {
+	return &bots{
+		running: map[string]*Bot{},
+		pokes:   make(chan struct{}, 1),
+		lock:    sync.Mutex{},
+	}
+}
+
+// poke asks the bots to look at the session again after it changed.
+func (b *bots) poke() {
+	select {
+	case b.pokes <- struct{}{}:
+	default:
+	}
+}
+
+func (b *bots) run(s *Server) {
+	for range b.pokes {
+		b.step(s)
+	}
+}
+
+// step makes one move if a bot is to play. Making it pokes again, so the
+// bots keep playing while it is their turn.
+func (b *bots) step(s *Server) {
+	s.automation.lock.Lock()
+	session, err := s.sessionState()
+	s.automation.lock.Unlock()
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Printf("error: %#v\n", err)
+		}
+		return
+	}
+	switch session.Phase {
+	case "finished":
+		b.finish(s, session)
+		return
+	case "started":
+	default:
+		return
+	}
+	for _, position := range session.CurrentPlayers {
+		user, view := b.seat(s, session, position)
+		if user != nil {
+			b.play(s, user, session, position, view)
+			return
+		}
+	}
+}
+
+// seat is the bot user at position and the player state it sees, nil if
+// position is played by a person.
+func (b *bots) seat(s *Server, session *sessionResponse, position int) (*DevUser, json.RawMessage) {
+	for _, p := range session.Players {
+		if p.Position != position {
+			continue
+		}
+		user := s.devUser(p.ID)
+		if user == nil || len(user.Bot) == 0 {
+			return nil, nil
+		}
+		views, err := PlayerViews(session.State)
+		if err != nil {
+			fmt.Printf("error: %#v\n", err)
+			return nil, nil
+		}
+		return user, views[fmt.Sprint(position)]
+	}
+	return nil, nil
+}
+
+func (b *bots) play(s *Server, user *DevUser, session *sessionResponse, position int, view json.RawMessage) {
+	time.Sleep(botThinkTime)
+	event := &BotEvent{Type: "gameUpdate", State: view, Position: position, CurrentPlayers: session.CurrentPlayers}
+	for attempt := 0; attempt < botAttempts; attempt++ {
+		data, err := b.ask(s.gameRoot, user, event)
+		if err != nil {
+			color.Printf("<red>🤖 %s failed:</> %s\n", user.Name, err)
+			b.stop(user.ID)
+			continue
+		}
+		s.automation.lock.Lock()
+		current, err := s.sessionState()
+		if err == nil && !bytes.Equal(current.State, session.State) {
+			// someone moved while the bot was thinking, it will be asked
+			// again if it is still its turn
+			s.automation.lock.Unlock()
+			return
+		}
+		if err == nil {
+			err = s.recordMove(current, &Move{Position: position, Data: data})
+		}
+		s.automation.lock.Unlock()
+		var gameError *GameError
+		if errors.As(err, &gameError) {
+			event.Error = firstLine(gameError.Message)
+			color.Printf("<yellow>🤖 %s's move was rejected:</> %s\n", user.Name, event.Error)
+			continue
+		}
+		if err != nil {
+			fmt.Printf("error: %#v\n", err)
+			return
+		}
+		color.Printf("🤖 <bold>%s</> moved <gray>%s</>\n", user.Name, compactJSON(data))
+		return
+	}
+	color.Printf("<red>🤖 %s gave up after %d attempts, move for position %d to continue</>\n", user.Name, botAttempts, position)
+}
+
+// finish tells the running bots in a session that it is over.
+func (b *bots) finish(s *Server, session *sessionResponse) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if bytes.Equal(b.finished, session.State) {
+		return
+	}
+	b.finished = session.State
+	views, err := PlayerViews(session.State)
+	if err != nil {
+		fmt.Printf("error: %#v\n", err)
+		return
+	}
+	for _, p := range session.Players {
+		bot := b.running[p.ID]
+		if bot == nil {
+			continue
+		}
+		event := &BotEvent{Type: "gameFinished", State: views[fmt.Sprint(p.Position)], Position: p.Position, Winners: session.Winners}
+		if err := bot.Send(event); err != nil {
+			fmt.Printf("error: %#v\n", err)
+		}
+	}
+}
+
+// ask sends event to the user's bot, starting it if needed, and waits for
+// the move it replies with.
+func (b *bots) ask(gameRoot string, user *DevUser, event *BotEvent) (json.RawMessage, error) {
+	b.lock.Lock()
+	bot := b.running[user.ID]
+	if bot == nil {
+		color.Printf("🤖 Starting bot for <bold>%s</> <gray>%s</>\n", user.Name, strings.Join(user.Bot, " "))
+		var err error
+		if bot, err = StartBot(gameRoot, user.Bot); err != nil {
+			b.lock.Unlock()
+			return nil, err
+		}
+		b.running[user.ID] = bot
+	}
+	b.lock.Unlock()
+	return bot.Move(event)
+}
+
+// stop kills the user's bot, it is started again when next needed.
+func (b *bots) stop(userID string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if bot := b.running[userID]; bot != nil {
+		delete(b.running, userID)
+		bot.Close()
+	}
+}
+
+func (b *bots) close() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for id, bot := range b.running {
+		delete(b.running, id)
+		bot.Close()
+	}
+}
+
+// StartBot runs command in dir as a bot. What it writes to stderr goes to
+// ours.
+func StartBot(dir string, command []string) (*Bot, error) {
+	cmd := exec.Command(command[0], command[1:]...) // #nosec G204
+	cmd.Dir = dir
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("unable to start %s: %w", command[0], err)
+	}
+	bot := &Bot{
+		cmd:     cmd,
+		stdin:   stdin,
+		lines:   make(chan []byte, 16),
+		stopped: make(chan struct{}),
+	}
+	go func() {
+		defer close(bot.lines)
+		// the process is reaped here and only here, once its output is done
+		defer func() { bot.exitErr = cmd.Wait() }()
+		scanner := bufio.NewScanner(stdout)
+		scanner.Buffer(nil, 1024*1024)
+		for scanner.Scan() {
+			select {
+			case bot.lines <- append([]byte{}, scanner.Bytes()...):
+			case <-bot.stopped:
+				return
+			}
+		}
+	}()
+	return bot, nil
+}
+
+// Move sends a gameUpdate and waits for the move data the bot replies with.
+func (b *Bot) Move(event *BotEvent) (json.RawMessage, error) {
+	// anything left over was meant for an earlier event
+	for len(b.lines) > 0 {
+		<-b.lines
+	}
+	if err := b.Send(event); err != nil {
+		return nil, err
+	}
+	select {
+	case line, ok := <-b.lines:
+		if !ok {
+			return nil, fmt.Errorf("exited: %v", b.exitErr)
+		}
+		reply := &botReply{}
+		if err := json.Unmarshal(line, reply); err != nil || reply.Data == nil {
+			return nil, fmt.Errorf(`replies must be {"data": <move data>}, got %s`, strings.TrimSpace(string(line)))
+		}
+		return reply.Data, nil
+	case <-time.After(botTimeout):
+		return nil, fmt.Errorf("no reply within %s", botTimeout)
+	}
+}
+
+// Send writes an event the bot isn't expected to reply to.
+func (b *Bot) Send(event *BotEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = b.stdin.Write(append(line, '\n'))
+	return err
+}
+
+// Close kills the bot.
+func (b *Bot) Close() {
+	close(b.stopped)
+	b.stdin.Close()
+	if err := b.cmd.Process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		fmt.Printf("error: %#v\n", err)
+	}
+}
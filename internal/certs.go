@@ -0,0 +1,247 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+const (
+	caCertFile     = "ca.pem"
+	caKeyFile      = "ca-key.pem"
+	serverCertFile = "cert.pem"
+	serverKeyFile  = "key.pem"
+
+	caValidity     = 10 * 365 * 24 * time.Hour
+	serverValidity = 365 * 24 * time.Hour
+	renewBefore    = 30 * 24 * time.Hour
+)
+
+// Certificates is a locally generated CA and a server certificate signed
+// by it, cached in Dir between runs.
+type Certificates struct {
+	Dir        string
+	CACertPath string
+	CACertPEM  []byte
+	// Created is set when the CA did not exist and had to be generated, in
+	// which case it will need to be trusted again.
+	Created bool
+
+	server tls.Certificate
+}
+
+// CertificatesDir is where certificates are cached by default.
+func CertificatesDir() (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return path.Join(configDir, "boardzilla", "certs"), nil
+}
+
+// LoadOrCreateCertificates loads the CA and server certificate from dir,
+// creating the CA if it is missing and reissuing the server certificate if
+// it is missing, about to expire, or does not cover every host in hosts.
+func LoadOrCreateCertificates(dir string, hosts []string) (*Certificates, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	caCert, caKey, created, err := loadOrCreateCA(dir)
+	if err != nil {
+		return nil, fmt.Errorf("certificate authority: %w", err)
+	}
+	server, err := loadServerCertificate(dir, caCert, hosts)
+	if err != nil {
+		server, err = createServerCertificate(dir, caCert, caKey, hosts)
+		if err != nil {
+			return nil, fmt.Errorf("server certificate: %w", err)
+		}
+	}
+	return &Certificates{
+		Dir:        dir,
+		CACertPath: path.Join(dir, caCertFile),
+		CACertPEM:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw}),
+		Created:    created,
+		server:     server,
+	}, nil
+}
+
+func (c *Certificates) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{c.server},
+	}
+}
+
+func loadOrCreateCA(dir string) (*x509.Certificate, *ecdsa.PrivateKey, bool, error) {
+	certPEM, certErr := os.ReadFile(filepath.Clean(path.Join(dir, caCertFile)))
+	keyPEM, keyErr := os.ReadFile(filepath.Clean(path.Join(dir, caKeyFile)))
+	if certErr == nil && keyErr == nil {
+		cert, key, err := parseKeyPair(certPEM, keyPEM)
+		if err == nil && time.Now().Add(renewBefore).Before(cert.NotAfter) {
+			return cert, key, false, nil
+		}
+	} else if certErr != nil && !os.IsNotExist(certErr) {
+		return nil, nil, false, certErr
+	} else if keyErr != nil && !os.IsNotExist(keyErr) {
+		return nil, nil, false, keyErr
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, nil, false, err
+	}
+	hostname, _ := os.Hostname()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Organization: []string{"Boardzilla devtools"},
+			CommonName:   fmt.Sprintf("Boardzilla dev CA (%s)", hostname),
+		},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if err := writeKeyPair(dir, caCertFile, caKeyFile, der, key); err != nil {
+		return nil, nil, false, err
+	}
+	return cert, key, true, nil
+}
+
+func loadServerCertificate(dir string, ca *x509.Certificate, hosts []string) (tls.Certificate, error) {
+	certPath := filepath.Clean(path.Join(dir, serverCertFile))
+	keyPath := filepath.Clean(path.Join(dir, serverKeyFile))
+	server, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return server, err
+	}
+	leaf, err := x509.ParseCertificate(server.Certificate[0])
+	if err != nil {
+		return server, err
+	}
+	if err := leaf.CheckSignatureFrom(ca); err != nil {
+		return server, err
+	}
+	if !time.Now().Add(renewBefore).Before(leaf.NotAfter) {
+		return server, fmt.Errorf("expiring")
+	}
+	for _, h := range hosts {
+		if err := leaf.VerifyHostname(h); err != nil {
+			return server, err
+		}
+	}
+	return server, nil
+}
+
+func createServerCertificate(dir string, ca *x509.Certificate, caKey *ecdsa.PrivateKey, hosts []string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Organization: []string{"Boardzilla devtools"},
+			CommonName:   "Boardzilla dev server",
+		},
+		NotBefore:   time.Now().Add(-time.Hour),
+		NotAfter:    time.Now().Add(serverValidity),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	if err := writeKeyPair(dir, serverCertFile, serverKeyFile, der, key); err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.LoadX509KeyPair(path.Join(dir, serverCertFile), path.Join(dir, serverKeyFile))
+}
+
+func parseKeyPair(certPEM, keyPEM []byte) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	certBlock, _ := pem.Decode(certPEM)
+	keyBlock, _ := pem.Decode(keyPEM)
+	if certBlock == nil || keyBlock == nil {
+		return nil, nil, fmt.Errorf("invalid pem")
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := x509.ParseECPrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	pub, ok := cert.PublicKey.(*ecdsa.PublicKey)
+	if !ok || !pub.Equal(&key.PublicKey) {
+		return nil, nil, fmt.Errorf("key does not match certificate")
+	}
+	return cert, key, nil
+}
+
+func writeKeyPair(dir, certFile, keyFile string, der []byte, key *ecdsa.PrivateKey) error {
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path.Join(dir, keyFile), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return err
+	}
+	return os.WriteFile(path.Join(dir, certFile), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+}
+
+func randomSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+// TrustInstructions explains how to trust the CA on this machine and on
+// other devices.
+func (c *Certificates) TrustInstructions(caURL string) string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "To trust the dev CA at %s:\n", c.CACertPath)
+	fmt.Fprintf(&b, "  macOS:   sudo security add-trusted-cert -d -r trustRoot -k /Library/Keychains/System.keychain %q\n", c.CACertPath)
+	fmt.Fprintf(&b, "  Linux:   sudo cp %q /usr/local/share/ca-certificates/boardzilla-dev.crt && sudo update-ca-certificates\n", c.CACertPath)
+	fmt.Fprintf(&b, "  Windows: certutil -addstore -f ROOT %q\n", c.CACertPath)
+	fmt.Fprintf(&b, "  Phones:  open %s on the device, install the profile, then enable full trust for it\n", caURL)
+	fmt.Fprintf(&b, "           (iOS: Settings > General > About > Certificate Trust Settings)\n")
+	return b.String()
+}
@@ -0,0 +1,270 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gookit/color"
+)
+
+// DefaultUIContentSecurityPolicy is the policy the platform serves UIs
+// with, used unless the manifest sets ui.csp.
+const DefaultUIContentSecurityPolicy = "default-src 'self'; connect-src 'none'; media-src 'self' data:; script-src 'self' 'unsafe-inline'; style-src 'self' 'unsafe-inline';"
+
+const cspReportPath = "/_csp-report"
+
+// uiContentSecurityPolicy is the manifest's policy with reporting to the
+// dev server added in place of any reporting it configures itself.
+func (s *Server) uiContentSecurityPolicy(r *http.Request) string {
+	policy := s.manifest.UI.ContentSecurityPolicy
+	if policy == "" {
+		policy = DefaultUIContentSecurityPolicy
+	}
+	directives := []string{}
+	for _, d := range strings.Split(policy, ";") {
+		d = strings.TrimSpace(d)
+		name, _, _ := strings.Cut(d, " ")
+		if d == "" || strings.EqualFold(name, "report-uri") || strings.EqualFold(name, "report-to") {
+			continue
+		}
+		directives = append(directives, d)
+	}
+	directives = append(directives, "report-uri "+s.cspReportURL(r), "report-to csp")
+	return strings.Join(directives, "; ")
+}
+
+// cspReportURL is where violations are reported. It is always on the
+// shell's origin so reports from cross-origin frames are collected too.
+func (s *Server) cspReportURL(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+	u := url.URL{Scheme: s.scheme(), Host: net.JoinHostPort(host, strconv.Itoa(s.port)), Path: cspReportPath}
+	return u.String()
+}
+
+// CSPViolation is a de-duplicated Content-Security-Policy violation.
+type CSPViolation struct {
+	Directive  string    `json:"directive"`
+	BlockedURI string    `json:"blockedURI"`
+	SourceFile string    `json:"sourceFile,omitempty"`
+	LineNumber int       `json:"lineNumber,omitempty"`
+	Count      int       `json:"count"`
+	FirstSeen  time.Time `json:"firstSeen"`
+	LastSeen   time.Time `json:"lastSeen"`
+
+	// documentURI is the page the violation happened on.
+	documentURI string
+}
+
+func (v *CSPViolation) key() string {
+	return fmt.Sprintf("%s %s %s:%d", v.Directive, v.BlockedURI, v.SourceFile, v.LineNumber)
+}
+
+type cspViolationEvent struct {
+	Type      string        `json:"type"`
+	Violation *CSPViolation `json:"violation"`
+}
+
+// maxCSPViolations is how many distinct violations are kept. Any more are
+// only counted.
+const maxCSPViolations = 200
+
+// cspReports collects the violations browsers report, counting repeats
+// rather than storing them.
+type cspReports struct {
+	violations map[string]*CSPViolation
+	// dropped counts reports of new violations after the first
+	// maxCSPViolations.
+	dropped int
+	lock    sync.Mutex
+}
+
+func newCSPReports() *cspReports {
+	return &cspReports{violations: map[string]*CSPViolation{}}
+}
+
+// add records v, returning a copy of the stored violation and whether it
+// is new.
+func (c *cspReports) add(v *CSPViolation) (CSPViolation, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := time.Now()
+	if existing, ok := c.violations[v.key()]; ok {
+		existing.Count++
+		existing.LastSeen = now
+		return *existing, false
+	}
+	if len(c.violations) >= maxCSPViolations {
+		c.dropped++
+		return *v, false
+	}
+	v.Count = 1
+	v.FirstSeen = now
+	v.LastSeen = now
+	c.violations[v.key()] = v
+	return *v, true
+}
+
+func (c *cspReports) list() ([]*CSPViolation, int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	violations := make([]*CSPViolation, 0, len(c.violations))
+	for _, v := range c.violations {
+		copied := *v
+		violations = append(violations, &copied)
+	}
+	sort.Slice(violations, func(i, j int) bool { return violations[i].FirstSeen.Before(violations[j].FirstSeen) })
+	return violations, c.dropped
+}
+
+// parseCSPReports reads either a report-uri body, a single "csp-report"
+// object, or a Reporting API body, an array of reports of which only
+// csp-violation ones are kept.
+func parseCSPReports(body []byte) ([]*CSPViolation, error) {
+	var legacy struct {
+		Report *struct {
+			DocumentURI        string `json:"document-uri"`
+			ViolatedDirective  string `json:"violated-directive"`
+			EffectiveDirective string `json:"effective-directive"`
+			BlockedURI         string `json:"blocked-uri"`
+			SourceFile         string `json:"source-file"`
+			LineNumber         int    `json:"line-number"`
+		} `json:"csp-report"`
+	}
+	if err := json.Unmarshal(body, &legacy); err == nil && legacy.Report != nil {
+		directive := legacy.Report.EffectiveDirective
+		if directive == "" {
+			directive = legacy.Report.ViolatedDirective
+		}
+		return []*CSPViolation{{
+			Directive:   directive,
+			BlockedURI:  legacy.Report.BlockedURI,
+			SourceFile:  legacy.Report.SourceFile,
+			LineNumber:  legacy.Report.LineNumber,
+			documentURI: legacy.Report.DocumentURI,
+		}}, nil
+	}
+
+	var reports []struct {
+		Type string `json:"type"`
+		Body struct {
+			DocumentURL        string `json:"documentURL"`
+			EffectiveDirective string `json:"effectiveDirective"`
+			BlockedURL         string `json:"blockedURL"`
+			SourceFile         string `json:"sourceFile"`
+			LineNumber         int    `json:"lineNumber"`
+		} `json:"body"`
+	}
+	if err := json.Unmarshal(body, &reports); err != nil {
+		return nil, err
+	}
+	violations := []*CSPViolation{}
+	for _, r := range reports {
+		if r.Type != "csp-violation" {
+			continue
+		}
+		violations = append(violations, &CSPViolation{
+			Directive:   r.Body.EffectiveDirective,
+			BlockedURI:  r.Body.BlockedURL,
+			SourceFile:  r.Body.SourceFile,
+			LineNumber:  r.Body.LineNumber,
+			documentURI: r.Body.DocumentURL,
+		})
+	}
+	return violations, nil
+}
+
+// ownDocument returns whether documentURI is a page served by the dev
+// server, on the shell's port or one of the frame ports, as reached by the
+// browser that sent r.
+func (s *Server) ownDocument(r *http.Request, documentURI string) bool {
+	u, err := url.Parse(documentURI)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return false
+	}
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+	if u.Hostname() != host {
+		return false
+	}
+	port := u.Port()
+	if port == "" {
+		port = map[string]string{"http": "80", "https": "443"}[u.Scheme]
+	}
+	ports := []int{s.port}
+	if s.crossOrigin {
+		ports = append(ports, s.framePort(uiFrame), s.framePort(gameFrame))
+	}
+	for _, p := range ports {
+		if port == strconv.Itoa(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// recordCSPReport takes reports straight from the browser, which sends
+// them without credentials and, for cross-origin frames, from another
+// origin, so it is served outside the token and same-origin checks.
+func (s *Server) recordCSPReport(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Access-Control-Allow-Methods", "POST")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		w.WriteHeader(204)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, 64*1024))
+	if err != nil {
+		fmt.Printf("error: %#v\n", err)
+		w.WriteHeader(500)
+		return
+	}
+	violations, err := parseCSPReports(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, v := range violations {
+		if !s.ownDocument(r, v.documentURI) {
+			continue
+		}
+		v, isNew := s.cspReports.add(v)
+		if !isNew {
+			continue
+		}
+		source := ""
+		if v.SourceFile != "" {
+			source = fmt.Sprintf(" in %s:%d", v.SourceFile, v.LineNumber)
+		}
+		color.Printf("<red>🛡  CSP blocked</> <bold>%s</> by <bold>%s</>%s\n", v.BlockedURI, v.Directive, source)
+		s.broadcast(&cspViolationEvent{Type: "cspViolation", Violation: &v})
+	}
+	w.WriteHeader(204)
+}
+
+func (s *Server) serveCSPReports(w http.ResponseWriter, r *http.Request) {
+	var reportsResponse struct {
+		Violations []*CSPViolation `json:"violations"`
+		Dropped    int             `json:"dropped"`
+	}
+	reportsResponse.Violations, reportsResponse.Dropped = s.cspReports.list()
+	w.Header().Add("Content-type", "application/json")
+	w.Header().Add("Cache-control", "no-store")
+	if err := json.NewEncoder(w).Encode(reportsResponse); err != nil {
+		fmt.Printf("error: %#v\n", err)
+	}
+}
@@ -0,0 +1,74 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+)
+
+// DeepLink is a shell configuration requested in the query string of /,
+// so a reload can go straight back to a game in progress or set up.
+type DeepLink struct {
+	// Players is the number of seats to fill, 0 if not set.
+	Players    int             `json:"players,omitempty"`
+	RandomSeed string          `json:"randomSeed,omitempty"`
+	Settings   json.RawMessage `json:"settings,omitempty"`
+	// State is the name of a save state to load instead of starting.
+	State  string `json:"state,omitempty"`
+	UserID string `json:"userID,omitempty"`
+}
+
+// ParseDeepLink reads players, seed, settings, state and user from the
+// query, checking them against the manifest, the dev users and the save
+// states in saveStatesPath. It returns nil if none are set.
+func ParseDeepLink(q url.Values, manifest *ManifestV1, devUsers []*DevUser, saveStatesPath string) (*DeepLink, error) {
+	link := &DeepLink{
+		RandomSeed: q.Get("seed"),
+		State:      q.Get("state"),
+		UserID:     q.Get("user"),
+	}
+	if p := q.Get("players"); p != "" {
+		players, err := strconv.Atoi(p)
+		if err != nil {
+			return nil, fmt.Errorf("players must be a number")
+		}
+		if players < manifest.MinimumPlayers || players > manifest.MaximumPlayers {
+			return nil, fmt.Errorf("players must be between %d and %d", manifest.MinimumPlayers, manifest.MaximumPlayers)
+		}
+		link.Players = players
+	}
+	if s := q.Get("settings"); s != "" {
+		settings := map[string]json.RawMessage{}
+		if err := json.Unmarshal([]byte(s), &settings); err != nil {
+			return nil, fmt.Errorf("settings must be a JSON object")
+		}
+		link.Settings = json.RawMessage(s)
+	}
+	if link.State != "" {
+		if link.Players != 0 || link.RandomSeed != "" || link.Settings != nil {
+			return nil, fmt.Errorf("state cannot be combined with players, seed or settings, they come from the save state")
+		}
+		if !ValidSaveStateName(link.State) {
+			return nil, fmt.Errorf("invalid save state name %q", link.State)
+		}
+		if _, err := os.Stat(path.Join(saveStatesPath, link.State)); err != nil {
+			return nil, fmt.Errorf("no save state named %q", link.State)
+		}
+	}
+	if link.UserID != "" {
+		found := false
+		for _, u := range devUsers {
+			found = found || u.ID == link.UserID
+		}
+		if !found {
+			return nil, fmt.Errorf("no dev user with id %q", link.UserID)
+		}
+	}
+	if link.Players == 0 && link.RandomSeed == "" && link.Settings == nil && link.State == "" && link.UserID == "" {
+		return nil, nil
+	}
+	return link, nil
+}
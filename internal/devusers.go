@@ -0,0 +1,247 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const devUsersFile = ".bz/devusers.json"
+
+// DevUser is one of the users the dev shell lets you play as.
+type DevUser struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Avatar is the path of an image relative to the game root, a
+	// placeholder is used if empty.
+	Avatar string `json:"avatar,omitempty"`
+	Color  string `json:"color,omitempty"`
+	Host   bool   `json:"host,omitempty"`
+	// Bot is a command, run from the game root, that plays this user's
+	// seat. See bots.
+	Bot []string `json:"bot,omitempty"`
+
+	// builtIn is set on the users added to fill the roster
+	builtIn bool
+}
+
+// the built in users, whose avatars are 0.jpg to 9.jpg
+var defaultDevUserNames = []string{
+	"Evelyn",
+	"Jennifer",
+	"Kateryna",
+	"Logan",
+	"Liubika",
+	"Aischa",
+	"Leilani",
+	"Avery",
+	"Guadalupe",
+	"Zvezdelina",
+}
+
+// LoadDevUsers reads the dev users from .bz/devusers.json in the game root,
+// or the manifest's devUsers if there is no such file, then adds built in
+// users until there are enough to fill every seat. The host comes first.
+func LoadDevUsers(gameRoot string, manifest *ManifestV1) ([]*DevUser, error) {
+	users := manifest.DevUsers
+	f, err := os.ReadFile(filepath.Clean(path.Join(gameRoot, devUsersFile)))
+	if err == nil {
+		users = nil
+		if err := json.Unmarshal(f, &users); err != nil {
+			return nil, fmt.Errorf("%s: %w", devUsersFile, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	roster := []*DevUser{}
+	taken := map[string]bool{}
+	hosts := 0
+	for i, u := range users {
+		if u.ID == "" || u.Name == "" {
+			return nil, fmt.Errorf("dev user %d requires an id and name", i)
+		}
+		if taken[u.ID] {
+			return nil, fmt.Errorf("dev user id %q is used more than once", u.ID)
+		}
+		taken[u.ID] = true
+		if u.Host {
+			hosts++
+		}
+		if u.Host && len(u.Bot) != 0 {
+			return nil, fmt.Errorf("dev user %q is the host so can't be a bot", u.ID)
+		}
+		u := u
+		roster = append(roster, &u)
+	}
+	if hosts > 1 {
+		return nil, fmt.Errorf("only one dev user can be the host")
+	}
+
+	count := max(manifest.MaximumPlayers, len(defaultDevUserNames), len(roster))
+	for i := 0; len(roster) < count; i++ {
+		id := strconv.Itoa(i)
+		if taken[id] {
+			continue
+		}
+		name := fmt.Sprintf("Player %d", i+1)
+		if i < len(defaultDevUserNames) {
+			name = defaultDevUserNames[i]
+		}
+		roster = append(roster, &DevUser{ID: id, Name: name, builtIn: true})
+	}
+
+	// the host is the seat you play from, so the first person if none is
+	// chosen
+	host := slices.IndexFunc(roster, func(u *DevUser) bool { return u.Host })
+	if host < 0 {
+		host = slices.IndexFunc(roster, func(u *DevUser) bool { return len(u.Bot) == 0 })
+	}
+	if host < 0 {
+		return nil, fmt.Errorf("at least one dev user must not be a bot")
+	}
+	if host > 0 {
+		h := roster[host]
+		roster = append([]*DevUser{h}, slices.Delete(roster, host, host+1)...)
+	}
+	roster[0].Host = true
+	return roster, nil
+}
+
+// the colours the shell gives seated players, in seat order
+var playerColors = []string{
+	"#d50000", "#00695c", "#304ffe", "#ff6f00", "#7c4dff",
+	"#ffa825", "#f2d330", "#43a047", "#004d40", "#795a4f",
+	"#00838f", "#408074", "#448aff", "#1a237e", "#ff4081",
+	"#bf360c", "#4a148c", "#aa00ff", "#455a64", "#600020",
+}
+
+// SeatDevUsers makes players of users, seated in order.
+func SeatDevUsers(users []*DevUser) []*Player {
+	players := []*Player{}
+	for i, u := range users {
+		c := u.Color
+		if c == "" {
+			c = playerColors[i%len(playerColors)]
+		}
+		players = append(players, &Player{
+			ID:       u.ID,
+			Name:     u.Name,
+			Color:    c,
+			Position: i + 1,
+			Avatar:   "/_profile/" + url.PathEscape(u.ID),
+			Host:     u.Host,
+		})
+	}
+	return players
+}
+
+// DevUsers is the roster the shell offers, host first.
+func (s *Server) DevUsers() []*DevUser {
+	return s.devUsers
+}
+
+// devUsersJSON is the roster as the shell sees it, with avatars as URLs.
+func (s *Server) devUsersJSON() (string, error) {
+	users := make([]DevUser, len(s.devUsers))
+	for i, u := range s.devUsers {
+		users[i] = *u
+		users[i].Avatar = "/_profile/" + url.PathEscape(u.ID)
+	}
+	b, err := json.Marshal(users)
+	return string(b), err
+}
+
+func (s *Server) devUser(id string) *DevUser {
+	for _, u := range s.devUsers {
+		if u.ID == id {
+			return u
+		}
+	}
+	return nil
+}
+
+// serveAvatar serves a dev user's own avatar, a built in user's jpg, or a
+// placeholder generated from their id.
+func (s *Server) serveAvatar(w http.ResponseWriter, r *http.Request) {
+	id, err := url.PathUnescape(strings.TrimSuffix(chi.URLParam(r, "*"), ".jpg"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	user := s.devUser(id)
+	var f []byte
+	contentType := "image/jpeg"
+	switch {
+	case user != nil && user.Avatar != "":
+		// #nosec G304
+		f, err = os.ReadFile(path.Join(s.gameRoot, user.Avatar))
+		contentType = mime.TypeByExtension(path.Ext(user.Avatar))
+	case user != nil && user.builtIn && len(id) == 1 && id >= "0" && id <= "9":
+		f, err = s.getBuildFile(id + ".jpg")
+	default:
+		f, err = placeholderAvatar(id)
+		contentType = "image/png"
+	}
+	if err != nil {
+		fmt.Printf("error: %#v\n", err)
+		w.WriteHeader(500)
+		return
+	}
+	w.Header().Add("Content-type", contentType)
+	w.Header().Add("Cache-control", "no-store")
+	if _, err := w.Write(f); err != nil {
+		fmt.Printf("error: %#v\n", err)
+	}
+}
+
+// placeholderAvatar draws a mirrored 5x5 pattern in a colour, both taken
+// from a hash of the id, so the same id always gets the same avatar.
+func placeholderAvatar(id string) ([]byte, error) {
+	const cells, cellSize, margin = 5, 20, 10
+	sum := sha256.Sum256([]byte(id))
+	fg := color.RGBA{R: sum[0]/2 + 32, G: sum[1]/2 + 32, B: sum[2]/2 + 32, A: 255}
+	bg := color.RGBA{R: 240, G: 240, B: 240, A: 255}
+	size := cells*cellSize + 2*margin
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			img.Set(x, y, bg)
+		}
+	}
+	for row := 0; row < cells; row++ {
+		for col := 0; col < (cells+1)/2; col++ {
+			bit := row*3 + col
+			if sum[3+bit/8]&(1<<(bit%8)) == 0 {
+				continue
+			}
+			for _, c := range []int{col, cells - 1 - col} {
+				for y := 0; y < cellSize; y++ {
+					for x := 0; x < cellSize; x++ {
+						img.Set(margin+c*cellSize+x, margin+row*cellSize+y, fg)
+					}
+				}
+			}
+		}
+	}
+	var b bytes.Buffer
+	if err := png.Encode(&b, img); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
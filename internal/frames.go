@@ -0,0 +1,160 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gookit/color"
+)
+
+// frame is one of the iframes the shell embeds. With cross-origin frames
+// each is served on its own port, port+1 for the UI and port+2 for the game,
+// so it is a different origin from the shell like it is in production.
+type frame int
+
+const (
+	uiFrame frame = iota + 1
+	gameFrame
+)
+
+func (f frame) String() string {
+	switch f {
+	case uiFrame:
+		return "ui"
+	case gameFrame:
+		return "game"
+	}
+	return "unknown"
+}
+
+type frameContextKey struct{}
+
+// requestFrame is the frame a request was served for, or 0 if it came to
+// the shell's own origin.
+func requestFrame(r *http.Request) frame {
+	f, _ := r.Context().Value(frameContextKey{}).(frame)
+	return f
+}
+
+func (s *Server) framePort(f frame) int {
+	return s.port + int(f)
+}
+
+// frameOrigin is the origin the frame is served from as seen by a browser
+// that reached the shell through r, empty when frames share the shell's
+// origin.
+func (s *Server) frameOrigin(r *http.Request, f frame) string {
+	if !s.crossOrigin {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+	u := url.URL{Scheme: s.scheme(), Host: net.JoinHostPort(host, strconv.Itoa(s.framePort(f)))}
+	return u.String()
+}
+
+// frameServes reports whether the frame's origin should serve p. Frames
+// only get their own documents and static assets, never the shell or its
+// API.
+func frameServes(f frame, p string) bool {
+	if f == gameFrame {
+		return p == "/game.html" || p == "/game.js"
+	}
+	switch {
+	case p == "/", p == "/events", p == "/autosave", p == "/ca.crt", p == "/game.html", p == "/game.js":
+		return false
+	case strings.HasPrefix(p, "/states"), strings.HasPrefix(p, "/api/"):
+		return false
+	case strings.HasPrefix(p, "/_"):
+		return strings.HasPrefix(p, "/_profile/")
+	}
+	return true
+}
+
+// frameHandler serves a frame's origin from the shell's router with the
+// headers production sends: documents are sandboxed without
+// allow-same-origin, which gives them an opaque origin, and may only be
+// framed by the shell.
+func (s *Server) frameHandler(f frame, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if !frameServes(f, r.URL.Path) {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Referrer-Policy", "no-referrer")
+		w.Header().Set("Cross-Origin-Resource-Policy", "cross-origin")
+		// requests for fonts from an opaque origin are CORS requests from "null"
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		if path.Ext(r.URL.Path) == ".html" {
+			w.Header().Add("Content-Security-Policy", fmt.Sprintf("sandbox allow-scripts allow-popups; frame-ancestors %s://*:%d", s.scheme(), s.port))
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), frameContextKey{}, f)))
+	})
+}
+
+type crossOriginAccess struct {
+	Kind   string `json:"kind"`
+	Detail string `json:"detail"`
+}
+
+// crossOriginReport collects the accesses the UI attempted that the
+// production sandbox blocks, printing each the first time it is seen.
+type crossOriginReport struct {
+	counts map[crossOriginAccess]int
+	lock   sync.Mutex
+}
+
+func (c *crossOriginReport) add(a crossOriginAccess) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.counts[a] == 0 {
+		color.Printf("<yellow>⚠️  UI attempted blocked %s access:</> <bold>%s</>\n", a.Kind, a.Detail)
+	}
+	c.counts[a]++
+}
+
+func (s *Server) serveCrossOriginReport(w http.ResponseWriter, r *http.Request) {
+	type entry struct {
+		crossOriginAccess
+		Count int `json:"count"`
+	}
+	var reportResponse struct {
+		Accesses []*entry `json:"accesses"`
+	}
+	reportResponse.Accesses = []*entry{}
+	s.crossOriginReport.lock.Lock()
+	for a, count := range s.crossOriginReport.counts {
+		reportResponse.Accesses = append(reportResponse.Accesses, &entry{a, count})
+	}
+	s.crossOriginReport.lock.Unlock()
+	w.Header().Add("Content-type", "application/json")
+	w.Header().Add("Cache-control", "no-store")
+	if err := json.NewEncoder(w).Encode(reportResponse); err != nil {
+		fmt.Printf("error: %#v\n", err)
+	}
+}
+
+func (s *Server) recordCrossOriginAccess(w http.ResponseWriter, r *http.Request) {
+	access := crossOriginAccess{}
+	if err := json.NewDecoder(r.Body).Decode(&access); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.crossOriginReport.add(access)
+	w.WriteHeader(204)
+}
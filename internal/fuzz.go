@@ -0,0 +1,418 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+)
+
+const (
+	// fuzzStuckMoves is how many rejected moves in a row end a fuzzed game.
+	fuzzStuckMoves = 100
+	// fuzzMinimizeReplays bounds the replays spent minimizing a failure.
+	fuzzMinimizeReplays = 500
+)
+
+// crashErrors are the errors JavaScript throws by itself, as opposed to a
+// game rejecting a move with its own Error.
+var crashErrors = []string{"TypeError", "RangeError", "ReferenceError", "SyntaxError", "EvalError", "URIError", "InternalError", "AggregateError"}
+
+// Fuzzer plays games with generated moves looking for ones that crash the
+// game or leave it in an invalid state.
+type Fuzzer struct {
+	Runner *Runner
+	// NewRunner replaces Runner when a move kills it.
+	NewRunner func() (*Runner, error)
+	Rand      *rand.Rand
+	// Enumerate generates moves with the game's enumerateMoves export,
+	// otherwise moves are made by mutating Corpus.
+	Enumerate bool
+	// Corpus is recorded move data to mutate.
+	Corpus []json.RawMessage
+	// MutateRate is the chance an enumerated move is mutated before it is
+	// made.
+	MutateRate float64
+	// AllErrors counts every error the game throws as a failure, not only
+	// the ones JavaScript throws itself like a TypeError.
+	AllErrors bool
+	MaxMoves  int
+
+	// Moves and Rejected count the moves made and rejected so far.
+	Moves    int
+	Rejected int
+}
+
+// FuzzFailure is a game that failed, made by Moves then Move.
+type FuzzFailure struct {
+	Setup *SetupState
+	// Moves are the moves accepted before the failure.
+	Moves []*Move
+	// Move is the move that failed, nil if initialState did.
+	Move *Move
+	// Updates are the initial GameUpdate and one after each of Moves, and
+	// after Move if it was accepted but left an invalid GameUpdate.
+	Updates []json.RawMessage
+	// Error is what the game threw or why its GameUpdate is invalid.
+	Error string
+
+	signature string
+}
+
+// Signature identifies failures that are probably the same bug.
+func (f *FuzzFailure) Signature() string {
+	return f.signature
+}
+
+func newFuzzFailure(setup *SetupState, err error) *FuzzFailure {
+	failure := &FuzzFailure{Setup: setup, Error: err.Error(), signature: firstLine(err.Error())}
+	var invariantError *InvariantError
+	if errors.As(err, &invariantError) {
+		// the values differ from game to game, the invariants don't
+		names := []string{}
+		for _, v := range invariantError.Violations {
+			names = append(names, v.Invariant)
+		}
+		failure.signature = "invariant violated: " + strings.Join(names, ", ")
+	}
+	return failure
+}
+
+// Playout is the game up to the failure, ready to be saved.
+func (f *FuzzFailure) Playout() *Playout {
+	moves := f.Moves
+	if len(f.Updates) > len(f.Moves)+1 {
+		moves = append(append([]*Move{}, f.Moves...), f.Move)
+	}
+	return &Playout{Setup: f.Setup, Moves: moves, Updates: f.Updates}
+}
+
+// Fuzz plays one game from setup, returning the first failure found or nil
+// if the game finished, stalled or hit MaxMoves without one.
+func (f *Fuzzer) Fuzz(setup *SetupState) (*FuzzFailure, error) {
+	update, err := f.Runner.InitialState(setup)
+	if err != nil {
+		f.replaceExitedRunner()
+		return newFuzzFailure(setup, fmt.Errorf("initialState: %w", err)), nil
+	}
+	if err := f.check(update, setup); err != nil {
+		failure := newFuzzFailure(setup, fmt.Errorf("initialState: %w", err))
+		failure.Updates = []json.RawMessage{update}
+		return failure, nil
+	}
+	moves := []*Move{}
+	updates := []json.RawMessage{update}
+	stuck := 0
+	for len(moves) < f.MaxMoves && stuck < fuzzStuckMoves {
+		var u struct {
+			Game json.RawMessage `json:"game"`
+		}
+		if err := json.Unmarshal(update, &u); err != nil {
+			return nil, err
+		}
+		status := &gameStatus{}
+		if err := json.Unmarshal(u.Game, status); err != nil {
+			return nil, err
+		}
+		if status.Phase == "finished" {
+			return nil, nil
+		}
+		move, err := f.candidate(u.Game, status, setup)
+		if err != nil {
+			return nil, err
+		}
+		next, err := f.Runner.ProcessMove(u.Game, move)
+		if err != nil {
+			if f.isFailure(err) {
+				f.replaceExitedRunner()
+				failure := newFuzzFailure(setup, err)
+				failure.Moves, failure.Move, failure.Updates = moves, move, updates
+				return failure, nil
+			}
+			f.Rejected++
+			stuck++
+			continue
+		}
+		f.Moves++
+		stuck = 0
+		if err := f.check(next, setup); err != nil {
+			failure := newFuzzFailure(setup, err)
+			failure.Moves, failure.Move, failure.Updates = moves, move, append(updates, next)
+			return failure, nil
+		}
+		moves = append(moves, move)
+		updates = append(updates, next)
+		update = next
+	}
+	return nil, nil
+}
+
+// candidate generates the next move to try, mostly for a current player.
+func (f *Fuzzer) candidate(game json.RawMessage, status *gameStatus, setup *SetupState) (*Move, error) {
+	position := status.CurrentPlayers[f.Rand.Intn(len(status.CurrentPlayers))]
+	mover := position
+	if f.Rand.Float64() < 0.1 {
+		// moves out of turn should be rejected too
+		mover = setup.Players[f.Rand.Intn(len(setup.Players))].Position
+	}
+	if !f.Enumerate {
+		data := f.Corpus[f.Rand.Intn(len(f.Corpus))]
+		if f.Rand.Float64() < 0.5 {
+			data = MutateJSON(data, f.Corpus, f.Rand)
+		}
+		return &Move{Position: mover, Data: data}, nil
+	}
+	moves, err := f.Runner.EnumerateMoves(game, position)
+	if err != nil {
+		return nil, fmt.Errorf("enumerateMoves for position %d: %w", position, err)
+	}
+	if len(moves) == 0 {
+		if len(f.Corpus) == 0 {
+			return nil, fmt.Errorf("enumerateMoves returned no moves for position %d", position)
+		}
+		moves = f.Corpus
+	}
+	data := moves[f.Rand.Intn(len(moves))]
+	if f.Rand.Float64() < f.MutateRate {
+		data = MutateJSON(data, append(moves, f.Corpus...), f.Rand)
+	}
+	return &Move{Position: mover, Data: data}, nil
+}
+
+// isFailure tells a crash apart from the game rejecting a move.
+func (f *Fuzzer) isFailure(err error) bool {
+	var gameError *GameError
+	if f.AllErrors && errors.As(err, &gameError) {
+		return true
+	}
+	return isCrash(err)
+}
+
+// isCrash is true unless err is the game rejecting a move with its own
+// Error rather than one of the crashErrors.
+func isCrash(err error) bool {
+	var gameError *GameError
+	if !errors.As(err, &gameError) {
+		return true
+	}
+	message := firstLine(gameError.Message)
+	for _, name := range crashErrors {
+		if strings.HasPrefix(message, name) {
+			return true
+		}
+	}
+	return strings.Contains(message, "Maximum call stack size exceeded")
+}
+
+// check checks update is a valid GameUpdate that keeps the game's
+// invariants.
+func (f *Fuzzer) check(update json.RawMessage, setup *SetupState) error {
+	if err := CheckUpdate(update, setup); err != nil {
+		return err
+	}
+	return f.Runner.CheckInvariants(update)
+}
+
+func (f *Fuzzer) replaceExitedRunner() {
+	if !f.Runner.Exited() {
+		return
+	}
+	f.Runner.Close()
+	runner, err := f.NewRunner()
+	if err != nil {
+		fmt.Printf("error: %#v\n", err)
+		return
+	}
+	f.Runner = runner
+}
+
+// Minimize removes as many moves before the failing one as it can while
+// the failure still happens, replaying each candidate from the setup.
+func (f *Fuzzer) Minimize(failure *FuzzFailure) *FuzzFailure {
+	if failure.Move == nil {
+		return failure
+	}
+	if smaller := f.reproduce(failure, []*Move{}); smaller != nil {
+		return smaller
+	}
+	replays := 1
+	moves := failure.Moves
+	chunks := 2
+	for len(moves) > 0 && replays < fuzzMinimizeReplays {
+		size := (len(moves) + chunks - 1) / chunks
+		reduced := false
+		for start := 0; start < len(moves) && replays < fuzzMinimizeReplays; start += size {
+			end := start + size
+			if end > len(moves) {
+				end = len(moves)
+			}
+			candidate := append(append([]*Move{}, moves[:start]...), moves[end:]...)
+			replays++
+			if smaller := f.reproduce(failure, candidate); smaller != nil {
+				failure = smaller
+				moves = candidate
+				reduced = true
+				if chunks > 2 {
+					chunks--
+				}
+				break
+			}
+		}
+		if !reduced {
+			if chunks >= len(moves) {
+				break
+			}
+			chunks *= 2
+			if chunks > len(moves) {
+				chunks = len(moves)
+			}
+		}
+	}
+	return failure
+}
+
+// reproduce replays moves then the failing move, returning the failure if
+// it happens the same way.
+func (f *Fuzzer) reproduce(failure *FuzzFailure, moves []*Move) *FuzzFailure {
+	update, err := f.Runner.InitialState(failure.Setup)
+	if err != nil {
+		f.replaceExitedRunner()
+		return nil
+	}
+	updates := []json.RawMessage{update}
+	for _, m := range append(moves, failure.Move) {
+		var u struct {
+			Game json.RawMessage `json:"game"`
+		}
+		if err := json.Unmarshal(update, &u); err != nil {
+			return nil
+		}
+		next, err := f.Runner.ProcessMove(u.Game, m)
+		if m == failure.Move {
+			switch {
+			case err != nil && f.isFailure(err):
+				f.replaceExitedRunner()
+			case err == nil:
+				if err = f.check(next, failure.Setup); err != nil {
+					updates = append(updates, next)
+				}
+			default:
+				return nil
+			}
+			if err == nil {
+				return nil
+			}
+			reproduced := newFuzzFailure(failure.Setup, err)
+			if reproduced.Signature() != failure.Signature() {
+				return nil
+			}
+			reproduced.Moves, reproduced.Move, reproduced.Updates = moves, failure.Move, updates
+			return reproduced
+		}
+		if err != nil {
+			f.replaceExitedRunner()
+			return nil
+		}
+		if f.check(next, failure.Setup) != nil {
+			return nil
+		}
+		update = next
+		updates = append(updates, next)
+	}
+	return nil
+}
+
+// MutateJSON changes one value somewhere in data, sometimes to a value
+// found in donors.
+func MutateJSON(data json.RawMessage, donors []json.RawMessage, rng *rand.Rand) json.RawMessage {
+	v, err := decodeJSON(data)
+	if err != nil {
+		return data
+	}
+	// each slot is somewhere a value can be replaced
+	type slot struct {
+		value interface{}
+		set   func(interface{})
+	}
+	var root interface{} = v
+	slots := []slot{{v, func(n interface{}) { root = n }}}
+	var collect func(v interface{})
+	collect = func(v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			// in key order, so the choice is reproducible from rng
+			for _, k := range sortedKeys(v) {
+				k := k
+				slots = append(slots, slot{v[k], func(n interface{}) { v[k] = n }})
+				collect(v[k])
+			}
+		case []interface{}:
+			for i, c := range v {
+				i := i
+				slots = append(slots, slot{c, func(n interface{}) { v[i] = n }})
+				collect(c)
+			}
+		}
+	}
+	collect(v)
+	s := slots[rng.Intn(len(slots))]
+	s.set(mutateValue(s.value, donors, rng))
+	out, err := json.Marshal(root)
+	if err != nil {
+		return data
+	}
+	return out
+}
+
+func mutateValue(v interface{}, donors []json.RawMessage, rng *rand.Rand) interface{} {
+	if len(donors) != 0 && rng.Float64() < 0.2 {
+		if d, err := decodeJSON(donors[rng.Intn(len(donors))]); err == nil {
+			return d
+		}
+	}
+	if rng.Float64() < 0.25 {
+		return []interface{}{nil, true, false, json.Number("0"), json.Number("-1"), "", []interface{}{}, map[string]interface{}{}}[rng.Intn(8)]
+	}
+	switch v := v.(type) {
+	case json.Number:
+		n, _ := v.Float64()
+		return []interface{}{n + 1, n - 1, -n, 0, 0.5, 1e9, -1}[rng.Intn(7)]
+	case string:
+		return []interface{}{"", v + v, strings.ToUpper(v), "undefined", "__proto__", "constructor", strings.Repeat("x", 10000)}[rng.Intn(7)]
+	case []interface{}:
+		if len(v) != 0 {
+			i := rng.Intn(len(v))
+			switch rng.Intn(3) {
+			case 0:
+				return append(append([]interface{}{}, v[:i]...), v[i+1:]...)
+			case 1:
+				return append(append([]interface{}{}, v...), v[i])
+			}
+		}
+		return append(append([]interface{}{}, v...), nil)
+	case map[string]interface{}:
+		keys := sortedKeys(v)
+		out := map[string]interface{}{}
+		for k, c := range v {
+			out[k] = c
+		}
+		if len(keys) != 0 && rng.Intn(2) == 0 {
+			delete(out, keys[rng.Intn(len(keys))])
+		} else {
+			out["__proto__"] = map[string]interface{}{}
+		}
+		return out
+	}
+	return []interface{}{nil, true, false, json.Number("0"), json.Number("-1"), "", []interface{}{}, map[string]interface{}{}}[rng.Intn(8)]
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,258 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"path"
+	"sync"
+)
+
+//go:embed headless.js
+var headlessScript string
+
+// GameError is returned when the game itself throws while handling a
+// request, as opposed to the runner failing.
+type GameError struct {
+	Message string
+}
+
+func (e *GameError) Error() string {
+	return e.Message
+}
+
+type runnerRequest struct {
+	ID          int             `json:"id"`
+	Type        string          `json:"type"`
+	Setup       *SetupState     `json:"setup,omitempty"`
+	Moves       []*Move         `json:"moves"`
+	Move        *Move           `json:"move,omitempty"`
+	State       json.RawMessage `json:"state,omitempty"`
+	Position    int             `json:"position"`
+	FromVersion int             `json:"fromVersion"`
+	ToVersion   int             `json:"toVersion,omitempty"`
+
+	PreviousState json.RawMessage `json:"previousState,omitempty"`
+}
+
+type runnerResponse struct {
+	ID     int             `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  string          `json:"error"`
+	// Duration is how long the game took in milliseconds.
+	Duration float64 `json:"duration"`
+}
+
+// Runner executes the built game artifact headlessly in node, the same way
+// game.html runs it in the browser.
+type Runner struct {
+	// Invariants are checked by the headless tools after each GameUpdate.
+	Invariants []*Invariant
+	// Profile, if set, records how long each call into the game takes.
+	Profile *Profile
+
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+	stderr *bytes.Buffer
+	seq    int
+	lock   sync.Mutex
+}
+
+// NewRunner starts node with the game artifact at gamePath loaded.
+func NewRunner(gamePath string) (*Runner, error) {
+	cmd := exec.Command("node", "-e", headlessScript, "--", gamePath) // #nosec G204
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("unable to start node: %w", err)
+	}
+	return &Runner{
+		cmd:    cmd,
+		stdin:  stdin,
+		stdout: bufio.NewReaderSize(stdout, 1024*1024),
+		stderr: stderr,
+		lock:   sync.Mutex{},
+	}, nil
+}
+
+// NewRunnerForManifest starts a runner for the game artifact described by
+// the manifest in gameRoot, with the game's invariants.
+func NewRunnerForManifest(gameRoot string, manifest *ManifestV1) (*Runner, error) {
+	invariants, err := LoadInvariants(gameRoot)
+	if err != nil {
+		return nil, err
+	}
+	runner, err := NewRunner(path.Join(gameRoot, manifest.Game.Root, manifest.Game.OutputFile))
+	if err != nil {
+		return nil, err
+	}
+	runner.Invariants = invariants
+	return runner, nil
+}
+
+func (r *Runner) call(req *runnerRequest) (json.RawMessage, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.seq++
+	req.ID = r.seq
+	line, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.stdin.Write(append(line, '\n')); err != nil {
+		return nil, r.exitError(err)
+	}
+	resLine, err := r.stdout.ReadBytes('\n')
+	if err != nil {
+		return nil, r.exitError(err)
+	}
+	res := &runnerResponse{}
+	if err := json.Unmarshal(resLine, res); err != nil {
+		return nil, err
+	}
+	if res.ID != req.ID {
+		return nil, fmt.Errorf("expected response %d, got %d", req.ID, res.ID)
+	}
+	if r.Profile != nil && profiledMethods[req.Type] {
+		r.Profile.Record(&Timing{Method: req.Type, Duration: res.Duration, Size: len(res.Result)})
+	}
+	if res.Error != "" {
+		return nil, &GameError{Message: res.Error}
+	}
+	return res.Result, nil
+}
+
+func (r *Runner) exitError(err error) error {
+	if waitErr := r.cmd.Wait(); waitErr != nil {
+		return fmt.Errorf("game runner exited: %w\n%s", waitErr, r.stderr.String())
+	}
+	return fmt.Errorf("game runner: %w\n%s", err, r.stderr.String())
+}
+
+// Exports lists the functions exported by the game.
+func (r *Runner) Exports() ([]string, error) {
+	res, err := r.call(&runnerRequest{Type: "exports"})
+	if err != nil {
+		return nil, err
+	}
+	exports := []string{}
+	if err := json.Unmarshal(res, &exports); err != nil {
+		return nil, err
+	}
+	return exports, nil
+}
+
+// GameExports is what the game artifact defines, as game.html finds it.
+type GameExports struct {
+	// Global is the JavaScript type of the global game, "undefined" if the
+	// artifact does not define it.
+	Global string `json:"global"`
+	// ViaDefault is set when the functions are on game.default, as bundled
+	// from an ES module's default export.
+	ViaDefault bool     `json:"viaDefault"`
+	Exports    []string `json:"exports"`
+}
+
+// Describe finds how the game artifact defines game. Unlike Exports it
+// succeeds when game is missing.
+func (r *Runner) Describe() (*GameExports, error) {
+	res, err := r.call(&runnerRequest{Type: "describe"})
+	if err != nil {
+		return nil, err
+	}
+	exports := &GameExports{}
+	if err := json.Unmarshal(res, exports); err != nil {
+		return nil, err
+	}
+	return exports, nil
+}
+
+// HasExport reports whether the game exports a function called name.
+func (r *Runner) HasExport(name string) (bool, error) {
+	exports, err := r.Exports()
+	if err != nil {
+		return false, err
+	}
+	for _, e := range exports {
+		if e == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *Runner) InitialState(setup *SetupState) (json.RawMessage, error) {
+	return r.call(&runnerRequest{Type: "initialState", Setup: setup})
+}
+
+// ProcessMove applies move to previousState, which is the game field of a
+// GameUpdate.
+func (r *Runner) ProcessMove(previousState json.RawMessage, move *Move) (json.RawMessage, error) {
+	return r.call(&runnerRequest{Type: "processMove", PreviousState: previousState, Move: move})
+}
+
+func (r *Runner) GetPlayerState(state json.RawMessage, position int) (json.RawMessage, error) {
+	return r.call(&runnerRequest{Type: "getPlayerState", State: state, Position: position})
+}
+
+func (r *Runner) ReprocessHistory(setup *SetupState, moves []*Move) (*ReprocessResponse, error) {
+	res, err := r.call(&runnerRequest{Type: "reprocessHistory", Setup: setup, Moves: moves})
+	if err != nil {
+		return nil, err
+	}
+	reprocessResponse := &ReprocessResponse{}
+	if err := json.Unmarshal(res, reprocessResponse); err != nil {
+		return nil, err
+	}
+	return reprocessResponse, nil
+}
+
+// MigrateState calls the game's optional migrateState export to upgrade a
+// stored GameUpdate from one state version to another.
+func (r *Runner) MigrateState(state json.RawMessage, fromVersion, toVersion int) (json.RawMessage, error) {
+	return r.call(&runnerRequest{Type: "migrateState", State: state, FromVersion: fromVersion, ToVersion: toVersion})
+}
+
+// EnumerateMoves calls the game's optional enumerateMoves export, which
+// lists move data position could send given the game field of a GameUpdate.
+func (r *Runner) EnumerateMoves(game json.RawMessage, position int) ([]json.RawMessage, error) {
+	res, err := r.call(&runnerRequest{Type: "enumerateMoves", State: game, Position: position})
+	if err != nil {
+		return nil, err
+	}
+	moves := []json.RawMessage{}
+	if err := json.Unmarshal(res, &moves); err != nil {
+		return nil, fmt.Errorf("enumerateMoves must return an array of move data: %w", err)
+	}
+	return moves, nil
+}
+
+// Exited reports whether node has exited, after which the runner can't be
+// used.
+func (r *Runner) Exited() bool {
+	return r.cmd.ProcessState != nil
+}
+
+func (r *Runner) Close() error {
+	if err := r.stdin.Close(); err != nil {
+		return err
+	}
+	if r.Exited() {
+		// already reaped when the crash was noticed
+		return nil
+	}
+	return r.cmd.Wait()
+}
@@ -0,0 +1,67 @@
+// Runs a built game.js outside the browser, speaking JSON lines over
+// stdin/stdout. Mirrors the message handling in game.html. Everything is
+// scoped inside a function so nothing collides with the bundle's globals.
+(() => {
+  const fs = require("fs");
+  const vm = require("vm");
+  const readline = require("readline");
+
+  const out = process.stdout;
+  const methods = ["log", "warn", "info", "debug", "trace"];
+  methods.forEach((m) => {
+    console[m] = (...data) => console.error(...data);
+  });
+
+  globalThis.window = globalThis;
+  globalThis.self = globalThis;
+
+  const gamePath = process.argv[1];
+  vm.runInThisContext(fs.readFileSync(gamePath, "utf8"), { filename: gamePath });
+  const game = () => globalThis.game.default ?? globalThis.game;
+
+  const handlers = {
+    describe: () => {
+      const g = globalThis.game;
+      const exported = g === null || g === undefined ? {} : game();
+      return {
+        global: g === null ? "null" : typeof g,
+        viaDefault: g?.default !== null && g?.default !== undefined,
+        exports: Object.keys(exported).filter(
+          (k) => typeof exported[k] === "function"
+        ),
+      };
+    },
+    exports: () =>
+      Object.keys(game()).filter((k) => typeof game()[k] === "function"),
+    initialState: ({ setup }) => game().initialState(setup),
+    processMove: ({ previousState, move }) =>
+      game().processMove(previousState, {
+        position: move.position,
+        data: move.data,
+      }),
+    getPlayerState: ({ state, position }) =>
+      game().getPlayerState(state, position),
+    reprocessHistory: ({ setup, moves }) =>
+      game().reprocessHistory(setup, moves),
+    migrateState: ({ state, fromVersion, toVersion }) =>
+      game().migrateState(state, fromVersion, toVersion),
+    enumerateMoves: ({ state, position }) =>
+      game().enumerateMoves(state, position),
+  };
+
+  const rl = readline.createInterface({ input: process.stdin, terminal: false });
+  rl.on("line", (line) => {
+    const req = JSON.parse(line);
+    const start = performance.now();
+    let res;
+    try {
+      const handler = handlers[req.type];
+      if (!handler) throw new Error(`unknown request type ${req.type}`);
+      res = { id: req.id, result: handler(req) };
+    } catch (e) {
+      res = { id: req.id, error: String(e && e.stack ? e.stack : e) };
+    }
+    res.duration = performance.now() - start;
+    out.write(JSON.stringify(res) + "\n");
+  });
+})();
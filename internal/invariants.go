@@ -0,0 +1,342 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gookit/color"
+	"github.com/tidwall/gjson"
+)
+
+const invariantsFile = ".bz/invariants.json"
+
+// Invariant is an Assertion that must hold in every GameUpdate, such as
+// `count(game.state.deck, game.state.discard) == 52`.
+type Invariant struct {
+	Name  string `json:"name"`
+	Check string `json:"check"`
+	// When limits the invariant to updates where this assertion holds.
+	When string `json:"when,omitempty"`
+
+	check *Assertion
+	when  *Assertion
+}
+
+// UnmarshalJSON also takes an invariant written as just its check.
+func (i *Invariant) UnmarshalJSON(data []byte) error {
+	var check string
+	if err := json.Unmarshal(data, &check); err == nil {
+		i.Check = check
+		return nil
+	}
+	type invariant Invariant
+	return json.Unmarshal(data, (*invariant)(i))
+}
+
+// InvariantViolation is an invariant that did not hold, with what its
+// expressions came to.
+type InvariantViolation struct {
+	Invariant string `json:"invariant"`
+	Check     string `json:"check"`
+	Detail    string `json:"detail"`
+}
+
+func (v *InvariantViolation) String() string {
+	return fmt.Sprintf("%s: %s", v.Invariant, v.Detail)
+}
+
+// InvariantError is returned by headless tools when a GameUpdate breaks
+// invariants.
+type InvariantError struct {
+	Violations []*InvariantViolation
+}
+
+func (e *InvariantError) Error() string {
+	messages := []string{}
+	for _, v := range e.Violations {
+		messages = append(messages, v.String())
+	}
+	return "invariant violated: " + strings.Join(messages, "; ")
+}
+
+// LoadInvariants reads .bz/invariants.json in the game root, returning none
+// if there is no such file.
+func LoadInvariants(gameRoot string) ([]*Invariant, error) {
+	f, err := os.ReadFile(filepath.Clean(path.Join(gameRoot, invariantsFile)))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	invariants := []*Invariant{}
+	if err := json.Unmarshal(f, &invariants); err != nil {
+		return nil, fmt.Errorf("%s: %w", invariantsFile, err)
+	}
+	for _, i := range invariants {
+		if i.Name == "" {
+			i.Name = i.Check
+		}
+		if i.check, err = ParseAssertion(i.Check); err != nil {
+			return nil, fmt.Errorf("%s: %w", invariantsFile, err)
+		}
+		if i.When != "" {
+			if i.when, err = ParseAssertion(i.When); err != nil {
+				return nil, fmt.Errorf("%s: %w", invariantsFile, err)
+			}
+		}
+	}
+	return invariants, nil
+}
+
+// CheckInvariants lists the invariants that do not hold in update.
+func CheckInvariants(invariants []*Invariant, update json.RawMessage) []*InvariantViolation {
+	violations := []*InvariantViolation{}
+	for _, i := range invariants {
+		if i.when != nil {
+			if ok, _, _ := i.when.Check(update); !ok {
+				continue
+			}
+		}
+		ok, left, right := i.check.Check(update)
+		if ok {
+			continue
+		}
+		violations = append(violations, &InvariantViolation{Invariant: i.Name, Check: i.Check, Detail: i.check.describe(left, right)})
+	}
+	return violations
+}
+
+// checkInvariants checks the game's invariants after a GameUpdate reaches
+// the dev server, reporting violations in the shell and to the dev UI.
+func (s *Server) checkInvariants(entry *JournalEntry) {
+	invariants, err := LoadInvariants(s.gameRoot)
+	if err != nil {
+		color.Printf("<red>%s</>\n", err)
+		return
+	}
+	event := &invariantViolationEvent{Type: "invariantViolation"}
+	var update json.RawMessage
+	switch {
+	case entry.Move != nil:
+		update = entry.Move.State
+		event.Move = entry.Move.Data
+		event.Position = entry.Move.Position
+		event.Seq = entry.Move.Seq
+	case entry.InitialState != nil:
+		update = entry.InitialState.State
+	default:
+		return
+	}
+	if len(invariants) == 0 || len(update) == 0 {
+		return
+	}
+	event.Violations = CheckInvariants(invariants, update)
+	if len(event.Violations) == 0 {
+		return
+	}
+	if event.Move != nil {
+		color.Printf("<red>⚠  Invariants violated after move %d by position %d</> <gray>%s</>\n", event.Seq+1, event.Position, compactJSON(event.Move))
+	} else {
+		color.Printf("<red>⚠  Invariants violated in the initial state</>\n")
+	}
+	for _, v := range event.Violations {
+		color.Printf("   <bold>%s</> %s\n", v.Invariant, v.Detail)
+	}
+	s.broadcast(event)
+}
+
+type invariantViolationEvent struct {
+	Type       string                `json:"type"`
+	Violations []*InvariantViolation `json:"violations"`
+	// Move is the move that led to the update, absent for the initial
+	// state.
+	Move     json.RawMessage `json:"move,omitempty"`
+	Position int             `json:"position,omitempty"`
+	Seq      int             `json:"seq"`
+}
+
+// CheckInvariants checks the invariants the runner was started with,
+// returning an *InvariantError if any do not hold in update.
+func (r *Runner) CheckInvariants(update json.RawMessage) error {
+	if len(r.Invariants) == 0 {
+		return nil
+	}
+	if violations := CheckInvariants(r.Invariants, update); len(violations) != 0 {
+		return &InvariantError{Violations: violations}
+	}
+	return nil
+}
+
+// expression is a JSON literal, a gjson path, or an aggregate function of
+// other expressions: sum, count, min, max or distinct.
+type expression struct {
+	source   string
+	literal  bool
+	value    gjson.Result
+	path     string
+	function string
+	args     []*expression
+}
+
+var aggregatePattern = regexp.MustCompile(`^(sum|count|min|max|distinct)\((.*)\)$`)
+
+func parseExpression(s string) (*expression, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, fmt.Errorf("missing expression")
+	}
+	if gjson.Valid(s) {
+		return &expression{source: s, literal: true, value: gjson.Parse(s)}, nil
+	}
+	if m := aggregatePattern.FindStringSubmatch(s); m != nil {
+		e := &expression{source: s, function: m[1]}
+		for _, arg := range splitArgs(m[2]) {
+			a, err := parseExpression(arg)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", s, err)
+			}
+			e.args = append(e.args, a)
+		}
+		if len(e.args) == 0 {
+			return nil, fmt.Errorf("%s needs at least one path", s)
+		}
+		return e, nil
+	}
+	return &expression{source: s, path: s}, nil
+}
+
+// splitArgs splits on the commas outside brackets and quotes, which may be
+// part of a gjson path.
+func splitArgs(s string) []string {
+	args := []string{}
+	depth := 0
+	quoted := false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\':
+			i++
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '(' || c == '[' || c == '{':
+			depth++
+		case c == ')' || c == ']' || c == '}':
+			depth--
+		case c == ',' && depth == 0:
+			args = append(args, s[start:i])
+			start = i + 1
+		}
+	}
+	if strings.TrimSpace(s[start:]) != "" || len(args) != 0 {
+		args = append(args, s[start:])
+	}
+	return args
+}
+
+func (e *expression) eval(doc json.RawMessage) gjson.Result {
+	switch {
+	case e.literal:
+		return e.value
+	case e.function == "":
+		return gjson.GetBytes(doc, e.path)
+	}
+	values := []gjson.Result{}
+	for _, a := range e.args {
+		values = append(values, a.eval(doc))
+	}
+	switch e.function {
+	case "count":
+		n := 0
+		for _, v := range values {
+			switch {
+			case !v.Exists():
+			case v.IsArray():
+				n += len(v.Array())
+			case v.IsObject():
+				n += len(v.Map())
+			default:
+				n++
+			}
+		}
+		return numberResult(float64(n))
+	case "distinct":
+		seen := map[string]bool{}
+		for _, v := range values {
+			for _, element := range elements(v) {
+				seen[normalizeJSON(element)] = true
+			}
+		}
+		return numberResult(float64(len(seen)))
+	}
+	numbers := []float64{}
+	for _, v := range values {
+		numbers = append(numbers, flattenNumbers(v)...)
+	}
+	switch e.function {
+	case "sum":
+		total := 0.0
+		for _, n := range numbers {
+			total += n
+		}
+		return numberResult(total)
+	case "min", "max":
+		if len(numbers) == 0 {
+			return gjson.Result{}
+		}
+		m := numbers[0]
+		for _, n := range numbers[1:] {
+			if e.function == "min" {
+				m = math.Min(m, n)
+			} else {
+				m = math.Max(m, n)
+			}
+		}
+		return numberResult(m)
+	}
+	return gjson.Result{}
+}
+
+// elements is the values in an array or object, or v itself.
+func elements(v gjson.Result) []gjson.Result {
+	switch {
+	case !v.Exists():
+		return nil
+	case v.IsArray():
+		return v.Array()
+	case v.IsObject():
+		values := []gjson.Result{}
+		v.ForEach(func(_, value gjson.Result) bool {
+			values = append(values, value)
+			return true
+		})
+		return values
+	}
+	return []gjson.Result{v}
+}
+
+// flattenNumbers is every number in v, looking inside arrays and objects.
+func flattenNumbers(v gjson.Result) []float64 {
+	if v.Type == gjson.Number {
+		return []float64{v.Num}
+	}
+	numbers := []float64{}
+	if v.IsArray() || v.IsObject() {
+		for _, element := range elements(v) {
+			numbers = append(numbers, flattenNumbers(element)...)
+		}
+	}
+	return numbers
+}
+
+func numberResult(n float64) gjson.Result {
+	return gjson.Parse(strconv.FormatFloat(n, 'f', -1, 64))
+}
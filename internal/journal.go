@@ -0,0 +1,267 @@
+package internal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	journalDir          = ".autosave"
+	journalExt          = ".jsonl"
+	journalKeepSessions = 10
+)
+
+// JournalEntry is a single line in the session journal. A "setup" entry
+// starts a session, from scratch or from the History of a session the shell
+// adopted, "move" entries append to its history, "revert" truncates the
+// history back to Seq and "end" marks the shell leaving the session.
+type JournalEntry struct {
+	Type string `json:"type"`
+	Time int64  `json:"time"`
+	// GameStateVersion is the state version of the game that made the
+	// session a setup entry starts.
+	GameStateVersion *int                     `json:"gameStateVersion,omitempty"`
+	RandomSeed       string                   `json:"randomSeed,omitempty"`
+	Settings         json.RawMessage          `json:"settings,omitempty"`
+	Players          []*Player                `json:"players,omitempty"`
+	InitialState     *InitialStateHistoryItem `json:"initialState,omitempty"`
+	History          []*HistoryItem           `json:"history,omitempty"`
+	// SaveState names the save a setup entry adopts, for the server to
+	// fill the entry from in place of the shell.
+	SaveState string       `json:"saveState,omitempty"`
+	Move      *HistoryItem `json:"move,omitempty"`
+	Seq       int          `json:"seq,omitempty"`
+}
+
+// Adopt fills a setup entry from the save state the session continues.
+func (e *JournalEntry) Adopt(saveState *SaveStateData) {
+	e.GameStateVersion = &saveState.GameStateVersion
+	e.RandomSeed = saveState.RandomSeed
+	e.Settings = saveState.Settings
+	e.Players = saveState.Players
+	e.InitialState = &saveState.InitialState
+	e.History = saveState.History
+}
+
+// Journal keeps an append-only log of the current dev session so it can
+// be recovered after the server restarts or the page reloads.
+type Journal struct {
+	dir  string
+	keep int
+	f    *os.File
+	lock sync.Mutex
+}
+
+func NewJournal(saveStatesPath string) (*Journal, error) {
+	dir := path.Join(saveStatesPath, journalDir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &Journal{
+		dir:  dir,
+		keep: journalKeepSessions,
+		lock: sync.Mutex{},
+	}, nil
+}
+
+// Record writes an entry to the journal. A setup entry begins a new
+// session file, any other entry is appended to the most recent session.
+func (j *Journal) Record(e *JournalEntry) error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	if e.Time == 0 {
+		e.Time = time.Now().UnixMilli()
+	}
+	switch e.Type {
+	case "setup":
+		if e.InitialState == nil {
+			return fmt.Errorf("setup entry requires initialState")
+		}
+		if err := j.begin(); err != nil {
+			return err
+		}
+	case "move":
+		if e.Move == nil {
+			return fmt.Errorf("move entry requires move")
+		}
+	case "revert", "end":
+	default:
+		return fmt.Errorf("unknown journal entry type %q", e.Type)
+	}
+	if j.f == nil {
+		sessions, err := j.sessions()
+		if err != nil {
+			return err
+		}
+		if len(sessions) == 0 {
+			if e.Type == "end" {
+				return nil
+			}
+			return fmt.Errorf("no session to append to")
+		}
+		f, err := os.OpenFile(filepath.Clean(sessions[len(sessions)-1]), os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return err
+		}
+		j.f = f
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err := j.f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return j.f.Sync()
+}
+
+// Latest replays the most recent session into a save state. It returns an
+// error satisfying os.IsNotExist if no session has been recorded.
+func (j *Journal) Latest() (*SaveStateData, error) {
+	saveState, _, err := j.replay()
+	return saveState, err
+}
+
+// Current is the session the shell is playing, the latest one unless the
+// shell has left it. It returns an error satisfying os.IsNotExist if there
+// is none.
+func (j *Journal) Current() (*SaveStateData, error) {
+	saveState, ended, err := j.replay()
+	if err == nil && ended {
+		return nil, os.ErrNotExist
+	}
+	return saveState, err
+}
+
+func (j *Journal) replay() (*SaveStateData, bool, error) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	sessions, err := j.sessions()
+	if err != nil {
+		return nil, false, err
+	}
+	if len(sessions) == 0 {
+		return nil, false, os.ErrNotExist
+	}
+	f, err := os.Open(filepath.Clean(sessions[len(sessions)-1]))
+	if err != nil {
+		return nil, false, err
+	}
+	defer f.Close()
+
+	var saveState *SaveStateData
+	ended := false
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		e := &JournalEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), e); err != nil {
+			// a crash mid-write leaves a partial last line, keep what we have
+			break
+		}
+		switch e.Type {
+		case "setup":
+			saveState = &SaveStateData{
+				FormatVersion: SaveStateFormatVersion,
+				RandomSeed:    e.RandomSeed,
+				Settings:      e.Settings,
+				Players:       e.Players,
+				History:       append([]*HistoryItem{}, e.History...),
+				InitialState:  *e.InitialState,
+			}
+			if e.GameStateVersion != nil {
+				saveState.GameStateVersion = *e.GameStateVersion
+			}
+			ended = false
+		case "move":
+			if saveState == nil {
+				continue
+			}
+			saveState.History = append(saveState.History, e.Move)
+		case "revert":
+			if saveState == nil {
+				continue
+			}
+			if e.Seq+1 < len(saveState.History) {
+				saveState.History = saveState.History[:max(e.Seq+1, 0)]
+			}
+		case "end":
+			ended = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, false, err
+	}
+	if saveState == nil {
+		return nil, false, os.ErrNotExist
+	}
+	return saveState, ended, nil
+}
+
+func (j *Journal) Close() error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	if j.f == nil {
+		return nil
+	}
+	err := j.f.Close()
+	j.f = nil
+	return err
+}
+
+func (j *Journal) begin() error {
+	if j.f != nil {
+		if err := j.f.Close(); err != nil {
+			return err
+		}
+		j.f = nil
+	}
+	name := path.Join(j.dir, fmt.Sprintf("session-%d%s", time.Now().UnixNano(), journalExt))
+	f, err := os.OpenFile(filepath.Clean(name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	j.f = f
+	return j.rotate()
+}
+
+// rotate removes the oldest sessions beyond the number we keep.
+func (j *Journal) rotate() error {
+	sessions, err := j.sessions()
+	if err != nil {
+		return err
+	}
+	for len(sessions) > j.keep {
+		if err := os.Remove(sessions[0]); err != nil {
+			return err
+		}
+		sessions = sessions[1:]
+	}
+	return nil
+}
+
+// sessions lists session files oldest first.
+func (j *Journal) sessions() ([]string, error) {
+	entries, err := os.ReadDir(j.dir)
+	if err != nil {
+		return nil, err
+	}
+	sessions := []string{}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasPrefix(e.Name(), "session-") || path.Ext(e.Name()) != journalExt {
+			continue
+		}
+		sessions = append(sessions, path.Join(j.dir, e.Name()))
+	}
+	// names embed a fixed width nanosecond timestamp so they sort by time
+	sort.Strings(sessions)
+	return sessions, nil
+}
@@ -0,0 +1,66 @@
+package internal
+
+import (
+	"encoding/xml"
+	"io"
+	"strings"
+	"time"
+)
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Time     float64         `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      float64       `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// JUnitCase is one test for a JUnit XML report.
+type JUnitCase struct {
+	Name      string
+	ClassName string
+	Duration  time.Duration
+	Failures  []string
+}
+
+// WriteJUnit writes cases as a single JUnit XML test suite, the format CI
+// systems read test results from.
+func WriteJUnit(w io.Writer, suite string, cases []JUnitCase) error {
+	s := junitTestSuite{Name: suite, Tests: len(cases)}
+	for _, c := range cases {
+		tc := junitTestCase{Name: c.Name, ClassName: c.ClassName, Time: c.Duration.Seconds()}
+		if len(c.Failures) != 0 {
+			s.Failures++
+			tc.Failure = &junitFailure{Message: c.Failures[0], Text: strings.Join(c.Failures, "\n")}
+		}
+		s.Time += tc.Time
+		s.Cases = append(s.Cases, tc)
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(junitTestSuites{Suites: []junitTestSuite{s}}); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
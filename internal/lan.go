@@ -0,0 +1,37 @@
+package internal
+
+import (
+	"net"
+)
+
+// LANAddresses lists the unicast addresses of every interface that is up
+// and not a loopback, IPv4 first.
+func LANAddresses() ([]net.IP, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	v4 := []net.IP{}
+	v6 := []net.IP{}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok || ipNet.IP.IsLoopback() || ipNet.IP.IsLinkLocalUnicast() {
+				continue
+			}
+			if ip4 := ipNet.IP.To4(); ip4 != nil {
+				v4 = append(v4, ip4)
+			} else {
+				v6 = append(v6, ipNet.IP)
+			}
+		}
+	}
+	return append(v4, v6...), nil
+}
@@ -0,0 +1,411 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// Leak is information private to one position that shows up in another
+// position's PlayerState.state.
+type Leak struct {
+	// Owner is the position the information belongs to.
+	Owner int
+	// Viewer is the position that can see it.
+	Viewer int
+	// OwnerPath is where Owner sees it and Path is where Viewer does.
+	OwnerPath string
+	Path      string
+	Value     string
+}
+
+func (l *Leak) String() string {
+	if l.Path == l.OwnerPath {
+		return fmt.Sprintf("position %d can see position %d's %s: %s", l.Viewer, l.Owner, l.Path, l.Value)
+	}
+	return fmt.Sprintf("position %d can see position %d's %s at %s: %s", l.Viewer, l.Owner, l.OwnerPath, l.Path, l.Value)
+}
+
+// PlayerViews is each position's PlayerState.state in a GameUpdate, keyed
+// by position.
+func PlayerViews(update json.RawMessage) (map[string]json.RawMessage, error) {
+	var u struct {
+		Players []struct {
+			Position int             `json:"position"`
+			State    json.RawMessage `json:"state"`
+		} `json:"players"`
+	}
+	if err := json.Unmarshal(update, &u); err != nil {
+		return nil, err
+	}
+	views := map[string]json.RawMessage{}
+	for _, p := range u.Players {
+		views[strconv.Itoa(p.Position)] = p.State
+	}
+	return views, nil
+}
+
+// FindLeaks compares the views of every position in one update. With
+// privatePaths, gjson paths into a view where `{position}` stands for the
+// position the value belongs to, a value is private if it is at one of
+// those paths. Without them it is private if the game shows it to some
+// positions and not others: a value only its owner sees at that path, or a
+// value under a position key, or in an element with that "position", that
+// differs between the other views.
+func FindLeaks(views map[string]json.RawMessage, privatePaths []string) []*Leak {
+	positions := []string{}
+	docs := map[string]gjson.Result{}
+	for p, v := range views {
+		positions = append(positions, p)
+		docs[p] = gjson.ParseBytes(v)
+	}
+	sort.Strings(positions)
+	index := map[string]map[string][]string{}
+	tags := map[string]map[string]string{}
+	for _, p := range positions {
+		index[p] = indexContainers(docs[p])
+		tags[p] = positionTagged(docs[p], positions)
+	}
+
+	var leaks []*Leak
+	if len(privatePaths) != 0 {
+		leaks = declaredLeaks(positions, docs, index, privatePaths)
+	} else {
+		leaks = append(dropReversed(ownerOnlyLeaks(positions, docs, index, tags)), taggedLeaks(positions, docs, tags)...)
+	}
+	sort.Slice(leaks, func(i, j int) bool {
+		if leaks[i].Owner != leaks[j].Owner {
+			return leaks[i].Owner < leaks[j].Owner
+		}
+		if leaks[i].Viewer != leaks[j].Viewer {
+			return leaks[i].Viewer < leaks[j].Viewer
+		}
+		return leaks[i].Path < leaks[j].Path
+	})
+	return leaks
+}
+
+func declaredLeaks(positions []string, docs map[string]gjson.Result, index map[string]map[string][]string, privatePaths []string) []*Leak {
+	leaks := []*Leak{}
+	for _, owner := range positions {
+		seenAt := map[string]bool{}
+		for _, private := range privatePaths {
+			ownerPath := strings.ReplaceAll(private, "{position}", owner)
+			value := docs[owner].Get(ownerPath)
+			if isEmptyJSON(value) {
+				continue
+			}
+			normalized := normalizeJSON(value)
+			for _, viewer := range positions {
+				if viewer == owner {
+					continue
+				}
+				// a path naming the owner should not show the owner's value
+				// to anyone else
+				if ownerPath != private {
+					if seen := docs[viewer].Get(ownerPath); seen.Exists() && normalizeJSON(seen) == normalized {
+						leaks = append(leaks, newLeak(owner, viewer, ownerPath, ownerPath, value))
+						continue
+					}
+				}
+				viewerPath := strings.ReplaceAll(private, "{position}", viewer)
+				for _, path := range index[viewer][normalized] {
+					if path == viewerPath || path == ownerPath || seenAt[viewer+":"+path] || isPublicAt(docs[owner], path, normalized) {
+						continue
+					}
+					leaks = append(leaks, newLeak(owner, viewer, ownerPath, path, value))
+					seenAt[viewer+":"+path] = true
+					break
+				}
+			}
+		}
+	}
+	return leaks
+}
+
+// ownerOnlyLeaks finds values the owner sees at a path where some other
+// position sees something different, that another position can see
+// elsewhere in its view. Values tagged to another position belong to it,
+// not to whoever sees them.
+func ownerOnlyLeaks(positions []string, docs map[string]gjson.Result, index map[string]map[string][]string, tags map[string]map[string]string) []*Leak {
+	leaks := []*Leak{}
+	for _, owner := range positions {
+		found := map[string][]string{}
+		seenAt := map[string]bool{}
+		walkJSON(docs[owner], "", func(ownerPath string, value gjson.Result) bool {
+			if tag := tagOf(tags[owner], ownerPath); tag != "" && tag != owner {
+				return false
+			}
+			if !isContainer(value) || isEmptyJSON(value) {
+				return true
+			}
+			normalized := normalizeJSON(value)
+			private := false
+			for _, other := range positions {
+				if other != owner {
+					if seen := docs[other].Get(ownerPath); !seen.Exists() || normalizeJSON(seen) != normalized {
+						private = true
+					}
+				}
+			}
+			if !private {
+				return true
+			}
+			for _, viewer := range positions {
+				if viewer == owner || hasPathPrefix(found[viewer], ownerPath) {
+					continue
+				}
+				for _, path := range index[viewer][normalized] {
+					if tag := tagOf(tags[viewer], path); tag != "" && tag != owner {
+						continue
+					}
+					if path == ownerPath || seenAt[viewer+":"+path] || isPublicAt(docs[owner], path, normalized) {
+						continue
+					}
+					leaks = append(leaks, newLeak(owner, viewer, ownerPath, path, value))
+					found[viewer] = append(found[viewer], ownerPath)
+					seenAt[viewer+":"+path] = true
+					break
+				}
+			}
+			return true
+		})
+	}
+	return leaks
+}
+
+// taggedLeaks finds values belonging to a position that one other position
+// sees as the owner does while another sees something different.
+func taggedLeaks(positions []string, docs map[string]gjson.Result, tags map[string]map[string]string) []*Leak {
+	leaks := []*Leak{}
+	reported := map[string]bool{}
+	for _, p := range positions {
+		for path, owner := range tags[p] {
+			if reported[path] {
+				continue
+			}
+			reported[path] = true
+			value := docs[owner].Get(path)
+			if !value.Exists() || value.Type == gjson.Null {
+				continue
+			}
+			normalized := normalizeJSON(value)
+			same, hidden := []string{}, []string{}
+			for _, viewer := range positions {
+				if viewer == owner {
+					continue
+				}
+				if seen := docs[viewer].Get(path); seen.Exists() && normalizeJSON(seen) == normalized {
+					same = append(same, viewer)
+				} else {
+					hidden = append(hidden, viewer)
+				}
+			}
+			if len(hidden) == 0 {
+				continue
+			}
+			for _, viewer := range same {
+				leaks = append(leaks, newLeak(owner, viewer, path, path, value))
+			}
+		}
+	}
+	return leaks
+}
+
+// positionTagged lists the paths in doc that belong to a position, values
+// of an object keyed by positions and array elements with a "position".
+func positionTagged(doc gjson.Result, positions []string) map[string]string {
+	isPosition := map[string]bool{}
+	for _, p := range positions {
+		isPosition[p] = true
+	}
+	tagged := map[string]string{}
+	walkJSON(doc, "", func(path string, value gjson.Result) bool {
+		switch {
+		case value.IsObject():
+			keys := 0
+			byPosition := true
+			value.ForEach(func(k, _ gjson.Result) bool {
+				keys++
+				byPosition = isPosition[k.String()]
+				return byPosition
+			})
+			if keys != 0 && byPosition {
+				value.ForEach(func(k, _ gjson.Result) bool {
+					tagged[joinPath(path, k.String())] = k.String()
+					return true
+				})
+			}
+		case value.IsArray():
+			i := 0
+			value.ForEach(func(_, e gjson.Result) bool {
+				if p := e.Get("position"); e.IsObject() && p.Type == gjson.Number && isPosition[p.Raw] {
+					tagged[joinPath(path, strconv.Itoa(i))] = p.Raw
+				}
+				i++
+				return true
+			})
+		}
+		return true
+	})
+	return tagged
+}
+
+// tagOf is the position path or one of its parents is tagged to, if any.
+func tagOf(tags map[string]string, path string) string {
+	for {
+		if tag, ok := tags[path]; ok {
+			return tag
+		}
+		i := lastSeparator(path)
+		if i < 0 {
+			return ""
+		}
+		path = path[:i]
+	}
+}
+
+// lastSeparator is the index of the last unescaped "." in a gjson path.
+func lastSeparator(path string) int {
+	for i := len(path) - 1; i > 0; i-- {
+		if path[i] == '.' && path[i-1] != '\\' {
+			return i
+		}
+	}
+	return -1
+}
+
+// dropReversed keeps one of two leaks that mirror each other, such as each
+// player's hand under "myHand" showing up as the next player's "peek".
+// Only one side can be the owner's own value, taken to be the one at the
+// shallower path.
+func dropReversed(leaks []*Leak) []*Leak {
+	type key struct {
+		owner, viewer   int
+		ownerPath, path string
+	}
+	byKey := map[key]bool{}
+	for _, l := range leaks {
+		byKey[key{l.Owner, l.Viewer, l.OwnerPath, l.Path}] = true
+	}
+	kept := []*Leak{}
+	for _, l := range leaks {
+		if byKey[key{l.Viewer, l.Owner, l.Path, l.OwnerPath}] && !shallower(l.OwnerPath, l.Path) {
+			continue
+		}
+		kept = append(kept, l)
+	}
+	return kept
+}
+
+func shallower(a, b string) bool {
+	da, db := strings.Count(a, "."), strings.Count(b, ".")
+	if da != db {
+		return da < db
+	}
+	return a < b
+}
+
+func newLeak(owner, viewer, ownerPath, path string, value gjson.Result) *Leak {
+	o, _ := strconv.Atoi(owner)
+	v, _ := strconv.Atoi(viewer)
+	return &Leak{Owner: o, Viewer: v, OwnerPath: ownerPath, Path: path, Value: compactJSON(json.RawMessage(value.Raw))}
+}
+
+// isPublicAt is true if the owner sees the same value at path, so finding
+// it there in another view is no leak.
+func isPublicAt(ownerDoc gjson.Result, path, normalized string) bool {
+	seen := ownerDoc.Get(path)
+	return seen.Exists() && normalizeJSON(seen) == normalized
+}
+
+func hasPathPrefix(paths []string, path string) bool {
+	for _, p := range paths {
+		if strings.HasPrefix(path, p+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// indexContainers maps each non-empty object and array in doc, normalized,
+// to the paths it is found at.
+func indexContainers(doc gjson.Result) map[string][]string {
+	index := map[string][]string{}
+	walkJSON(doc, "", func(path string, value gjson.Result) bool {
+		if isContainer(value) && !isEmptyJSON(value) {
+			n := normalizeJSON(value)
+			index[n] = append(index[n], path)
+		}
+		return true
+	})
+	return index
+}
+
+// walkJSON calls visit with the gjson path of every value under doc,
+// parents first. Returning false from visit skips a value's children.
+func walkJSON(doc gjson.Result, path string, visit func(path string, value gjson.Result) bool) {
+	if path != "" && !visit(path, doc) {
+		return
+	}
+	if !isContainer(doc) {
+		return
+	}
+	i := 0
+	doc.ForEach(func(k, v gjson.Result) bool {
+		key := k.String()
+		if doc.IsArray() {
+			key = strconv.Itoa(i)
+		}
+		i++
+		walkJSON(v, joinPath(path, key), visit)
+		return true
+	})
+}
+
+var gjsonPathEscaper = strings.NewReplacer(".", `\.`, "*", `\*`, "?", `\?`)
+
+func joinPath(path, key string) string {
+	key = gjsonPathEscaper.Replace(key)
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func isContainer(value gjson.Result) bool {
+	return value.IsObject() || value.IsArray()
+}
+
+func isEmptyJSON(value gjson.Result) bool {
+	switch {
+	case !value.Exists(), value.Type == gjson.Null:
+		return true
+	case isContainer(value):
+		empty := true
+		value.ForEach(func(_, _ gjson.Result) bool {
+			empty = false
+			return false
+		})
+		return empty
+	}
+	return value.Type == gjson.String && value.Str == ""
+}
+
+// normalizeJSON re-encodes a value with sorted keys so equal values compare
+// equal however they were serialized.
+func normalizeJSON(value gjson.Result) string {
+	v, err := decodeJSON([]byte(value.Raw))
+	if err != nil {
+		return value.Raw
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return value.Raw
+	}
+	return string(b)
+}
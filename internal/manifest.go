@@ -10,6 +10,9 @@ type UIConfig struct {
 	BuildCommands   BuildCommand `json:"build"`
 	WatchPaths      []string     `json:"watchPaths"`
 	OutputDirectory string       `json:"outDir"`
+	// ContentSecurityPolicy is the policy ui.html is served with, to mirror
+	// what the platform enforces, DefaultUIContentSecurityPolicy if empty.
+	ContentSecurityPolicy string `json:"csp,omitempty"`
 }
 
 type GameConfig struct {
@@ -23,6 +26,17 @@ type ManifestV1 struct {
 	MinimumPlayers int        `json:"minPlayers"`
 	MaximumPlayers int        `json:"maxPlayers"`
 	DefaultPlayers int        `json:"defaultPlayers,omitempty"`
+	StateVersion   int        `json:"stateVersion,omitempty"`
+	DevUsers       []DevUser  `json:"devUsers,omitempty"`
 	UI             UIConfig   `json:"ui"`
 	Game           GameConfig `json:"game"`
+
+	// PrivateState lists gjson paths into a PlayerState.state holding
+	// information hidden from other players, with `{position}` standing
+	// for the position it belongs to. See FindLeaks.
+	PrivateState []string `json:"privateState,omitempty"`
+
+	// StateBudgets are how large the game's state may grow before the dev
+	// server warns about it. See CheckStateSize.
+	StateBudgets *StateBudgets `json:"stateBudgets,omitempty"`
 }
@@ -0,0 +1,86 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/tidwall/gjson"
+)
+
+const presetsFile = ".bz/presets.json"
+
+// SettingsPreset is named game settings to test with.
+type SettingsPreset struct {
+	Name     string
+	Settings json.RawMessage
+}
+
+// LoadSettingsPresets reads .bz/presets.json in the game root, an object
+// of settings by name, in the order they are written. Without the file
+// there is one preset, "default", with no settings.
+func LoadSettingsPresets(gameRoot string) ([]*SettingsPreset, error) {
+	f, err := os.ReadFile(filepath.Clean(path.Join(gameRoot, presetsFile)))
+	if os.IsNotExist(err) {
+		return []*SettingsPreset{{Name: "default", Settings: json.RawMessage("{}")}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	presets := gjson.ParseBytes(f)
+	if !gjson.ValidBytes(f) || !presets.IsObject() {
+		return nil, fmt.Errorf("%s must be an object of settings by name", presetsFile)
+	}
+	loaded := []*SettingsPreset{}
+	presets.ForEach(func(name, settings gjson.Result) bool {
+		loaded = append(loaded, &SettingsPreset{Name: name.String(), Settings: json.RawMessage(settings.Raw)})
+		return true
+	})
+	if len(loaded) == 0 {
+		return nil, fmt.Errorf("%s has no presets", presetsFile)
+	}
+	return loaded, nil
+}
+
+// MatrixCell is the outcome of one combination of player count, seed and
+// settings preset.
+type MatrixCell struct {
+	Players  int    `json:"players"`
+	Seed     string `json:"seed"`
+	Preset   string `json:"preset"`
+	Moves    int    `json:"moves"`
+	Finished bool   `json:"finished"`
+	// Error is the first thing that went wrong, empty if the cell passed.
+	Error string `json:"error,omitempty"`
+}
+
+// PlayMatrixCell plays up to maxMoves from setup with picker, checking
+// each GameUpdate has the shape the platform relies on and holds the
+// runner's invariants. With no picker only the initial state is checked.
+func PlayMatrixCell(runner *Runner, setup *SetupState, preset string, picker MovePicker, rng *rand.Rand, maxMoves int) (*MatrixCell, *Playout) {
+	cell := &MatrixCell{Players: len(setup.Players), Seed: setup.RandomSeed, Preset: preset}
+	if picker == nil {
+		maxMoves = 0
+	}
+	playout, err := Play(runner, setup, picker, rng, maxMoves)
+	cell.Moves = len(playout.Moves)
+	cell.Finished = playout.Finished
+	// an invalid update comes before whatever it led to
+	for i, update := range playout.Updates {
+		if err := CheckUpdate(update, setup); err != nil {
+			if i == 0 {
+				cell.Error = fmt.Sprintf("initialState: %s", err)
+			} else {
+				cell.Error = fmt.Sprintf("move %d by position %d: %s", i, playout.Moves[i-1].Position, err)
+			}
+			return cell, playout
+		}
+	}
+	if err != nil {
+		cell.Error = firstLine(err.Error())
+	}
+	return cell, playout
+}
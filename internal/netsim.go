@@ -0,0 +1,153 @@
+package internal
+
+import (
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// NetworkConditions describe the simulated connection between the dev
+// server and its clients.
+type NetworkConditions struct {
+	Enabled   bool    `json:"enabled"`
+	LatencyMs int     `json:"latencyMs"`
+	JitterMs  int     `json:"jitterMs"`
+	DropRate  float64 `json:"dropRate"`
+}
+
+type netSimClient struct {
+	ID          string    `json:"id"`
+	RemoteAddr  string    `json:"remoteAddr"`
+	UserAgent   string    `json:"userAgent"`
+	ConnectedAt time.Time `json:"connectedAt"`
+
+	kick chan struct{}
+}
+
+// netSim delays and drops traffic and disconnects event stream clients
+// so UIs can be exercised against a poor connection.
+type netSim struct {
+	conditions   NetworkConditions
+	clients      map[string]*netSimClient
+	disconnected map[string]time.Time
+	lock         sync.Mutex
+}
+
+func newNetSim(conditions NetworkConditions) *netSim {
+	return &netSim{
+		conditions:   conditions,
+		clients:      map[string]*netSimClient{},
+		disconnected: map[string]time.Time{},
+		lock:         sync.Mutex{},
+	}
+}
+
+func (n *netSim) Conditions() NetworkConditions {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	return n.conditions
+}
+
+func (n *netSim) SetConditions(c NetworkConditions) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.conditions = c
+}
+
+// delay is how long to hold the next response or event.
+func (n *netSim) delay() time.Duration {
+	c := n.Conditions()
+	if !c.Enabled {
+		return 0
+	}
+	d := time.Duration(c.LatencyMs) * time.Millisecond
+	if c.JitterMs > 0 {
+		d += time.Duration(rand.Intn(2*c.JitterMs+1)-c.JitterMs) * time.Millisecond // #nosec G404
+	}
+	return max(d, 0)
+}
+
+// drop decides whether the next response or event is lost.
+func (n *netSim) drop() bool {
+	c := n.Conditions()
+	return c.Enabled && c.DropRate > 0 && rand.Float64() < c.DropRate // #nosec G404
+}
+
+// Middleware applies the simulated conditions to a response. A dropped
+// response has its connection closed without a reply.
+func (n *netSim) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(n.delay())
+		if n.drop() {
+			if hj, ok := w.(http.Hijacker); ok {
+				if conn, _, err := hj.Hijack(); err == nil {
+					conn.Close()
+					return
+				}
+			}
+			panic(http.ErrAbortHandler)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// connect registers an event stream client. It returns false if the
+// client is in a simulated disconnect, otherwise a channel closed when
+// the client should be disconnected and a func to unregister it.
+func (n *netSim) connect(id string, r *http.Request) (<-chan struct{}, func(), bool) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if until, ok := n.disconnected[id]; ok {
+		if time.Now().Before(until) {
+			return nil, nil, false
+		}
+		delete(n.disconnected, id)
+	}
+	c := &netSimClient{
+		ID:          id,
+		RemoteAddr:  r.RemoteAddr,
+		UserAgent:   r.UserAgent(),
+		ConnectedAt: time.Now(),
+		kick:        make(chan struct{}),
+	}
+	if previous, ok := n.clients[id]; ok {
+		// ids are per page load, so this is the same page reconnecting and
+		// the stream it replaces is dead
+		close(previous.kick)
+	}
+	n.clients[id] = c
+	return c.kick, func() {
+		n.lock.Lock()
+		defer n.lock.Unlock()
+		if n.clients[id] == c {
+			delete(n.clients, id)
+		}
+	}, true
+}
+
+// Disconnect drops the client's event stream and refuses to let it
+// reconnect for d. It reports whether the client was connected.
+func (n *netSim) Disconnect(id string, d time.Duration) bool {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.disconnected[id] = time.Now().Add(d)
+	c, ok := n.clients[id]
+	if ok {
+		close(c.kick)
+		delete(n.clients, id)
+	}
+	return ok
+}
+
+func (n *netSim) Clients() []*netSimClient {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	clients := make([]*netSimClient, 0, len(n.clients))
+	for _, c := range n.clients {
+		clients = append(clients, c)
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].ConnectedAt.Before(clients[j].ConnectedAt) })
+	return clients
+}
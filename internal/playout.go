@@ -0,0 +1,229 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+)
+
+// Playout is a game played headlessly from Setup by a MovePicker.
+type Playout struct {
+	Setup   *SetupState
+	Moves   []*Move
+	Updates []json.RawMessage
+	// Finished is false if the playout stopped at its move limit.
+	Finished bool
+}
+
+// A MovePicker chooses the moves in a playout. Pick is given the latest
+// GameUpdate and a current player to move, and is asked again with the
+// game's error each time the move it picked is rejected. It returns an
+// error when it has no move to make.
+type MovePicker interface {
+	Pick(update json.RawMessage, position int, rejected error) (json.RawMessage, error)
+}
+
+// Play plays from setup until the game finishes or maxMoves have been made,
+// moving a random current player with the move picker chooses. A move the
+// game rejects is offered back to the picker, but one that crashes the game
+// ends the playout. The playout so far is returned along with any error.
+func Play(runner *Runner, setup *SetupState, picker MovePicker, rng *rand.Rand, maxMoves int) (*Playout, error) {
+	playout := &Playout{Setup: setup}
+	update, err := runner.InitialState(setup)
+	if err != nil {
+		return playout, fmt.Errorf("initialState: %w", err)
+	}
+	playout.Updates = append(playout.Updates, update)
+	if err := runner.CheckInvariants(update); err != nil {
+		return playout, fmt.Errorf("initialState: %w", err)
+	}
+	for len(playout.Moves) < maxMoves {
+		var previous struct {
+			Game json.RawMessage `json:"game"`
+		}
+		if err := json.Unmarshal(update, &previous); err != nil {
+			return playout, err
+		}
+		status := &gameStatus{}
+		if err := json.Unmarshal(previous.Game, status); err != nil {
+			return playout, err
+		}
+		if status.Phase == "finished" {
+			playout.Finished = true
+			return playout, nil
+		}
+		if len(status.CurrentPlayers) == 0 {
+			return playout, fmt.Errorf("game is %q with no current players", status.Phase)
+		}
+		position := status.CurrentPlayers[rng.Intn(len(status.CurrentPlayers))]
+		var rejected error
+		for {
+			data, err := picker.Pick(update, position, rejected)
+			if err != nil {
+				return playout, err
+			}
+			move := &Move{Position: position, Data: data}
+			next, err := runner.ProcessMove(previous.Game, move)
+			if err != nil && !isCrash(err) {
+				rejected = err
+				continue
+			}
+			if err != nil {
+				return playout, fmt.Errorf("move %d by position %d: %w", len(playout.Moves)+1, position, err)
+			}
+			playout.Moves = append(playout.Moves, move)
+			playout.Updates = append(playout.Updates, next)
+			if err := runner.CheckInvariants(next); err != nil {
+				return playout, fmt.Errorf("move %d by position %d: %w", len(playout.Moves), position, err)
+			}
+			update = next
+			break
+		}
+	}
+	return playout, nil
+}
+
+// RandomPlayout plays moves chosen at random from the game's enumerateMoves
+// export. See Play.
+func RandomPlayout(runner *Runner, setup *SetupState, rng *rand.Rand, maxMoves int) (*Playout, error) {
+	return Play(runner, setup, &RandomPicker{Runner: runner, Rand: rng}, rng, maxMoves)
+}
+
+// RandomPicker picks moves at random from the game's enumerateMoves
+// export, trying the others in turn when one is rejected.
+type RandomPicker struct {
+	Runner     *Runner
+	Rand       *rand.Rand
+	candidates []json.RawMessage
+	tried      int
+}
+
+func (p *RandomPicker) Pick(update json.RawMessage, position int, rejected error) (json.RawMessage, error) {
+	if rejected == nil {
+		var u struct {
+			Game json.RawMessage `json:"game"`
+		}
+		if err := json.Unmarshal(update, &u); err != nil {
+			return nil, err
+		}
+		candidates, err := p.Runner.EnumerateMoves(u.Game, position)
+		if err != nil {
+			return nil, fmt.Errorf("enumerateMoves for position %d: %w", position, err)
+		}
+		p.Rand.Shuffle(len(candidates), func(i, j int) { candidates[i], candidates[j] = candidates[j], candidates[i] })
+		p.candidates = candidates
+		p.tried = 0
+	}
+	if p.tried == len(p.candidates) {
+		return nil, fmt.Errorf("none of the %d moves enumerated for position %d were accepted", len(p.candidates), position)
+	}
+	p.tried++
+	return p.candidates[p.tried-1], nil
+}
+
+// BotPicker asks a bot per position for moves, starting them with Command
+// in Dir when first needed and again after they fail.
+type BotPicker struct {
+	Dir      string
+	Command  []string
+	bots     map[int]*Bot
+	attempts int
+}
+
+func (p *BotPicker) Pick(update json.RawMessage, position int, rejected error) (json.RawMessage, error) {
+	if rejected == nil {
+		p.attempts = 0
+	}
+	p.attempts++
+	if p.attempts > botAttempts {
+		return nil, fmt.Errorf("bot for position %d gave up after %d attempts: %s", position, botAttempts, firstLine(rejected.Error()))
+	}
+	bot, err := p.bot(position)
+	if err != nil {
+		return nil, err
+	}
+	var u struct {
+		Game gameStatus `json:"game"`
+	}
+	if err := json.Unmarshal(update, &u); err != nil {
+		return nil, err
+	}
+	views, err := PlayerViews(update)
+	if err != nil {
+		return nil, err
+	}
+	event := &BotEvent{Type: "gameUpdate", State: views[fmt.Sprint(position)], Position: position, CurrentPlayers: u.Game.CurrentPlayers}
+	if rejected != nil {
+		event.Error = firstLine(rejected.Error())
+	}
+	data, err := bot.Move(event)
+	if err != nil {
+		bot.Close()
+		delete(p.bots, position)
+		return nil, fmt.Errorf("bot for position %d: %w", position, err)
+	}
+	return data, nil
+}
+
+func (p *BotPicker) bot(position int) (*Bot, error) {
+	if p.bots == nil {
+		p.bots = map[int]*Bot{}
+	}
+	if bot := p.bots[position]; bot != nil {
+		return bot, nil
+	}
+	bot, err := StartBot(p.Dir, p.Command)
+	if err != nil {
+		return nil, err
+	}
+	p.bots[position] = bot
+	return bot, nil
+}
+
+// Finish tells the bots the game in update is over.
+func (p *BotPicker) Finish(update json.RawMessage) error {
+	var u struct {
+		Game gameStatus `json:"game"`
+	}
+	if err := json.Unmarshal(update, &u); err != nil {
+		return err
+	}
+	views, err := PlayerViews(update)
+	if err != nil {
+		return err
+	}
+	for position, bot := range p.bots {
+		event := &BotEvent{Type: "gameFinished", State: views[fmt.Sprint(position)], Position: position, Winners: u.Game.Winners}
+		if err := bot.Send(event); err != nil {
+			bot.Close()
+			delete(p.bots, position)
+		}
+	}
+	return nil
+}
+
+func (p *BotPicker) Close() {
+	for position, bot := range p.bots {
+		bot.Close()
+		delete(p.bots, position)
+	}
+}
+
+// SaveState records the playout so it can be loaded in the dev UI.
+func (p *Playout) SaveState(gameStateVersion int) *SaveStateData {
+	saveState := &SaveStateData{
+		FormatVersion:    SaveStateFormatVersion,
+		GameStateVersion: gameStateVersion,
+		RandomSeed:       p.Setup.RandomSeed,
+		Settings:         p.Setup.Settings,
+		Players:          p.Setup.Players,
+		History:          []*HistoryItem{},
+	}
+	if len(p.Updates) != 0 {
+		saveState.InitialState = InitialStateHistoryItem{State: p.Updates[0], Players: p.Setup.Players, Settings: p.Setup.Settings}
+	}
+	for i, m := range p.Moves {
+		saveState.History = append(saveState.History, &HistoryItem{Seq: i, State: p.Updates[i+1], Data: m.Data, Position: m.Position})
+	}
+	return saveState
+}
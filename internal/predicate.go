@@ -0,0 +1,70 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// Predicate is a condition on a GameUpdate: an Assertion, or a gjson path
+// that must lead to a value other than false or null, such as
+// `game.state.hands.1.#(rank=="A")`.
+type Predicate struct {
+	Source string
+
+	check *Assertion
+	path  string
+}
+
+func ParsePredicate(s string) (*Predicate, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, fmt.Errorf("missing predicate")
+	}
+	if !assertionPattern.MatchString(s) {
+		if hasBareOperator(s) {
+			return nil, fmt.Errorf("predicate %q compares without spaces around the operator, write it like `a == b`", s)
+		}
+		return &Predicate{Source: s, path: s}, nil
+	}
+	check, err := ParseAssertion(s)
+	if err != nil {
+		return nil, err
+	}
+	return &Predicate{Source: s, check: check}, nil
+}
+
+// Match returns whether the predicate holds in update.
+func (p *Predicate) Match(update json.RawMessage) bool {
+	if p.check != nil {
+		ok, _, _ := p.check.Check(update)
+		return ok
+	}
+	v := gjson.GetBytes(update, p.path)
+	return v.Exists() && v.Type != gjson.Null && v.Type != gjson.False
+}
+
+// hasBareOperator returns whether s has a comparison operator outside the
+// brackets and quotes of a gjson query, where it can't be part of a path.
+func hasBareOperator(s string) bool {
+	depth := 0
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\':
+			i++
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '(' || c == '[' || c == '{':
+			depth++
+		case c == ')' || c == ']' || c == '}':
+			depth--
+		case depth == 0 && strings.ContainsRune("=!<>", rune(c)):
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,226 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/gookit/color"
+)
+
+// profiledMethods are the calls into the game that are timed.
+var profiledMethods = map[string]bool{
+	"initialState":     true,
+	"processMove":      true,
+	"getPlayerState":   true,
+	"reprocessHistory": true,
+}
+
+// ProfiledMethods lists the calls into the game that are timed.
+func ProfiledMethods() []string {
+	methods := []string{}
+	for m := range profiledMethods {
+		methods = append(methods, m)
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// Timing is one call into the game, timed where the game runs so it leaves
+// out the cost of talking to it.
+type Timing struct {
+	Method string `json:"method"`
+	// Duration is in milliseconds.
+	Duration float64 `json:"duration"`
+	// Size is the length of the serialized result in bytes.
+	Size int `json:"size"`
+}
+
+// Profile collects timings of the calls into a game.
+type Profile struct {
+	// OnRecord, if set, is called with each timing as it is recorded.
+	OnRecord func(t *Timing)
+	// Limit, if set, is how many timings are kept for each method. Past it
+	// a random sample of the calls is kept, so a long session stays within
+	// bounds and its percentiles stay representative.
+	Limit int
+
+	timings map[string][]*Timing
+	calls   map[string]int
+	lock    sync.Mutex
+}
+
+func NewProfile() *Profile {
+	return &Profile{timings: map[string][]*Timing{}, calls: map[string]int{}}
+}
+
+func (p *Profile) Record(t *Timing) {
+	p.lock.Lock()
+	p.calls[t.Method]++
+	if p.Limit == 0 || len(p.timings[t.Method]) < p.Limit {
+		p.timings[t.Method] = append(p.timings[t.Method], t)
+	} else if i := rand.Intn(p.calls[t.Method]); i < p.Limit { // #nosec G404
+		// reservoir sampling, every call is equally likely to be kept
+		p.timings[t.Method][i] = t
+	}
+	p.lock.Unlock()
+	if p.OnRecord != nil {
+		p.OnRecord(t)
+	}
+}
+
+// Reset forgets the timings so far, such as those taken while the game
+// warmed up.
+func (p *Profile) Reset() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.timings = map[string][]*Timing{}
+	p.calls = map[string]int{}
+}
+
+// MethodProfile summarizes the calls to one method.
+type MethodProfile struct {
+	Method string `json:"method"`
+	Calls  int    `json:"calls"`
+	// Duration is in milliseconds.
+	Duration *Percentiles `json:"duration"`
+	// Size is in bytes.
+	Size *Percentiles `json:"size"`
+}
+
+// Summary is a MethodProfile for each method called, in name order.
+func (p *Profile) Summary() []*MethodProfile {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	methods := []string{}
+	for m := range p.timings {
+		methods = append(methods, m)
+	}
+	sort.Strings(methods)
+	summary := []*MethodProfile{}
+	for _, m := range methods {
+		durations := []float64{}
+		sizes := []float64{}
+		for _, t := range p.timings[m] {
+			durations = append(durations, t.Duration)
+			sizes = append(sizes, float64(t.Size))
+		}
+		summary = append(summary, &MethodProfile{
+			Method:   m,
+			Calls:    p.calls[m],
+			Duration: NewPercentiles(durations),
+			Size:     NewPercentiles(sizes),
+		})
+	}
+	return summary
+}
+
+// serverProfileLimit is how many timings of each method the dev server
+// keeps over a session.
+const serverProfileLimit = 1000
+
+// slowCall is how long a call into the game can take before the dev server
+// points it out, in milliseconds.
+const slowCall = 100
+
+// recordTiming takes a timing from the game frame, which measures the game
+// as it runs in the browser.
+func (s *Server) recordTiming(w http.ResponseWriter, r *http.Request) {
+	t := &Timing{}
+	if err := json.NewDecoder(r.Body).Decode(t); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !profiledMethods[t.Method] {
+		http.Error(w, fmt.Sprintf("%q is not timed", t.Method), http.StatusBadRequest)
+		return
+	}
+	s.profile.Record(t)
+	w.WriteHeader(204)
+}
+
+// reportSlowCall points out calls into the game slow enough to make the
+// game feel laggy.
+func reportSlowCall(t *Timing) {
+	if t.Duration < slowCall {
+		return
+	}
+	color.Printf("<yellow>🐢 %s took %.0fms</> <gray>returning %s</>\n", t.Method, t.Duration, FormatBytes(t.Size))
+}
+
+func (s *Server) serveTimings(w http.ResponseWriter, r *http.Request) {
+	var timingsResponse struct {
+		Methods []*MethodProfile `json:"methods"`
+	}
+	timingsResponse.Methods = s.profile.Summary()
+	w.Header().Add("Content-type", "application/json")
+	w.Header().Add("Cache-control", "no-store")
+	if err := json.NewEncoder(w).Encode(timingsResponse); err != nil {
+		fmt.Printf("error: %#v\n", err)
+	}
+}
+
+// FormatBytes is n as B, KB or MB.
+func FormatBytes(n int) string {
+	switch {
+	case n >= 1024*1024:
+		return fmt.Sprintf("%.1fMB", float64(n)/(1024*1024))
+	case n >= 1024:
+		return fmt.Sprintf("%.1fKB", float64(n)/1024)
+	}
+	return fmt.Sprintf("%dB", n)
+}
+
+// ProfileSaveState replays a save state with the calls the platform makes
+// while a game is played, so their timings are recorded in the runner's
+// profile: initialState, processMove for each move, getPlayerState for each
+// player after it if withPlayerState is set, then reprocessHistory.
+func ProfileSaveState(runner *Runner, saveState *SaveStateData, withPlayerState bool) error {
+	setup := &SetupState{RandomSeed: saveState.RandomSeed, Players: saveState.Players, Settings: saveState.Settings}
+	moves := []*Move{}
+	for _, h := range saveState.History {
+		moves = append(moves, &Move{Position: h.Position, Data: h.Data})
+	}
+	update, err := runner.InitialState(setup)
+	if err != nil {
+		return fmt.Errorf("initialState: %w", err)
+	}
+	for i, m := range moves {
+		var previous struct {
+			Game json.RawMessage `json:"game"`
+		}
+		if err := json.Unmarshal(update, &previous); err != nil {
+			return err
+		}
+		if update, err = runner.ProcessMove(previous.Game, m); err != nil {
+			return fmt.Errorf("move %d by position %d: %w", i+1, m.Position, err)
+		}
+		if !withPlayerState {
+			continue
+		}
+		var u struct {
+			Game struct {
+				State json.RawMessage `json:"state"`
+			} `json:"game"`
+		}
+		if err := json.Unmarshal(update, &u); err != nil {
+			return err
+		}
+		for _, p := range setup.Players {
+			if _, err := runner.GetPlayerState(u.Game.State, p.Position); err != nil {
+				return fmt.Errorf("getPlayerState after move %d for position %d: %w", i+1, p.Position, err)
+			}
+		}
+	}
+	result, err := runner.ReprocessHistory(setup, moves)
+	if err != nil {
+		return fmt.Errorf("reprocessHistory: %w", err)
+	}
+	if result.Error != "" {
+		return fmt.Errorf("reprocessHistory: %s", result.Error)
+	}
+	return nil
+}
@@ -0,0 +1,276 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gookit/color"
+	"github.com/tidwall/gjson"
+)
+
+// shellMessages are sent by the dev shell's own script in ui.html rather
+// than the game's UI.
+var shellMessages = map[string]bool{
+	"key":               true,
+	"sendDark":          true,
+	"crossOriginAccess": true,
+}
+
+// messageField is a field of a message the UI sends. Kind is the JSON type
+// it must have, or "position" for a whole number, or "any".
+type messageField struct {
+	Name     string
+	Kind     string
+	Required bool
+}
+
+// messageSchema is a message the UI may send, as in interface.md.
+type messageSchema struct {
+	Fields []messageField
+	// Phases are those of the game the message may be sent in.
+	Phases   []string
+	HostOnly bool
+}
+
+var uiMessageSchemas = map[string]*messageSchema{
+	"updateSettings": {
+		Fields: []messageField{
+			{Name: "id", Kind: "string", Required: true},
+			{Name: "settings", Kind: "object", Required: true},
+			{Name: "seatCount", Kind: "position", Required: true},
+		},
+		Phases:   []string{"new"},
+		HostOnly: true,
+	},
+	"updatePlayers": {
+		Fields: []messageField{
+			{Name: "id", Kind: "string", Required: true},
+			{Name: "operations", Kind: "array", Required: true},
+		},
+		Phases: []string{"new"},
+	},
+	"ready": {
+		Phases: []string{"new", "started", "finished"},
+	},
+	"move": {
+		Fields: []messageField{
+			{Name: "id", Kind: "string", Required: true},
+			{Name: "data", Kind: "any", Required: true},
+		},
+		Phases: []string{"started"},
+	},
+}
+
+// playerOperationSchemas are the operations in an updatePlayers message.
+// A non-host may only operate on their own user.
+var playerOperationSchemas = map[string]*messageSchema{
+	"seat": {
+		Fields: []messageField{
+			{Name: "position", Kind: "position", Required: true},
+			{Name: "userID", Kind: "string", Required: true},
+			{Name: "color", Kind: "string", Required: true},
+			{Name: "name", Kind: "string", Required: true},
+			{Name: "settings", Kind: "any"},
+		},
+	},
+	"unseat": {
+		Fields: []messageField{
+			{Name: "userID", Kind: "string", Required: true},
+		},
+	},
+	"update": {
+		Fields: []messageField{
+			{Name: "userID", Kind: "string", Required: true},
+			{Name: "color", Kind: "string"},
+			{Name: "name", Kind: "string"},
+			{Name: "ready", Kind: "boolean"},
+			{Name: "settings", Kind: "any"},
+		},
+	},
+}
+
+// UIMessage is a message the UI posted to the host, with what the dev
+// shell knew when it arrived.
+type UIMessage struct {
+	Message json.RawMessage `json:"message"`
+	// Phase is "new", "started" or "finished".
+	Phase     string `json:"phase"`
+	UserID    string `json:"userID"`
+	Host      bool   `json:"host"`
+	SeatCount int    `json:"seatCount"`
+}
+
+// ValidateUIMessage lists the ways m breaks the protocol in interface.md
+// for the phase it was sent in and the user who sent it.
+func ValidateUIMessage(m *UIMessage, manifest *ManifestV1) []string {
+	if !gjson.ValidBytes(m.Message) || !gjson.ParseBytes(m.Message).IsObject() {
+		return []string{"message is not an object"}
+	}
+	message := gjson.ParseBytes(m.Message)
+	t := message.Get("type")
+	if t.Type != gjson.String {
+		return []string{"type must be a string"}
+	}
+	schema, ok := uiMessageSchemas[t.Str]
+	if !ok {
+		return []string{fmt.Sprintf("unknown message type %q", t.Str)}
+	}
+	problems := checkFields(message, "", schema.Fields)
+	allowed := false
+	for _, phase := range schema.Phases {
+		allowed = allowed || phase == m.Phase
+	}
+	if !allowed {
+		problems = append(problems, fmt.Sprintf("sent while the game is %s, only allowed while it is %s", m.Phase, strings.Join(schema.Phases, " or ")))
+	}
+	if schema.HostOnly && !m.Host {
+		problems = append(problems, "only the host may send it")
+	}
+	switch t.Str {
+	case "updateSettings":
+		if seatCount := message.Get("seatCount"); isInteger(seatCount) && (int(seatCount.Num) < manifest.MinimumPlayers || int(seatCount.Num) > manifest.MaximumPlayers) {
+			problems = append(problems, fmt.Sprintf("seatCount %d is outside the game's %d to %d players", int(seatCount.Num), manifest.MinimumPlayers, manifest.MaximumPlayers))
+		}
+	case "updatePlayers":
+		for i, op := range message.Get("operations").Array() {
+			problems = append(problems, checkPlayerOperation(op, fmt.Sprintf("operations.%d", i), m)...)
+		}
+	}
+	return problems
+}
+
+func checkPlayerOperation(op gjson.Result, path string, m *UIMessage) []string {
+	if !op.IsObject() {
+		return []string{fmt.Sprintf("%s must be an object", path)}
+	}
+	t := op.Get("type")
+	schema, ok := playerOperationSchemas[t.Str]
+	if t.Type != gjson.String || !ok {
+		return []string{fmt.Sprintf("%s.type is %s, not seat, unseat or update", path, t.Raw)}
+	}
+	problems := checkFields(op, path+".", schema.Fields)
+	if userID := op.Get("userID"); !m.Host && userID.Type == gjson.String && userID.Str != m.UserID {
+		problems = append(problems, fmt.Sprintf("%s: %s for user %q by %q, who is not the host", path, t.Str, userID.Str, m.UserID))
+	}
+	if position := op.Get("position"); isInteger(position) && (position.Num < 1 || (m.SeatCount != 0 && int(position.Num) > m.SeatCount)) {
+		problems = append(problems, fmt.Sprintf("%s.position %d is not one of the %d seats", path, int(position.Num), m.SeatCount))
+	}
+	return problems
+}
+
+func checkFields(v gjson.Result, prefix string, fields []messageField) []string {
+	problems := []string{}
+	for _, f := range fields {
+		value := v.Get(f.Name)
+		if !value.Exists() {
+			if f.Required {
+				problems = append(problems, fmt.Sprintf("missing %s%s", prefix, f.Name))
+			}
+			continue
+		}
+		ok := true
+		switch f.Kind {
+		case "string":
+			ok = value.Type == gjson.String
+		case "boolean":
+			ok = value.IsBool()
+		case "position":
+			ok = isInteger(value)
+		case "object":
+			ok = value.IsObject()
+		case "array":
+			ok = value.IsArray()
+		}
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s%s is %s, not a %s", prefix, f.Name, value.Raw, f.Kind))
+		}
+	}
+	return problems
+}
+
+// protocolReport collects the protocol violations in messages from the UI,
+// printing each the first time it is seen.
+type protocolReport struct {
+	counts map[ProtocolViolation]int
+	lock   sync.Mutex
+}
+
+// ProtocolViolation is a way a message of Type broke the protocol.
+type ProtocolViolation struct {
+	Type    string `json:"type"`
+	Problem string `json:"problem"`
+}
+
+// add counts the violations, returning those not seen before.
+func (p *protocolReport) add(violations []ProtocolViolation) []ProtocolViolation {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	fresh := []ProtocolViolation{}
+	for _, v := range violations {
+		if p.counts[v] == 0 {
+			fresh = append(fresh, v)
+		}
+		p.counts[v]++
+	}
+	return fresh
+}
+
+// recordUIMessage validates a copy of a message the UI posted to the dev
+// shell, reporting new violations in the shell and to the dev UI.
+func (s *Server) recordUIMessage(w http.ResponseWriter, r *http.Request) {
+	m := &UIMessage{}
+	if err := json.NewDecoder(r.Body).Decode(m); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	t := gjson.GetBytes(m.Message, "type").String()
+	if shellMessages[t] {
+		w.WriteHeader(204)
+		return
+	}
+	violations := []ProtocolViolation{}
+	for _, problem := range ValidateUIMessage(m, s.manifest) {
+		violations = append(violations, ProtocolViolation{Type: t, Problem: problem})
+	}
+	if fresh := s.protocolReport.add(violations); len(fresh) != 0 {
+		for _, v := range fresh {
+			color.Printf("<yellow>⚠️  UI sent an invalid %s message:</> %s <gray>%s</>\n", v.Type, v.Problem, compactJSON(m.Message))
+		}
+		s.broadcast(&protocolViolationEvent{Type: "protocolViolation", Violations: fresh})
+	}
+	w.WriteHeader(204)
+}
+
+type protocolViolationEvent struct {
+	Type       string              `json:"type"`
+	Violations []ProtocolViolation `json:"violations"`
+}
+
+func (s *Server) serveProtocolReport(w http.ResponseWriter, r *http.Request) {
+	type entry struct {
+		ProtocolViolation
+		Count int `json:"count"`
+	}
+	var reportResponse struct {
+		Violations []*entry `json:"violations"`
+	}
+	reportResponse.Violations = []*entry{}
+	s.protocolReport.lock.Lock()
+	for v, count := range s.protocolReport.counts {
+		reportResponse.Violations = append(reportResponse.Violations, &entry{v, count})
+	}
+	s.protocolReport.lock.Unlock()
+	sort.Slice(reportResponse.Violations, func(i, j int) bool {
+		a, b := reportResponse.Violations[i], reportResponse.Violations[j]
+		return a.Type < b.Type || (a.Type == b.Type && a.Problem < b.Problem)
+	})
+	w.Header().Add("Content-type", "application/json")
+	w.Header().Add("Cache-control", "no-store")
+	if err := json.NewEncoder(w).Encode(reportResponse); err != nil {
+		fmt.Printf("error: %#v\n", err)
+	}
+}
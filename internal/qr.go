@@ -0,0 +1,413 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+)
+
+// QRCode is a QR code symbol encoding text in byte mode with low error
+// correction, which is plenty for scanning a URL off a terminal.
+type QRCode struct {
+	Size    int
+	modules [][]bool
+}
+
+type qrVersion struct {
+	eccPerBlock int
+	// data codewords for each block, blocks in the first group come first
+	blocks []int
+	align  []int
+}
+
+// qrVersions holds the error correction level L block structure of
+// versions 1 through 10, indexed by version-1.
+var qrVersions = []qrVersion{
+	{7, []int{19}, nil},
+	{10, []int{34}, []int{6, 18}},
+	{15, []int{55}, []int{6, 22}},
+	{20, []int{80}, []int{6, 26}},
+	{26, []int{108}, []int{6, 30}},
+	{18, []int{68, 68}, []int{6, 34}},
+	{20, []int{78, 78}, []int{6, 22, 38}},
+	{24, []int{97, 97}, []int{6, 24, 42}},
+	{30, []int{116, 116}, []int{6, 26, 46}},
+	{18, []int{68, 68, 69, 69}, []int{6, 28, 50}},
+}
+
+const qrFormatBitsL = 1
+
+// EncodeQR encodes text as the smallest QR code that can hold it.
+func EncodeQR(text string) (*QRCode, error) {
+	data := []byte(text)
+	for i, v := range qrVersions {
+		version := i + 1
+		capacity := 0
+		for _, b := range v.blocks {
+			capacity += b
+		}
+		countBits := 8
+		if version >= 10 {
+			countBits = 16
+		}
+		if 4+countBits+len(data)*8 > capacity*8 {
+			continue
+		}
+		codewords := qrDataCodewords(data, countBits, capacity)
+		return newQRCode(version, v, qrAddECC(codewords, v)), nil
+	}
+	return nil, fmt.Errorf("text of %d bytes is too long for a QR code", len(data))
+}
+
+func qrDataCodewords(data []byte, countBits, capacity int) []byte {
+	bits := []bool{}
+	appendBits := func(val, n int) {
+		for i := n - 1; i >= 0; i-- {
+			bits = append(bits, (val>>i)&1 != 0)
+		}
+	}
+	appendBits(0b0100, 4)
+	appendBits(len(data), countBits)
+	for _, b := range data {
+		appendBits(int(b), 8)
+	}
+	appendBits(0, min(4, capacity*8-len(bits)))
+	appendBits(0, (8-len(bits)%8)%8)
+	for pad := 0xEC; len(bits) < capacity*8; pad ^= 0xEC ^ 0x11 {
+		appendBits(pad, 8)
+	}
+	codewords := make([]byte, capacity)
+	for i, bit := range bits {
+		if bit {
+			codewords[i>>3] |= 1 << (7 - i&7)
+		}
+	}
+	return codewords
+}
+
+// qrAddECC splits data into blocks, computes each block's Reed-Solomon
+// error correction and interleaves the result.
+func qrAddECC(data []byte, v qrVersion) []byte {
+	generator := qrGenerator(v.eccPerBlock)
+	blocks := make([][]byte, len(v.blocks))
+	eccs := make([][]byte, len(v.blocks))
+	offset := 0
+	for i, n := range v.blocks {
+		blocks[i] = data[offset : offset+n]
+		eccs[i] = qrRemainder(blocks[i], generator)
+		offset += n
+	}
+	result := []byte{}
+	for i := 0; i < v.blocks[len(v.blocks)-1]; i++ {
+		for _, b := range blocks {
+			if i < len(b) {
+				result = append(result, b[i])
+			}
+		}
+	}
+	for i := 0; i < v.eccPerBlock; i++ {
+		for _, e := range eccs {
+			result = append(result, e[i])
+		}
+	}
+	return result
+}
+
+func qrMultiply(x, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11D)
+		z ^= int((y>>i)&1) * int(x)
+	}
+	return byte(z)
+}
+
+// qrGenerator returns the coefficients of the degree n generator
+// polynomial, highest power first, excluding the leading 1.
+func qrGenerator(n int) []byte {
+	result := make([]byte, n)
+	result[n-1] = 1
+	root := byte(1)
+	for i := 0; i < n; i++ {
+		for j := 0; j < n; j++ {
+			result[j] = qrMultiply(result[j], root)
+			if j+1 < n {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = qrMultiply(root, 0x02)
+	}
+	return result
+}
+
+func qrRemainder(data, generator []byte) []byte {
+	result := make([]byte, len(generator))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i := range result {
+			result[i] ^= qrMultiply(generator[i], factor)
+		}
+	}
+	return result
+}
+
+func newQRCode(version int, v qrVersion, codewords []byte) *QRCode {
+	size := version*4 + 17
+	q := &QRCode{Size: size, modules: make([][]bool, size)}
+	function := make([][]bool, size)
+	for i := range q.modules {
+		q.modules[i] = make([]bool, size)
+		function[i] = make([]bool, size)
+	}
+	set := func(x, y int, dark bool) {
+		q.modules[y][x] = dark
+		function[y][x] = true
+	}
+
+	for i := 0; i < size; i++ {
+		set(6, i, i%2 == 0)
+		set(i, 6, i%2 == 0)
+	}
+	for _, c := range [][2]int{{3, 3}, {size - 4, 3}, {3, size - 4}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := c[0]+dx, c[1]+dy
+				if x < 0 || y < 0 || x >= size || y >= size {
+					continue
+				}
+				dist := max(abs(dx), abs(dy))
+				set(x, y, dist != 2 && dist != 4)
+			}
+		}
+	}
+	last := len(v.align) - 1
+	for i, ay := range v.align {
+		for j, ax := range v.align {
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					set(ax+dx, ay+dy, max(abs(dx), abs(dy)) != 1)
+				}
+			}
+		}
+	}
+	// reserve the format area, it is drawn for real once the mask is chosen
+	q.drawFormat(0, set)
+	if version >= 7 {
+		bits := qrVersionBits(version)
+		for i := 0; i < 18; i++ {
+			dark := (bits>>i)&1 != 0
+			a, b := size-11+i%3, i/3
+			set(a, b, dark)
+			set(b, a, dark)
+		}
+	}
+
+	i := 0
+	for right := size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+				if (right+1)&2 == 0 {
+					y = size - 1 - vert
+				}
+				if function[y][x] || i >= len(codewords)*8 {
+					continue
+				}
+				q.modules[y][x] = (codewords[i>>3]>>(7-i&7))&1 != 0
+				i++
+			}
+		}
+	}
+
+	bestMask, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		q.applyMask(mask, function)
+		q.drawFormat(mask, set)
+		if p := q.penalty(); bestPenalty < 0 || p < bestPenalty {
+			bestMask, bestPenalty = mask, p
+		}
+		q.applyMask(mask, function)
+	}
+	q.applyMask(bestMask, function)
+	q.drawFormat(bestMask, set)
+	return q
+}
+
+func (q *QRCode) applyMask(mask int, function [][]bool) {
+	for y := 0; y < q.Size; y++ {
+		for x := 0; x < q.Size; x++ {
+			if function[y][x] {
+				continue
+			}
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if invert {
+				q.modules[y][x] = !q.modules[y][x]
+			}
+		}
+	}
+}
+
+func (q *QRCode) drawFormat(mask int, set func(x, y int, dark bool)) {
+	bits := qrFormatBits(mask)
+	bit := func(i int) bool { return (bits>>i)&1 != 0 }
+	for i := 0; i <= 5; i++ {
+		set(8, i, bit(i))
+	}
+	set(8, 7, bit(6))
+	set(8, 8, bit(7))
+	set(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		set(14-i, 8, bit(i))
+	}
+	for i := 0; i < 8; i++ {
+		set(q.Size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		set(8, q.Size-15+i, bit(i))
+	}
+	set(8, q.Size-8, true)
+}
+
+func qrFormatBits(mask int) int {
+	data := qrFormatBitsL<<3 | mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	return (data<<10 | rem) ^ 0x5412
+}
+
+func qrVersionBits(version int) int {
+	rem := version
+	for i := 0; i < 12; i++ {
+		rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+	}
+	return version<<12 | rem
+}
+
+// penalty scores how hard the symbol is to scan, lower is better.
+func (q *QRCode) penalty() int {
+	penalty := 0
+	finder := []bool{true, false, true, true, true, false, true}
+	line := func(get func(i int) bool) {
+		run := 1
+		for i := 1; i <= q.Size; i++ {
+			if i < q.Size && get(i) == get(i-1) {
+				run++
+				continue
+			}
+			if run >= 5 {
+				penalty += 3 + run - 5
+			}
+			run = 1
+		}
+		for i := 0; i+7 <= q.Size; i++ {
+			match := true
+			for j, f := range finder {
+				if get(i+j) != f {
+					match = false
+					break
+				}
+			}
+			if !match {
+				continue
+			}
+			lightBefore, lightAfter := true, true
+			for j := 1; j <= 4; j++ {
+				if i-j >= 0 && get(i-j) {
+					lightBefore = false
+				}
+				if i+6+j < q.Size && get(i+6+j) {
+					lightAfter = false
+				}
+			}
+			if lightBefore || lightAfter {
+				penalty += 40
+			}
+		}
+	}
+	dark := 0
+	for y := 0; y < q.Size; y++ {
+		line(func(i int) bool { return q.modules[y][i] })
+		line(func(i int) bool { return q.modules[i][y] })
+		for x := 0; x < q.Size; x++ {
+			if q.modules[y][x] {
+				dark++
+			}
+			if x+1 < q.Size && y+1 < q.Size {
+				c := q.modules[y][x]
+				if q.modules[y][x+1] == c && q.modules[y+1][x] == c && q.modules[y+1][x+1] == c {
+					penalty += 3
+				}
+			}
+		}
+	}
+	total := q.Size * q.Size
+	penalty += abs(dark*20-total*10) / total * 10
+	return penalty
+}
+
+func (q *QRCode) Dark(x, y int) bool {
+	if x < 0 || y < 0 || x >= q.Size || y >= q.Size {
+		return false
+	}
+	return q.modules[y][x]
+}
+
+// Terminal renders the code with half block characters, two modules per
+// line, in explicit black and white so it scans on any terminal theme.
+func (q *QRCode) Terminal() string {
+	const quiet = 4
+	var b strings.Builder
+	for y := -quiet; y < q.Size+quiet; y += 2 {
+		b.WriteString("\x1b[97;40m")
+		for x := -quiet; x < q.Size+quiet; x++ {
+			top, bottom := !q.Dark(x, y), !q.Dark(x, y+1)
+			switch {
+			case top && bottom:
+				b.WriteString("█")
+			case top:
+				b.WriteString("▀")
+			case bottom:
+				b.WriteString("▄")
+			default:
+				b.WriteString(" ")
+			}
+		}
+		b.WriteString("\x1b[0m\n")
+	}
+	return b.String()
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
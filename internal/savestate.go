@@ -0,0 +1,389 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// SaveStateQuery selects which parts of a save state are returned. When
+// Meta is set no history entries are written, otherwise only entries with
+// From <= seq <= To are included. A negative To is unbounded.
+type SaveStateQuery struct {
+	Meta bool
+	From int
+	To   int
+}
+
+func ParseSaveStateQuery(v url.Values) (*SaveStateQuery, error) {
+	q := &SaveStateQuery{From: 0, To: -1}
+	if meta := v.Get("meta"); meta != "" {
+		m, err := strconv.ParseBool(meta)
+		if err != nil {
+			return nil, fmt.Errorf("invalid meta: %w", err)
+		}
+		q.Meta = m
+	}
+	if from := v.Get("from"); from != "" {
+		f, err := strconv.Atoi(from)
+		if err != nil || f < 0 {
+			return nil, fmt.Errorf("invalid from %q", from)
+		}
+		q.From = f
+	}
+	if to := v.Get("to"); to != "" {
+		t, err := strconv.Atoi(to)
+		if err != nil || t < 0 {
+			return nil, fmt.Errorf("invalid to %q", to)
+		}
+		q.To = t
+	}
+	if q.To >= 0 && q.To < q.From {
+		return nil, fmt.Errorf("to must not be less than from")
+	}
+	return q, nil
+}
+
+func (q *SaveStateQuery) includes(seq int) bool {
+	if q.Meta {
+		return false
+	}
+	return seq >= q.From && (q.To < 0 || seq <= q.To)
+}
+
+// StreamSaveState copies the save state read from r to w, decoding one
+// history entry at a time so large saves are never held in memory. The
+// output has the shape of SaveStateData with history filtered by q and an
+// extra historyLength field holding the unfiltered number of entries.
+func StreamSaveState(r io.Reader, w io.Writer, q *SaveStateQuery) error {
+	dec := json.NewDecoder(r)
+	if err := expectDelim(dec, '{'); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, "{"); err != nil {
+		return err
+	}
+	historyLength := 0
+	first := true
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key, ok := t.(string)
+		if !ok {
+			return fmt.Errorf("expected object key, got %v", t)
+		}
+		if !first {
+			if _, err := io.WriteString(w, ","); err != nil {
+				return err
+			}
+		}
+		first = false
+		keyJSON, err := json.Marshal(key)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "%s:", keyJSON); err != nil {
+			return err
+		}
+		if key != "history" {
+			var raw json.RawMessage
+			if err := dec.Decode(&raw); err != nil {
+				return err
+			}
+			if _, err := w.Write(raw); err != nil {
+				return err
+			}
+			continue
+		}
+		if historyLength, err = streamHistory(dec, w, q); err != nil {
+			return err
+		}
+	}
+	if err := expectDelim(dec, '}'); err != nil {
+		return err
+	}
+	if !first {
+		if _, err := io.WriteString(w, ","); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, `"historyLength":%d}`, historyLength)
+	return err
+}
+
+func streamHistory(dec *json.Decoder, w io.Writer, q *SaveStateQuery) (int, error) {
+	if err := expectDelim(dec, '['); err != nil {
+		return 0, err
+	}
+	if _, err := io.WriteString(w, "["); err != nil {
+		return 0, err
+	}
+	count := 0
+	written := 0
+	for dec.More() {
+		item := &HistoryItem{}
+		if err := dec.Decode(item); err != nil {
+			return count, err
+		}
+		count++
+		if !q.includes(item.Seq) {
+			continue
+		}
+		if written != 0 {
+			if _, err := io.WriteString(w, ","); err != nil {
+				return count, err
+			}
+		}
+		b, err := json.Marshal(item)
+		if err != nil {
+			return count, err
+		}
+		if _, err := w.Write(b); err != nil {
+			return count, err
+		}
+		written++
+	}
+	if err := expectDelim(dec, ']'); err != nil {
+		return count, err
+	}
+	_, err := io.WriteString(w, "]")
+	return count, err
+}
+
+func expectDelim(dec *json.Decoder, d json.Delim) error {
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if delim, ok := t.(json.Delim); !ok || delim != d {
+		return fmt.Errorf("expected %s, got %v", d, t)
+	}
+	return nil
+}
+
+// SaveStateFormatVersion is the current shape of SaveStateData. Saves
+// written before versioning was introduced are format 0.
+const SaveStateFormatVersion = 1
+
+// saveStateMigrations upgrade a save from the format version at index i to
+// version i+1.
+var saveStateMigrations = []func(saveState map[string]json.RawMessage) error{
+	// 0 -> 1: versions are recorded, history is never null
+	func(saveState map[string]json.RawMessage) error {
+		if h, ok := saveState["history"]; !ok || string(h) == "null" {
+			saveState["history"] = json.RawMessage("[]")
+		}
+		if _, ok := saveState["gameStateVersion"]; !ok {
+			saveState["gameStateVersion"] = json.RawMessage("0")
+		}
+		return nil
+	},
+}
+
+// ReadSaveStateFormatVersion finds the top level formatVersion of a save
+// state without decoding the rest of it.
+func ReadSaveStateFormatVersion(r io.Reader) (int, error) {
+	dec := json.NewDecoder(r)
+	if err := expectDelim(dec, '{'); err != nil {
+		return 0, err
+	}
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return 0, err
+		}
+		if t == "formatVersion" {
+			version := 0
+			if err := dec.Decode(&version); err != nil {
+				return 0, err
+			}
+			return version, nil
+		}
+		if err := skipValue(dec); err != nil {
+			return 0, err
+		}
+	}
+	return 0, nil
+}
+
+// UpgradeSaveState migrates a save state to the current format version.
+func UpgradeSaveState(data []byte) ([]byte, error) {
+	saveState := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &saveState); err != nil {
+		return nil, err
+	}
+	version := 0
+	if v, ok := saveState["formatVersion"]; ok {
+		if err := json.Unmarshal(v, &version); err != nil {
+			return nil, err
+		}
+	}
+	if version > SaveStateFormatVersion {
+		return nil, fmt.Errorf("save state format %d is newer than this version of bz supports (%d)", version, SaveStateFormatVersion)
+	}
+	for ; version < SaveStateFormatVersion; version++ {
+		if err := saveStateMigrations[version](saveState); err != nil {
+			return nil, fmt.Errorf("upgrading save state from format %d: %w", version, err)
+		}
+	}
+	saveState["formatVersion"] = json.RawMessage(strconv.Itoa(version))
+	return json.Marshal(saveState)
+}
+
+// UpgradeSaveStateFile rewrites the save state at target in the current
+// format if it was written by an older version.
+func UpgradeSaveStateFile(target string) error {
+	f, err := os.Open(filepath.Clean(target))
+	if err != nil {
+		return err
+	}
+	version, err := ReadSaveStateFormatVersion(bufio.NewReader(f))
+	f.Close()
+	if err != nil {
+		return err
+	}
+	if version == SaveStateFormatVersion {
+		return nil
+	}
+	data, err := os.ReadFile(filepath.Clean(target))
+	if err != nil {
+		return err
+	}
+	upgraded, err := UpgradeSaveState(data)
+	if err != nil {
+		return err
+	}
+	return WriteSaveStateFile(target, upgraded)
+}
+
+// OpenSaveStateFile opens the save state at target for streaming, upgrading
+// it in memory if it was written by an older version. The file itself is
+// left as it is.
+func OpenSaveStateFile(target string) (io.ReadCloser, error) {
+	f, err := os.Open(filepath.Clean(target))
+	if err != nil {
+		return nil, err
+	}
+	version, err := ReadSaveStateFormatVersion(bufio.NewReader(f))
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if version == SaveStateFormatVersion {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			f.Close()
+			return nil, err
+		}
+		return f, nil
+	}
+	f.Close()
+	data, err := os.ReadFile(filepath.Clean(target))
+	if err != nil {
+		return nil, err
+	}
+	upgraded, err := UpgradeSaveState(data)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(upgraded)), nil
+}
+
+// ValidSaveStateName returns whether name can be used as the file name of a
+// save state without leaving the save states directory.
+func ValidSaveStateName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, `/\`) && !strings.Contains(name, "..") && !strings.HasPrefix(name, ".")
+}
+
+// ReadSaveStateFile reads the save state at file, upgrading it in memory if
+// it is in an older format.
+func ReadSaveStateFile(file string) (*SaveStateData, error) {
+	data, err := os.ReadFile(filepath.Clean(file))
+	if err != nil {
+		return nil, err
+	}
+	if data, err = UpgradeSaveState(data); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	saveState := &SaveStateData{}
+	if err := json.Unmarshal(data, saveState); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return saveState, nil
+}
+
+// StampSaveState records the current format version on a save state about
+// to be written, and the game's state version unless the save already has
+// the one it was loaded with.
+func StampSaveState(data []byte, gameStateVersion int) ([]byte, error) {
+	if !gjson.ValidBytes(data) || !gjson.ParseBytes(data).IsObject() {
+		return nil, fmt.Errorf("save state must be a JSON object")
+	}
+	data, err := sjson.DeleteBytes(data, "formatVersion")
+	if err != nil {
+		return nil, err
+	}
+	// formatVersion goes first so ReadSaveStateFormatVersion finds it
+	// without reading the history
+	rest := bytes.TrimSpace(bytes.TrimSpace(data)[1:])
+	stamped := []byte(fmt.Sprintf(`{"formatVersion":%d`, SaveStateFormatVersion))
+	if rest[0] != '}' {
+		stamped = append(stamped, ',')
+	}
+	data = append(stamped, rest...)
+	if gjson.GetBytes(data, "gameStateVersion").Exists() {
+		return data, nil
+	}
+	return sjson.SetBytes(data, "gameStateVersion", gameStateVersion)
+}
+
+// WriteSaveStateFile replaces the save state at target, writing to a
+// temporary file first so a failed write never truncates a save.
+func WriteSaveStateFile(target string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Clean(target))
+}
+
+func skipValue(dec *json.Decoder) error {
+	depth := 0
+	for {
+		t, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		if d, ok := t.(json.Delim); ok {
+			switch d {
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+			}
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,302 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// Scenario is a rules situation captured as a file: a setup, moves made by
+// position, and assertions on the resulting GameUpdate.
+type Scenario struct {
+	Name     string          `json:"name"`
+	Players  int             `json:"players"`
+	Seed     string          `json:"seed"`
+	Settings json.RawMessage `json:"settings"`
+	Moves    []*ScenarioMove `json:"moves"`
+	// Assert is checked against the GameUpdate after the last move.
+	Assert []string `json:"assert"`
+}
+
+type ScenarioMove struct {
+	Position int             `json:"position"`
+	Data     json.RawMessage `json:"data"`
+	// Error, if set, expects the game to reject the move with an error
+	// containing it. The move is then skipped.
+	Error string `json:"error,omitempty"`
+	// Assert is checked against the GameUpdate after this move.
+	Assert []string `json:"assert,omitempty"`
+}
+
+type ScenarioResult struct {
+	File     string
+	Name     string
+	Duration time.Duration
+	Failures []string
+}
+
+func (r *ScenarioResult) Passed() bool {
+	return len(r.Failures) == 0
+}
+
+func LoadScenario(file string) (*Scenario, error) {
+	data, err := os.ReadFile(filepath.Clean(file))
+	if err != nil {
+		return nil, err
+	}
+	scenario := &Scenario{}
+	if err := json.Unmarshal(data, scenario); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	if scenario.Name == "" {
+		scenario.Name = strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	}
+	return scenario, nil
+}
+
+// Setup is the SetupState the scenario starts from, seating the first of
+// devUsers. The seed defaults to the scenario's name so runs repeat.
+func (s *Scenario) Setup(manifest *ManifestV1, devUsers []*DevUser) (*SetupState, error) {
+	players := s.Players
+	if players == 0 {
+		players = manifest.MinimumPlayers
+	}
+	if players < manifest.MinimumPlayers || players > manifest.MaximumPlayers {
+		return nil, fmt.Errorf("players must be between %d and %d", manifest.MinimumPlayers, manifest.MaximumPlayers)
+	}
+	seed := s.Seed
+	if seed == "" {
+		seed = s.Name
+	}
+	settings := s.Settings
+	if settings == nil {
+		settings = json.RawMessage("{}")
+	}
+	return &SetupState{
+		RandomSeed: seed,
+		Players:    SeatDevUsers(devUsers[:players]),
+		Settings:   settings,
+	}, nil
+}
+
+// RunScenario plays the scenario in file headlessly. Problems with the
+// scenario itself and the runner are returned as errors, everything the
+// game does wrong is a failure in the result.
+func RunScenario(runner *Runner, manifest *ManifestV1, devUsers []*DevUser, file string) (*ScenarioResult, error) {
+	start := time.Now()
+	scenario, err := LoadScenario(file)
+	if err != nil {
+		return nil, err
+	}
+	result := &ScenarioResult{File: file, Name: scenario.Name}
+	defer func() { result.Duration = time.Since(start) }()
+
+	setup, err := scenario.Setup(manifest, devUsers)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	for _, a := range append(scenario.Assert, allMoveAssertions(scenario)...) {
+		if _, err := ParseAssertion(a); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+	}
+
+	update, err := runner.InitialState(setup)
+	if err != nil {
+		if _, ok := err.(*GameError); ok {
+			result.Failures = append(result.Failures, fmt.Sprintf("initialState failed: %s", err))
+			return result, nil
+		}
+		return nil, err
+	}
+	if err := runner.CheckInvariants(update); err != nil {
+		result.Failures = append(result.Failures, fmt.Sprintf("in the initial state, %s", err))
+	}
+	for i, m := range scenario.Moves {
+		var previous struct {
+			Game json.RawMessage `json:"game"`
+		}
+		if err := json.Unmarshal(update, &previous); err != nil {
+			return nil, err
+		}
+		next, err := runner.ProcessMove(previous.Game, &Move{Position: m.Position, Data: m.Data})
+		if _, ok := err.(*GameError); err != nil && !ok {
+			return nil, err
+		}
+		switch {
+		case err != nil && m.Error == "":
+			result.Failures = append(result.Failures, fmt.Sprintf("move %d by position %d failed: %s", i+1, m.Position, firstLine(err.Error())))
+			return result, nil
+		case err != nil && !strings.Contains(err.Error(), m.Error):
+			result.Failures = append(result.Failures, fmt.Sprintf("move %d by position %d was expected to fail with %q, failed with: %s", i+1, m.Position, m.Error, firstLine(err.Error())))
+			continue
+		case err != nil:
+			continue
+		case m.Error != "":
+			result.Failures = append(result.Failures, fmt.Sprintf("move %d by position %d was expected to fail with %q but succeeded", i+1, m.Position, m.Error))
+		}
+		update = next
+		if err := runner.CheckInvariants(update); err != nil {
+			result.Failures = append(result.Failures, fmt.Sprintf("after move %d by position %d, %s", i+1, m.Position, err))
+		}
+		for _, a := range m.Assert {
+			if failure := checkAssertion(update, a); failure != "" {
+				result.Failures = append(result.Failures, fmt.Sprintf("after move %d: %s", i+1, failure))
+			}
+		}
+	}
+	for _, a := range scenario.Assert {
+		if failure := checkAssertion(update, a); failure != "" {
+			result.Failures = append(result.Failures, failure)
+		}
+	}
+	return result, nil
+}
+
+func allMoveAssertions(s *Scenario) []string {
+	assertions := []string{}
+	for _, m := range s.Moves {
+		assertions = append(assertions, m.Assert...)
+	}
+	return assertions
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
+
+// Assertion compares two expressions, written as `<expression> <op>
+// <expression>` such as `players.1.score == 7`. An expression is a JSON
+// value, a gjson path or an aggregate of paths, see parseExpression. The
+// operator needs spaces around it so it is not confused with gjson queries.
+type Assertion struct {
+	Source      string
+	Left, Right *expression
+	Op          string
+}
+
+var assertionPattern = regexp.MustCompile(`^(.+?)\s+(==|!=|<=|>=|<|>)\s+(.+)$`)
+
+// bareWordPattern is a path with no gjson syntax in it, which on the right
+// of an assertion is far more likely to be a string missing its quotes.
+var bareWordPattern = regexp.MustCompile(`^[A-Za-z_][\w-]*$`)
+
+func ParseAssertion(s string) (*Assertion, error) {
+	m := assertionPattern.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return nil, fmt.Errorf("assertion %q is not of the form <expression> <op> <expression>", s)
+	}
+	left, err := parseExpression(m[1])
+	if err != nil {
+		return nil, fmt.Errorf("assertion %q: %w", s, err)
+	}
+	right, err := parseExpression(m[3])
+	if err != nil {
+		return nil, fmt.Errorf("assertion %q: %w", s, err)
+	}
+	if bareWordPattern.MatchString(right.path) {
+		return nil, fmt.Errorf("assertion %q: %s is not a JSON value, quote strings", s, m[3])
+	}
+	a := &Assertion{Source: s, Left: left, Right: right, Op: m[2]}
+	if (a.Op != "==" && a.Op != "!=") && right.literal && right.value.Type != gjson.Number && right.value.Type != gjson.String {
+		return nil, fmt.Errorf("assertion %q: %s only compares numbers and strings", s, a.Op)
+	}
+	return a, nil
+}
+
+// Check returns whether the assertion holds in doc, and what each side
+// came to.
+func (a *Assertion) Check(doc json.RawMessage) (bool, gjson.Result, gjson.Result) {
+	left := a.Left.eval(doc)
+	right := a.Right.eval(doc)
+	return compareResults(left, a.Op, right), left, right
+}
+
+// describe says what the sides that aren't JSON values came to.
+func (a *Assertion) describe(left, right gjson.Result) string {
+	details := []string{}
+	for _, side := range []struct {
+		e *expression
+		v gjson.Result
+	}{{a.Left, left}, {a.Right, right}} {
+		if side.e.literal {
+			continue
+		}
+		if !side.v.Exists() {
+			details = append(details, fmt.Sprintf("%s does not exist", side.e.source))
+		} else {
+			details = append(details, fmt.Sprintf("%s is %s", side.e.source, side.v.Raw))
+		}
+	}
+	return strings.Join(details, ", ")
+}
+
+// compareResults compares actual with expected by op. Values that don't
+// exist are only unequal, and the ordered ops compare numbers or strings.
+func compareResults(actual gjson.Result, op string, expected gjson.Result) bool {
+	if !actual.Exists() || !expected.Exists() {
+		return op == "!="
+	}
+	switch op {
+	case "==", "!=":
+		var x, y interface{}
+		if err := json.Unmarshal([]byte(actual.Raw), &x); err != nil {
+			return false
+		}
+		if err := json.Unmarshal([]byte(expected.Raw), &y); err != nil {
+			return false
+		}
+		return reflect.DeepEqual(x, y) == (op == "==")
+	}
+	if actual.Type != expected.Type || (actual.Type != gjson.Number && actual.Type != gjson.String) {
+		return false
+	}
+	var cmp int
+	if actual.Type == gjson.Number {
+		cmp = compare(actual.Num, expected.Num)
+	} else {
+		cmp = strings.Compare(actual.Str, expected.Str)
+	}
+	switch op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	default:
+		return cmp >= 0
+	}
+}
+
+func compare(x, y float64) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+// checkAssertion describes how the assertion failed, or returns "" if it
+// held.
+func checkAssertion(doc json.RawMessage, s string) string {
+	a, err := ParseAssertion(s)
+	if err != nil {
+		return err.Error()
+	}
+	ok, left, right := a.Check(doc)
+	if ok {
+		return ""
+	}
+	return fmt.Sprintf("%s: %s", s, a.describe(left, right))
+}
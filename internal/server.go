@@ -1,24 +1,29 @@
 package internal
 
 import (
+	"bufio"
+	"compress/gzip"
 	"embed"
 	"encoding/json"
 	"fmt"
 	"html/template"
 	"io"
 	"mime"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/gookit/color"
 )
 
 var liveDev = os.Getenv("LIVE_DEV") == "1"
@@ -68,11 +73,13 @@ type InitialStateHistoryItem struct {
 }
 
 type SaveStateData struct {
-	RandomSeed   string                  `json:"randomSeed"`
-	Settings     json.RawMessage         `json:"settings"`
-	Players      []*Player               `json:"players"`
-	History      []*HistoryItem          `json:"history"`
-	InitialState InitialStateHistoryItem `json:"initialState"`
+	FormatVersion    int                     `json:"formatVersion"`
+	GameStateVersion int                     `json:"gameStateVersion"`
+	RandomSeed       string                  `json:"randomSeed"`
+	Settings         json.RawMessage         `json:"settings"`
+	Players          []*Player               `json:"players"`
+	History          []*HistoryItem          `json:"history"`
+	InitialState     InitialStateHistoryItem `json:"initialState"`
 }
 
 type SetupState struct {
@@ -81,24 +88,117 @@ type SetupState struct {
 	Settings   json.RawMessage `json:"settings"`
 }
 
+type ServerOptions struct {
+	// Host is the interface to bind to, empty for all interfaces.
+	Host string
+	Port int
+	// Certificates serves over HTTPS when set.
+	Certificates *Certificates
+	// Network is the initial simulated network, it can be changed while
+	// running through /_netsim.
+	Network NetworkConditions
+	// CrossOrigin serves the UI and game frames from their own ports with
+	// production's sandbox headers.
+	CrossOrigin bool
+}
+
 type Server struct {
 	gameRoot string
 	manifest *ManifestV1
+	host     string
 	port     int
+	token    string
+	certs    *Certificates
+	netsim   *netSim
 	senders  map[int]chan interface{}
+	journal  *Journal
+	devUsers []*DevUser
 	lock     sync.Mutex
+
+	automation        *automation
+	bots              *bots
+	crossOrigin       bool
+	crossOriginReport *crossOriginReport
+	cspReports        *cspReports
+	profile           *Profile
+	stateSizes        *stateSizes
+	protocolReport    *protocolReport
 }
 
-func NewServer(gameRoot string, manifest *ManifestV1, port int) (*Server, error) {
+func NewServer(gameRoot string, manifest *ManifestV1, options ServerOptions) (*Server, error) {
+	token, err := newAccessToken()
+	if err != nil {
+		return nil, err
+	}
+	devUsers, err := LoadDevUsers(gameRoot, manifest)
+	if err != nil {
+		return nil, err
+	}
+	profile := NewProfile()
+	profile.OnRecord = reportSlowCall
+	profile.Limit = serverProfileLimit
 	return &Server{
 		gameRoot: gameRoot,
 		manifest: manifest,
-		port:     port,
+		host:     options.Host,
+		port:     options.Port,
+		token:    token,
+		certs:    options.Certificates,
+		netsim:   newNetSim(options.Network),
 		senders:  map[int]chan interface{}{},
+		devUsers: devUsers,
 		lock:     sync.Mutex{},
+
+		automation:        &automation{},
+		bots:              newBots(),
+		crossOrigin:       options.CrossOrigin,
+		crossOriginReport: &crossOriginReport{counts: map[crossOriginAccess]int{}},
+		cspReports:        newCSPReports(),
+		profile:           profile,
+		stateSizes:        &stateSizes{},
+		protocolReport:    &protocolReport{counts: map[ProtocolViolation]int{}},
 	}, nil
 }
 
+// URL is the address of the dev shell for the given host, including the
+// access token needed to make changes.
+func (s *Server) URL(host string) string {
+	return s.UserURL(host, "")
+}
+
+// UserURL is like URL but selects the dev user with the given id when the
+// shell loads.
+func (s *Server) UserURL(host, userID string) string {
+	query := url.Values{tokenParam: {s.token}}
+	if userID != "" {
+		query.Set("user", userID)
+	}
+	u := url.URL{
+		Scheme:   s.scheme(),
+		Host:     net.JoinHostPort(host, strconv.Itoa(s.port)),
+		Path:     "/",
+		RawQuery: query.Encode(),
+	}
+	return u.String()
+}
+
+// CAURL is where devices can download the dev CA when serving over HTTPS.
+func (s *Server) CAURL(host string) string {
+	u := url.URL{
+		Scheme: s.scheme(),
+		Host:   net.JoinHostPort(host, strconv.Itoa(s.port)),
+		Path:   "/ca.crt",
+	}
+	return u.String()
+}
+
+func (s *Server) scheme() string {
+	if s.certs != nil {
+		return "https"
+	}
+	return "http"
+}
+
 type reloadEvent struct {
 	Type   string `json:"type"`
 	Target string `json:"target"`
@@ -114,14 +214,16 @@ type pingEvent struct {
 	Type string `json:"type"`
 }
 
+type userOnlineEvent struct {
+	Type   string `json:"type"`
+	ID     string `json:"id"`
+	Online bool   `json:"online"`
+}
+
 func (s *Server) Serve() error {
 	go func() {
 		for {
-			s.lock.Lock()
-			for _, sender := range s.senders {
-				sender <- pingEvent{Type: "ping"}
-			}
-			s.lock.Unlock()
+			s.broadcast(pingEvent{Type: "ping"})
 			time.Sleep(10 * time.Second)
 		}
 	}()
@@ -130,10 +232,21 @@ func (s *Server) Serve() error {
 	if err := os.MkdirAll(saveStatesPath, 0700); err != nil {
 		return err
 	}
+	journal, err := NewJournal(saveStatesPath)
+	if err != nil {
+		return err
+	}
+	s.journal = journal
+	defer s.journal.Close()
+	go s.bots.run(s)
+	defer s.bots.close()
 
 	i := 0
 	r := chi.NewRouter()
 	r.Use(middleware.Logger)
+	r.Use(sameOrigin)
+	r.Use(s.requireToken)
+	assets := s.netsim.Middleware
 
 	r.Get("/events", func(w http.ResponseWriter, r *http.Request) {
 		flusher, ok := w.(http.Flusher)
@@ -145,15 +258,27 @@ func (s *Server) Serve() error {
 
 		s.lock.Lock()
 		currentID := i
+		i++
+		s.lock.Unlock()
+		clientID := r.URL.Query().Get("client")
+		if clientID == "" {
+			clientID = fmt.Sprintf("anonymous-%d", currentID)
+		}
+		kick, release, ok := s.netsim.connect(clientID, r)
+		if !ok {
+			http.Error(w, "simulated disconnect", http.StatusServiceUnavailable)
+			return
+		}
+		defer release()
+
+		s.lock.Lock()
 		c := make(chan interface{}, 10)
-		s.senders[i] = c
+		s.senders[currentID] = c
 		s.lock.Unlock()
-		i++
 
 		w.Header().Set("Content-Type", "text/event-stream")
 		w.Header().Set("Cache-Control", "no-cache")
 		w.Header().Set("Connection", "keep-alive")
-		w.Header().Set("Access-Control-Allow-Origin", "*")
 
 		encoder := json.NewEncoder(w)
 		defer func() {
@@ -162,7 +287,38 @@ func (s *Server) Serve() error {
 			delete(s.senders, currentID)
 		}()
 
-		for i := range c {
+		// events wait out the simulated latency here rather than in c, so
+		// a slow connection never holds up broadcast
+		type delayed struct {
+			event interface{}
+			due   time.Time
+		}
+		queue := []delayed{}
+		for {
+			var due <-chan time.Time
+			if len(queue) != 0 {
+				due = time.After(time.Until(queue[0].due))
+			}
+			select {
+			case e := <-c:
+				d := delayed{event: e, due: time.Now().Add(s.netsim.delay())}
+				if len(queue) != 0 && d.due.Before(queue[len(queue)-1].due) {
+					// jitter never reorders events
+					d.due = queue[len(queue)-1].due
+				}
+				queue = append(queue, d)
+				continue
+			case <-due:
+			case <-kick:
+				return
+			case <-r.Context().Done():
+				return
+			}
+			i := queue[0].event
+			queue = queue[1:]
+			if s.netsim.drop() {
+				continue
+			}
 			if _, err := w.Write([]byte("data: ")); err != nil {
 				fmt.Printf("err: %#v\n", err)
 				return
@@ -179,6 +335,77 @@ func (s *Server) Serve() error {
 		}
 	})
 
+	r.Get("/_netsim", func(w http.ResponseWriter, r *http.Request) {
+		var netsimResponse struct {
+			Conditions NetworkConditions `json:"conditions"`
+			Clients    []*netSimClient   `json:"clients"`
+		}
+		netsimResponse.Conditions = s.netsim.Conditions()
+		netsimResponse.Clients = s.netsim.Clients()
+		w.Header().Add("Content-type", "application/json")
+		w.Header().Add("Cache-control", "no-store")
+		if err := json.NewEncoder(w).Encode(netsimResponse); err != nil {
+			fmt.Printf("error: %#v\n", err)
+		}
+	})
+
+	r.Put("/_netsim", func(w http.ResponseWriter, r *http.Request) {
+		conditions := NetworkConditions{}
+		if err := json.NewDecoder(r.Body).Decode(&conditions); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if conditions.LatencyMs < 0 || conditions.JitterMs < 0 || conditions.DropRate < 0 || conditions.DropRate > 1 {
+			http.Error(w, "latency and jitter must be positive and dropRate between 0 and 1", http.StatusBadRequest)
+			return
+		}
+		s.netsim.SetConditions(conditions)
+		color.Printf("Simulated network is now <bold>%+v</>\n", conditions)
+		w.WriteHeader(204)
+	})
+
+	r.Post("/_netsim/disconnect", func(w http.ResponseWriter, r *http.Request) {
+		var disconnectRequest struct {
+			Client  string  `json:"client"`
+			Seconds float64 `json:"seconds"`
+			// UserID, if set, is reported offline to every other client for
+			// the duration of the disconnect.
+			UserID string `json:"userID"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&disconnectRequest); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if disconnectRequest.Client == "" || disconnectRequest.Seconds <= 0 {
+			http.Error(w, "requires client and a positive number of seconds", http.StatusBadRequest)
+			return
+		}
+		d := time.Duration(disconnectRequest.Seconds * float64(time.Second))
+		if !s.netsim.Disconnect(disconnectRequest.Client, d) {
+			color.Printf("<yellow>Client %s is not connected, it will be refused for %s</>\n", disconnectRequest.Client, d)
+		}
+		if disconnectRequest.UserID != "" {
+			s.broadcast(&userOnlineEvent{Type: "userOnline", ID: disconnectRequest.UserID, Online: false})
+			time.AfterFunc(d, func() {
+				s.broadcast(&userOnlineEvent{Type: "userOnline", ID: disconnectRequest.UserID, Online: true})
+			})
+		}
+		w.WriteHeader(204)
+	})
+
+	r.Route("/api", s.automationRoutes)
+
+	r.Get(cspReportPath, s.serveCSPReports)
+
+	r.Get("/_crossorigin", s.serveCrossOriginReport)
+	r.Post("/_crossorigin", s.recordCrossOriginAccess)
+
+	r.Get("/_timings", s.serveTimings)
+	r.Post("/_timings", s.recordTiming)
+
+	r.Get("/_protocol", s.serveProtocolReport)
+	r.Post("/_protocol", s.recordUIMessage)
+
 	r.Get("/states", func(w http.ResponseWriter, r *http.Request) {
 		entries, err := os.ReadDir(saveStatesPath)
 		if err != nil {
@@ -198,6 +425,9 @@ func (s *Server) Serve() error {
 		entriesResponse.Entries = []*entry{}
 
 		for _, e := range entries {
+			if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+				continue
+			}
 			i, err := e.Info()
 			if err != nil {
 				fmt.Printf("error: %#v\n", err)
@@ -217,51 +447,68 @@ func (s *Server) Serve() error {
 		}
 	})
 
+	// saveStateTarget is the file of the save state named in the request,
+	// refusing names that would reach outside saveStatesPath.
+	saveStateTarget := func(w http.ResponseWriter, r *http.Request) (string, bool) {
+		name, err := url.PathUnescape(chi.URLParam(r, "name"))
+		if err != nil || !ValidSaveStateName(name) {
+			http.Error(w, "invalid save state name", http.StatusBadRequest)
+			return "", false
+		}
+		return path.Join(saveStatesPath, name), true
+	}
+
 	r.Get("/states/{name}", func(w http.ResponseWriter, r *http.Request) {
-		escapedTarget := path.Join(saveStatesPath, chi.URLParam(r, "name"))
-		target, err := url.PathUnescape(escapedTarget)
+		target, ok := saveStateTarget(w, r)
+		if !ok {
+			return
+		}
+		query, err := ParseSaveStateQuery(r.URL.Query())
 		if err != nil {
-			fmt.Printf("error: %#v\n", err)
-			w.WriteHeader(500)
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		f, err := os.Open(filepath.Clean(target))
+		f, err := OpenSaveStateFile(target)
 		if err != nil {
 			fmt.Printf("error: %#v\n", err)
 			w.WriteHeader(500)
 			return
 		}
+		defer f.Close()
 		w.Header().Add("Content-type", "application/json")
 		w.Header().Add("Cache-control", "no-store")
-		w.WriteHeader(200)
-		saveStateData := &SaveStateData{}
-		if err := json.NewDecoder(f).Decode(saveStateData); err != nil {
-			fmt.Printf("error: %#v\n", err)
-			w.WriteHeader(500)
-			return
+		w.Header().Add("Vary", "Accept-Encoding")
+		var out io.Writer = w
+		if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			w.Header().Add("Content-Encoding", "gzip")
+			gz := gzip.NewWriter(w)
+			defer gz.Close()
+			out = gz
 		}
 		w.WriteHeader(200)
-		if err := json.NewEncoder(w).Encode(saveStateData); err != nil {
+		if err := StreamSaveState(bufio.NewReader(f), out, query); err != nil {
+			// headers are already sent, all we can do is cut the response short
 			fmt.Printf("error: %#v\n", err)
 		}
 	})
 
 	r.Post("/states/{name}", func(w http.ResponseWriter, r *http.Request) {
-		escapedTarget := path.Join(saveStatesPath, chi.URLParam(r, "name"))
-		target, err := url.PathUnescape(escapedTarget)
+		target, ok := saveStateTarget(w, r)
+		if !ok {
+			return
+		}
+		body, err := io.ReadAll(r.Body)
 		if err != nil {
 			fmt.Printf("error: %#v\n", err)
 			w.WriteHeader(500)
 			return
 		}
-		f, err := os.OpenFile(filepath.Clean(target), os.O_RDWR|os.O_TRUNC|os.O_CREATE, 0600)
+		body, err = StampSaveState(body, s.manifest.StateVersion)
 		if err != nil {
-			fmt.Printf("error: %#v\n", err)
-			w.WriteHeader(500)
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-
-		if _, err := io.Copy(f, r.Body); err != nil {
+		if err := WriteSaveStateFile(target, body); err != nil {
 			fmt.Printf("error: %#v\n", err)
 			w.WriteHeader(500)
 			return
@@ -271,22 +518,104 @@ func (s *Server) Serve() error {
 	})
 
 	r.Delete("/states/{name}", func(w http.ResponseWriter, r *http.Request) {
-		escapedTarget := path.Join(saveStatesPath, chi.URLParam(r, "name"))
-		target, err := url.PathUnescape(escapedTarget)
+		target, ok := saveStateTarget(w, r)
+		if !ok {
+			return
+		}
+		if err := os.Remove(target); err != nil {
+			fmt.Printf("error: %#v\n", err)
+			w.WriteHeader(500)
+			return
+		}
+		w.WriteHeader(204)
+	})
+
+	r.Get("/autosave", func(w http.ResponseWriter, r *http.Request) {
+		saveStateData, err := s.journal.Latest()
 		if err != nil {
+			if os.IsNotExist(err) {
+				w.WriteHeader(404)
+				return
+			}
 			fmt.Printf("error: %#v\n", err)
 			w.WriteHeader(500)
 			return
 		}
-		if err := os.Remove(target); err != nil {
+		w.Header().Add("Content-type", "application/json")
+		w.Header().Add("Cache-control", "no-store")
+		w.WriteHeader(200)
+		if err := json.NewEncoder(w).Encode(saveStateData); err != nil {
+			fmt.Printf("error: %#v\n", err)
+		}
+	})
+
+	r.Post("/autosave", func(w http.ResponseWriter, r *http.Request) {
+		entry := &JournalEntry{}
+		if err := json.NewDecoder(r.Body).Decode(entry); err != nil {
+			fmt.Printf("error: %#v\n", err)
+			w.WriteHeader(400)
+			return
+		}
+		if entry.Type == "setup" {
+			switch {
+			case entry.SaveState != "":
+				// the shell only holds the latest page of a loaded save, so
+				// the session is adopted from the save itself
+				if !ValidSaveStateName(entry.SaveState) {
+					http.Error(w, "invalid save state name", http.StatusBadRequest)
+					return
+				}
+				saveState, err := ReadSaveStateFile(path.Join(saveStatesPath, entry.SaveState))
+				if err != nil {
+					if os.IsNotExist(err) {
+						http.Error(w, "no save state named "+entry.SaveState, http.StatusNotFound)
+						return
+					}
+					fmt.Printf("error: %#v\n", err)
+					w.WriteHeader(500)
+					return
+				}
+				entry.Adopt(saveState)
+				entry.SaveState = ""
+			case entry.GameStateVersion == nil:
+				// new games are started with the game as built now
+				entry.GameStateVersion = &s.manifest.StateVersion
+			}
+		}
+		// API and bot moves are recorded under the same lock, so only one
+		// move can follow each state
+		s.automation.lock.Lock()
+		defer s.automation.lock.Unlock()
+		if entry.Type == "setup" {
+			// the shell has taken over from a session the API was setting up
+			s.automation.draft = nil
+		}
+		if entry.Type == "move" && entry.Move != nil {
+			saveState, err := s.journal.Current()
+			if err != nil && !os.IsNotExist(err) {
+				fmt.Printf("error: %#v\n", err)
+				w.WriteHeader(500)
+				return
+			}
+			if saveState == nil || entry.Move.Seq != len(saveState.History) {
+				// an API or bot move got there first and the shell will be
+				// sent the session it made
+				http.Error(w, "the session has moved on from this move", http.StatusConflict)
+				return
+			}
+		}
+		if err := s.journal.Record(entry); err != nil {
 			fmt.Printf("error: %#v\n", err)
 			w.WriteHeader(500)
 			return
 		}
+		s.checkInvariants(entry)
+		s.checkStateSize(entry)
+		s.bots.poke()
 		w.WriteHeader(204)
 	})
 
-	r.Get("/ui.js", func(w http.ResponseWriter, r *http.Request) {
+	r.With(assets).Get("/ui.js", func(w http.ResponseWriter, r *http.Request) {
 		f, err := os.ReadFile(path.Join(s.gameRoot, s.manifest.UI.Root, s.manifest.UI.OutputDirectory, "index.js"))
 		if err != nil {
 			fmt.Printf("error: %#v\n", err)
@@ -300,7 +629,7 @@ func (s *Server) Serve() error {
 		}
 	})
 
-	r.Get("/game.js", func(w http.ResponseWriter, r *http.Request) {
+	r.With(assets).Get("/game.js", func(w http.ResponseWriter, r *http.Request) {
 		f, err := os.ReadFile(path.Join(s.gameRoot, s.manifest.Game.Root, s.manifest.Game.OutputFile))
 		if err != nil {
 			fmt.Printf("error: %#v\n", err)
@@ -314,7 +643,7 @@ func (s *Server) Serve() error {
 		}
 	})
 
-	r.Get("/ui.css", func(w http.ResponseWriter, r *http.Request) {
+	r.With(assets).Get("/ui.css", func(w http.ResponseWriter, r *http.Request) {
 		f, err := os.ReadFile(path.Join(s.gameRoot, s.manifest.UI.Root, s.manifest.UI.OutputDirectory, "index.css"))
 		if err != nil {
 			fmt.Printf("error: %#v\n", err)
@@ -328,7 +657,7 @@ func (s *Server) Serve() error {
 		}
 	})
 
-	r.Get("/font.css", func(w http.ResponseWriter, r *http.Request) {
+	r.With(assets).Get("/font.css", func(w http.ResponseWriter, r *http.Request) {
 		f, err := s.getBuildFile("font.css")
 		if err != nil {
 			fmt.Printf("error: %#v\n", err)
@@ -342,7 +671,7 @@ func (s *Server) Serve() error {
 		}
 	})
 
-	r.Get("/files/dm-sans-latin-ext-wght-normal.woff2", func(w http.ResponseWriter, r *http.Request) {
+	r.With(assets).Get("/files/dm-sans-latin-ext-wght-normal.woff2", func(w http.ResponseWriter, r *http.Request) {
 		f, err := s.getBuildFile("dm-sans-latin-ext-wght-normal.woff2")
 		if err != nil {
 			fmt.Printf("error: %#v\n", err)
@@ -356,7 +685,7 @@ func (s *Server) Serve() error {
 		}
 	})
 
-	r.Get("/files/dm-sans-latin-wght-normal.woff2", func(w http.ResponseWriter, r *http.Request) {
+	r.With(assets).Get("/files/dm-sans-latin-wght-normal.woff2", func(w http.ResponseWriter, r *http.Request) {
 		f, err := s.getBuildFile("dm-sans-latin-wght-normal.woff2")
 		if err != nil {
 			fmt.Printf("error: %#v\n", err)
@@ -387,6 +716,11 @@ func (s *Server) Serve() error {
 			MinimumPlayers int
 			MaximumPlayers int
 			DefaultPlayers int
+			UIOrigin       string
+			GameOrigin     string
+			DevUsers       string
+			DeepLink       string
+			DeepLinkError  string
 		}
 		data.MinimumPlayers = s.manifest.MinimumPlayers
 		data.MaximumPlayers = s.manifest.MaximumPlayers
@@ -395,6 +729,28 @@ func (s *Server) Serve() error {
 		} else {
 			data.DefaultPlayers = s.manifest.DefaultPlayers
 		}
+		data.UIOrigin = s.frameOrigin(r, uiFrame)
+		data.GameOrigin = s.frameOrigin(r, gameFrame)
+		data.DevUsers, err = s.devUsersJSON()
+		if err != nil {
+			fmt.Printf("error: %#v\n", err)
+			w.WriteHeader(500)
+			return
+		}
+		deepLink, err := ParseDeepLink(r.URL.Query(), s.manifest, s.devUsers, saveStatesPath)
+		if err != nil {
+			// the shell shows the error and falls back to setting up by hand
+			data.DeepLinkError = err.Error()
+		} else if deepLink != nil {
+			b, err := json.Marshal(deepLink)
+			if err != nil {
+				fmt.Printf("error: %#v\n", err)
+				w.WriteHeader(500)
+				return
+			}
+			data.DeepLink = string(b)
+		}
+		s.rememberToken(w, r)
 		w.Header().Add("Content-type", "text/html")
 		w.Header().Add("Cache-control", "no-store")
 		if err := t.Execute(w, data); err != nil {
@@ -402,7 +758,7 @@ func (s *Server) Serve() error {
 		}
 	})
 
-	r.Get("/ui.html", func(w http.ResponseWriter, r *http.Request) {
+	r.With(assets).Get("/ui.html", func(w http.ResponseWriter, r *http.Request) {
 		f, err := s.getFile("ui.html")
 		if err != nil {
 			fmt.Printf("error: %#v\n", err)
@@ -416,18 +772,21 @@ func (s *Server) Serve() error {
 			return
 		}
 		var data struct {
-			Bootstrap string
+			Bootstrap   string
+			CrossOrigin bool
 		}
 		data.Bootstrap = r.URL.Query().Get("bootstrap")
+		data.CrossOrigin = requestFrame(r) == uiFrame
 		w.Header().Add("Content-type", "text/html")
 		w.Header().Add("Cache-control", "no-store")
-		w.Header().Add("Content-Security-Policy", "default-src 'self'; connect-src 'none'; media-src 'self' data:; script-src 'self' 'unsafe-inline'; style-src 'self' 'unsafe-inline';")
+		w.Header().Add("Content-Security-Policy", s.uiContentSecurityPolicy(r))
+		w.Header().Add("Reporting-Endpoints", fmt.Sprintf("csp=%q", s.cspReportURL(r)))
 		if err := t.Execute(w, data); err != nil {
 			fmt.Printf("error: %#v\n", err)
 		}
 	})
 
-	r.Get("/game.html", func(w http.ResponseWriter, r *http.Request) {
+	r.With(assets).Get("/game.html", func(w http.ResponseWriter, r *http.Request) {
 		f, err := s.getFile("game.html")
 		if err != nil {
 			fmt.Printf("error: %#v\n", err)
@@ -442,21 +801,21 @@ func (s *Server) Serve() error {
 		}
 	})
 
-	r.Get("/_profile/*", func(w http.ResponseWriter, r *http.Request) {
-		assetPath := chi.URLParam(r, "*")
-		f, err := s.getBuildFile(assetPath)
-		if err != nil {
-			fmt.Printf("error: %#v\n", err)
-			w.WriteHeader(500)
+	r.Get("/ca.crt", func(w http.ResponseWriter, r *http.Request) {
+		if s.certs == nil {
+			w.WriteHeader(404)
 			return
 		}
-		w.Header().Add("Content-type", "image/jpg")
-		if _, err := w.Write(f); err != nil {
+		w.Header().Add("Content-type", "application/x-x509-ca-cert")
+		w.Header().Add("Content-Disposition", `attachment; filename="boardzilla-dev-ca.crt"`)
+		if _, err := w.Write(s.certs.CACertPEM); err != nil {
 			fmt.Printf("error: %#v\n", err)
 		}
 	})
 
-	r.Get("/*", func(w http.ResponseWriter, r *http.Request) {
+	r.With(assets).Get("/_profile/*", s.serveAvatar)
+
+	r.With(assets).Get("/*", func(w http.ResponseWriter, r *http.Request) {
 		assetPath := filepath.FromSlash(filepath.Clean(chi.URLParam(r, "*")))
 		ext := filepath.Ext(assetPath)
 		f, err := s.getBuildFile(assetPath)
@@ -476,10 +835,17 @@ func (s *Server) Serve() error {
 		}
 	})
 
-	srv := &http.Server{
-		Handler:           r,
-		ReadHeaderTimeout: 200 * time.Millisecond,
-		Addr:              fmt.Sprintf(":%d", s.port),
+	listen := func(port int, handler http.Handler) error {
+		srv := &http.Server{
+			Handler:           handler,
+			ReadHeaderTimeout: 200 * time.Millisecond,
+			Addr:              net.JoinHostPort(s.host, strconv.Itoa(port)),
+		}
+		if s.certs != nil {
+			srv.TLSConfig = s.certs.TLSConfig()
+			return srv.ListenAndServeTLS("", "")
+		}
+		return srv.ListenAndServe()
 	}
 	if liveDev {
 		go func() {
@@ -492,38 +858,64 @@ func (s *Server) Serve() error {
 			}
 		}()
 	}
-	return srv.ListenAndServe()
+	shell := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == cspReportPath && (req.Method == http.MethodPost || req.Method == http.MethodOptions) {
+			s.recordCSPReport(w, req)
+			return
+		}
+		r.ServeHTTP(w, req)
+	})
+	errs := make(chan error, 3)
+	go func() { errs <- listen(s.port, shell) }()
+	if s.crossOrigin {
+		for _, f := range []frame{uiFrame, gameFrame} {
+			go func(f frame) {
+				if err := listen(s.framePort(f), s.frameHandler(f, r)); err != nil {
+					errs <- fmt.Errorf("%s frame: %w", f, err)
+				}
+			}(f)
+		}
+	}
+	return <-errs
 }
 
 func (s *Server) Reload(t BuildType) {
+	if t == Game {
+		s.automation.resetRunner()
+	}
+	var reloadTarget string
+	switch t {
+	case Game:
+		reloadTarget = "game"
+	case UI:
+		reloadTarget = "ui"
+	}
+	s.broadcast(&reloadEvent{
+		Type:   "reload",
+		Target: reloadTarget,
+	})
+}
+
+// broadcast sends e to every connected client. A client too far behind to
+// take it misses the event rather than holding up the sender.
+func (s *Server) broadcast(e interface{}) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 	for _, sender := range s.senders {
-		var reloadTarget string
-		switch t {
-		case Game:
-			reloadTarget = "game"
-		case UI:
-			reloadTarget = "ui"
-		}
-		sender <- &reloadEvent{
-			Type:   "reload",
-			Target: reloadTarget,
+		select {
+		case sender <- e:
+		default:
 		}
 	}
 }
 
 func (s *Server) BuildError(o, e string) {
 	fmt.Printf("sending build error!")
-	s.lock.Lock()
-	defer s.lock.Unlock()
-	for _, sender := range s.senders {
-		sender <- &buildErrorEvent{
-			Type: "buildError",
-			Out:  o,
-			Err:  e,
-		}
-	}
+	s.broadcast(&buildErrorEvent{
+		Type: "buildError",
+		Out:  o,
+		Err:  e,
+	})
 }
 
 func (s *Server) getBuildFile(n string) ([]byte, error) {
@@ -0,0 +1,223 @@
+package internal
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+)
+
+// SimulatedGame is the outcome of one playout in a simulation.
+type SimulatedGame struct {
+	Seed     string `json:"seed"`
+	Players  int    `json:"players"`
+	Moves    int    `json:"moves"`
+	Finished bool   `json:"finished"`
+	Winners  []int  `json:"winners"`
+	// Scores are each position's PlayerState.score at the end, if the game
+	// gives them.
+	Scores map[int]float64 `json:"scores,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// Simulate plays one game with picker and records how it ended, along
+// with the playout.
+func Simulate(runner *Runner, setup *SetupState, picker MovePicker, rng *rand.Rand, maxMoves int) (*SimulatedGame, *Playout) {
+	game := &SimulatedGame{Seed: setup.RandomSeed, Players: len(setup.Players), Winners: []int{}}
+	playout, err := Play(runner, setup, picker, rng, maxMoves)
+	game.Moves = len(playout.Moves)
+	game.Finished = playout.Finished
+	if err != nil {
+		game.Error = firstLine(err.Error())
+		return game, playout
+	}
+	var last struct {
+		Game    gameStatus `json:"game"`
+		Players []struct {
+			Position int      `json:"position"`
+			Score    *float64 `json:"score"`
+		} `json:"players"`
+	}
+	if err := json.Unmarshal(playout.Updates[len(playout.Updates)-1], &last); err != nil {
+		game.Error = err.Error()
+		return game, playout
+	}
+	if game.Finished {
+		game.Winners = append(game.Winners, last.Game.Winners...)
+	}
+	for _, p := range last.Players {
+		if p.Score != nil {
+			if game.Scores == nil {
+				game.Scores = map[int]float64{}
+			}
+			game.Scores[p.Position] = *p.Score
+		}
+	}
+	return game, playout
+}
+
+type Percentiles struct {
+	P10 float64 `json:"p10"`
+	P50 float64 `json:"p50"`
+	P90 float64 `json:"p90"`
+	P99 float64 `json:"p99"`
+	Max float64 `json:"max"`
+}
+
+// NewPercentiles summarizes values, which it sorts.
+func NewPercentiles(values []float64) *Percentiles {
+	if len(values) == 0 {
+		return nil
+	}
+	sort.Float64s(values)
+	at := func(p float64) float64 {
+		return values[int(math.Ceil(p*float64(len(values))))-1]
+	}
+	return &Percentiles{P10: at(0.1), P50: at(0.5), P90: at(0.9), P99: at(0.99), Max: values[len(values)-1]}
+}
+
+type SeatSummary struct {
+	Position int     `json:"position"`
+	Wins     int     `json:"wins"`
+	WinRate  float64 `json:"winRate"`
+	// WinRateMargin is the 95% confidence interval either side of WinRate.
+	WinRateMargin float64      `json:"winRateMargin"`
+	MeanScore     *float64     `json:"meanScore,omitempty"`
+	Scores        *Percentiles `json:"scores,omitempty"`
+}
+
+// PlayerCountSummary is how seats fared in the finished games with a
+// number of players.
+type PlayerCountSummary struct {
+	Players  int            `json:"players"`
+	Finished int            `json:"finished"`
+	Seats    []*SeatSummary `json:"seats"`
+}
+
+type ErrorCount struct {
+	Error string `json:"error"`
+	Count int    `json:"count"`
+}
+
+type SimulationSummary struct {
+	Games      int                   `json:"games"`
+	Finished   int                   `json:"finished"`
+	Unfinished int                   `json:"unfinished"`
+	Errors     int                   `json:"errors"`
+	MoveCounts *Percentiles          `json:"moveCounts,omitempty"`
+	ByPlayers  []*PlayerCountSummary `json:"byPlayers"`
+	// ErrorCounts groups errors by message, most frequent first.
+	ErrorCounts []*ErrorCount `json:"errorCounts"`
+}
+
+func Summarize(games []*SimulatedGame) *SimulationSummary {
+	summary := &SimulationSummary{Games: len(games), ByPlayers: []*PlayerCountSummary{}, ErrorCounts: []*ErrorCount{}}
+	moveCounts := []float64{}
+	byPlayers := map[int][]*SimulatedGame{}
+	errorCounts := map[string]int{}
+	for _, g := range games {
+		switch {
+		case g.Error != "":
+			summary.Errors++
+			errorCounts[g.Error]++
+			continue
+		case !g.Finished:
+			summary.Unfinished++
+			continue
+		}
+		summary.Finished++
+		moveCounts = append(moveCounts, float64(g.Moves))
+		byPlayers[g.Players] = append(byPlayers[g.Players], g)
+	}
+	summary.MoveCounts = NewPercentiles(moveCounts)
+
+	counts := []int{}
+	for n := range byPlayers {
+		counts = append(counts, n)
+	}
+	sort.Ints(counts)
+	for _, n := range counts {
+		finished := byPlayers[n]
+		pc := &PlayerCountSummary{Players: n, Finished: len(finished)}
+		for position := 1; position <= n; position++ {
+			seat := &SeatSummary{Position: position}
+			scores := []float64{}
+			for _, g := range finished {
+				for _, w := range g.Winners {
+					if w == position {
+						seat.Wins++
+					}
+				}
+				if score, ok := g.Scores[position]; ok {
+					scores = append(scores, score)
+				}
+			}
+			p := float64(seat.Wins) / float64(len(finished))
+			seat.WinRate = p
+			seat.WinRateMargin = 1.96 * math.Sqrt(p*(1-p)/float64(len(finished)))
+			if len(scores) != 0 {
+				total := 0.0
+				for _, s := range scores {
+					total += s
+				}
+				mean := total / float64(len(scores))
+				seat.MeanScore = &mean
+				seat.Scores = NewPercentiles(scores)
+			}
+			pc.Seats = append(pc.Seats, seat)
+		}
+		summary.ByPlayers = append(summary.ByPlayers, pc)
+	}
+
+	for e, c := range errorCounts {
+		summary.ErrorCounts = append(summary.ErrorCounts, &ErrorCount{Error: e, Count: c})
+	}
+	sort.Slice(summary.ErrorCounts, func(i, j int) bool {
+		if summary.ErrorCounts[i].Count != summary.ErrorCounts[j].Count {
+			return summary.ErrorCounts[i].Count > summary.ErrorCounts[j].Count
+		}
+		return summary.ErrorCounts[i].Error < summary.ErrorCounts[j].Error
+	})
+	return summary
+}
+
+// WriteSimulationCSV writes a row per game, with a winner and score column
+// for each of up to maxPlayers seats.
+func WriteSimulationCSV(w io.Writer, games []*SimulatedGame, maxPlayers int) error {
+	out := csv.NewWriter(w)
+	header := []string{"seed", "players", "moves", "finished"}
+	for position := 1; position <= maxPlayers; position++ {
+		header = append(header, fmt.Sprintf("won_%d", position), fmt.Sprintf("score_%d", position))
+	}
+	if err := out.Write(append(header, "error")); err != nil {
+		return err
+	}
+	for _, g := range games {
+		row := []string{g.Seed, strconv.Itoa(g.Players), strconv.Itoa(g.Moves), strconv.FormatBool(g.Finished)}
+		for position := 1; position <= maxPlayers; position++ {
+			won := ""
+			if position <= g.Players && g.Finished {
+				won = "0"
+				for _, w := range g.Winners {
+					if w == position {
+						won = "1"
+					}
+				}
+			}
+			score := ""
+			if s, ok := g.Scores[position]; ok {
+				score = strconv.FormatFloat(s, 'f', -1, 64)
+			}
+			row = append(row, won, score)
+		}
+		if err := out.Write(append(row, g.Error)); err != nil {
+			return err
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
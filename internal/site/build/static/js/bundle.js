@@ -26,1066 +26,1034 @@ __webpack_require__.r(__webpack_exports__);
 /* provided dependency */ var __react_refresh_utils__ = __webpack_require__(/*! ./node_modules/@pmmmwh/react-refresh-webpack-plugin/lib/runtime/RefreshUtils.js */ "./node_modules/@pmmmwh/react-refresh-webpack-plugin/lib/runtime/RefreshUtils.js");
 __webpack_require__.$Refresh$.runtime = __webpack_require__(/*! ./node_modules/react-refresh/runtime.js */ "./node_modules/react-refresh/runtime.js");
 
-var _jsxFileName = "/src/App.tsx",
-  _s = __webpack_require__.$Refresh$.signature();
-
-
-
-
-
-
-
-
-
-
-
+var _jsxFileName = "/src/App.tsx";
+
+const ReconnectingEventSource = reconnecting_eventsource__WEBPACK_IMPORTED_MODULE_0__["default"];
+const React = react__WEBPACK_IMPORTED_MODULE_1___default();
+const { useCallback, useEffect, useState, useMemo, useRef } = react__WEBPACK_IMPORTED_MODULE_1__;
+const History = _History__WEBPACK_IMPORTED_MODULE_2__["default"];
+const { Modal } = react_responsive_modal__WEBPACK_IMPORTED_MODULE_3__;
+const toast = react_hot_toast__WEBPACK_IMPORTED_MODULE_4__["default"];
+const { Toaster } = react_hot_toast__WEBPACK_IMPORTED_MODULE_4__;
+const Switch = react_switch__WEBPACK_IMPORTED_MODULE_5__["default"];
+const { sendInitialState, processMove, resolveGamePromise, rejectGamePromise, reprocessHistory, frameTargetOrigin } = _game__WEBPACK_IMPORTED_MODULE_8__;
 const body = document.getElementsByTagName("body")[0];
 const maxPlayers = parseInt(body.getAttribute("maxPlayers"));
 const minPlayers = parseInt(body.getAttribute("minPlayers"));
 const defaultPlayers = parseInt(body.getAttribute("defaultPlayers"));
-const possibleUsers = [{
-  id: "0",
-  name: "Evelyn"
-}, {
-  id: "1",
-  name: "Jennifer"
-}, {
-  id: "2",
-  name: "Kateryna"
-}, {
-  id: "3",
-  name: "Logan"
-}, {
-  id: "4",
-  name: "Liubika"
-}, {
-  id: "5",
-  name: "Aischa"
-}, {
-  id: "6",
-  name: "Leilani"
-}, {
-  id: "7",
-  name: "Avery"
-}, {
-  id: "8",
-  name: "Guadalupe"
-}, {
-  id: "9",
-  name: "Zvezdelina"
-}];
-const colors = ["#d50000", "#00695c", "#304ffe", "#ff6f00", "#7c4dff", "#ffa825", "#f2d330", "#43a047", "#004d40", "#795a4f", "#00838f", "#408074", "#448aff", "#1a237e", "#ff4081", "#bf360c", "#4a148c", "#aa00ff", "#455a64", "#600020"];
-const avatarURL = userID => `/_profile/${userID}.jpg`;
-function App() {
-  _s();
-  const [initialState, setInitialState] = (0,react__WEBPACK_IMPORTED_MODULE_1__.useState)();
-  const [numberOfUsers, setNumberOfUsers] = (0,react__WEBPACK_IMPORTED_MODULE_1__.useState)(0);
-  const [phase, setPhase] = (0,react__WEBPACK_IMPORTED_MODULE_1__.useState)("new");
-  const [currentUserID, setCurrentUserID] = (0,react__WEBPACK_IMPORTED_MODULE_1__.useState)(possibleUsers[0].id);
-  const [currentUserIDRequested, setCurrentUserIDRequested] = (0,react__WEBPACK_IMPORTED_MODULE_1__.useState)(undefined);
-  const [players, setPlayers] = (0,react__WEBPACK_IMPORTED_MODULE_1__.useState)([]);
-  const [playerReadiness, setPlayerReadiness] = (0,react__WEBPACK_IMPORTED_MODULE_1__.useState)(new Map());
-  const [buildError, setBuildError] = (0,react__WEBPACK_IMPORTED_MODULE_1__.useState)();
-  const [settings, setSettings] = (0,react__WEBPACK_IMPORTED_MODULE_1__.useState)({});
-  const [seatCount, setSeatCount] = (0,react__WEBPACK_IMPORTED_MODULE_1__.useState)(0);
-  const [history, setHistory] = (0,react__WEBPACK_IMPORTED_MODULE_1__.useState)([]);
-  const [historyPin, setHistoryPin] = (0,react__WEBPACK_IMPORTED_MODULE_1__.useState)(undefined);
-  const [helpOpen, setHelpOpen] = (0,react__WEBPACK_IMPORTED_MODULE_1__.useState)(false);
-  const [saveStatesOpen, setSaveStatesOpen] = (0,react__WEBPACK_IMPORTED_MODULE_1__.useState)(false);
-  const [saveStates, setSaveStates] = (0,react__WEBPACK_IMPORTED_MODULE_1__.useState)([]);
-  const [historyCollapsed, setHistoryCollapsed] = (0,react__WEBPACK_IMPORTED_MODULE_1__.useState)(false);
-  const [fullScreen, setFullScreen] = (0,react__WEBPACK_IMPORTED_MODULE_1__.useState)(false);
-  const [autoSwitch, setAutoSwitch] = (0,react__WEBPACK_IMPORTED_MODULE_1__.useState)(true);
-  const [reprocessing, setReprocessing] = (0,react__WEBPACK_IMPORTED_MODULE_1__.useState)(false);
-  const [darkMode, setDarkMode] = (0,react__WEBPACK_IMPORTED_MODULE_1__.useState)(localStorage.getItem("dark") === "true");
-  const host = currentUserID === possibleUsers[0].id;
-  const setRandomSeed = (0,react__WEBPACK_IMPORTED_MODULE_1__.useCallback)(seed => {
-    sessionStorage.setItem("rseed", seed);
-  }, []);
-  const getRandomSeed = (0,react__WEBPACK_IMPORTED_MODULE_1__.useCallback)(() => {
-    let randomSeed = sessionStorage.getItem("rseed");
-    if (!randomSeed) {
-      randomSeed = String(Math.random());
-      setRandomSeed(randomSeed);
-    }
-    return randomSeed;
-  }, [setRandomSeed]);
-  (0,react__WEBPACK_IMPORTED_MODULE_1__.useEffect)(() => {
-    localStorage.setItem("dark", darkMode ? "true" : "false");
-    if (darkMode) {
-      document.documentElement.classList.add("dark");
-    } else {
-      document.documentElement.classList.remove("dark");
-    }
-  }, [darkMode]);
-  const currentPlayer = (0,react__WEBPACK_IMPORTED_MODULE_1__.useMemo)(() => players.find(p => p.id === currentUserID), [players, currentUserID]);
-  const loadSaveStates = (0,react__WEBPACK_IMPORTED_MODULE_1__.useCallback)(async () => {
-    const response = await fetch("/states");
-    const states = await response.json();
-    setSaveStates(states.entries);
-  }, []);
-  const getCurrentState = (0,react__WEBPACK_IMPORTED_MODULE_1__.useCallback)(history => {
-    var _history$length;
-    const historyItem = historyPin !== null && historyPin !== void 0 ? historyPin : ((_history$length = history === null || history === void 0 ? void 0 : history.length) !== null && _history$length !== void 0 ? _history$length : 0) - 1;
-    return history && historyItem >= 0 ? history[historyItem].state : initialState.state;
-  }, [initialState, historyPin]);
-  const sendToUI = (0,react__WEBPACK_IMPORTED_MODULE_1__.useCallback)(data => {
-    var _document$getElementB;
-    (_document$getElementB = document.getElementById("ui")) === null || _document$getElementB === void 0 ? void 0 : _document$getElementB.contentWindow.postMessage(JSON.parse(JSON.stringify(data)));
-  }, []);
-  const userWithPlayerDetails = (0,react__WEBPACK_IMPORTED_MODULE_1__.useCallback)((user, player) => {
-    var _player$name, _playerReadiness$get;
-    return {
-      id: user.id,
-      name: (_player$name = player === null || player === void 0 ? void 0 : player.name) !== null && _player$name !== void 0 ? _player$name : user.name,
-      avatar: avatarURL(user.id),
-      playerDetails: player ? {
-        color: player.color,
-        position: player.position,
-        settings: player.settings,
-        ready: (_playerReadiness$get = playerReadiness.get(user.id)) !== null && _playerReadiness$get !== void 0 ? _playerReadiness$get : true,
-        sessionURL: host ? document.location.href : undefined
-      } : undefined
-    };
-  }, [host, playerReadiness]);
-  (0,react__WEBPACK_IMPORTED_MODULE_1__.useEffect)(() => {
-    loadSaveStates();
-  }, [loadSaveStates]);
-  (0,react__WEBPACK_IMPORTED_MODULE_1__.useEffect)(() => {
-    if (phase === "new") {
-      sendToUI({
-        type: "settingsUpdate",
-        settings,
-        seatCount
-      });
+const uiOrigin = body.getAttribute("uiOrigin") ?? "";
+const gameOrigin = body.getAttribute("gameOrigin") ?? "";
+const clientID = crypto.randomUUID();
+const possibleUsers = JSON.parse(body.getAttribute("devUsers"));
+const isBot = (id)=>!!possibleUsers.find((u)=>u.id === id)?.bot;
+const firstPersonPlaying = (players, currentPlayers)=>{
+    for (const position of currentPlayers){
+        const player = players.find((p)=>p.position === position);
+        if (player && !isBot(player.id)) return player;
     }
-  }, [phase, sendToUI, settings, seatCount]);
-  const setNumberAndSeat = (0,react__WEBPACK_IMPORTED_MODULE_1__.useCallback)(n => {
-    setSeatCount(n);
-    setNumberOfUsers(Math.max(n, numberOfUsers));
-    if (n > players.length) {
-      setPlayers(players.concat(possibleUsers.slice(players.length, n).map((u, i) => ({
-        id: u.id,
-        name: u.name,
-        avatar: avatarURL(u.id),
-        color: colors[players.length + i],
-        position: players.length + i + 1,
-        host: players.length + i === 0
-      }))));
-      setPlayerReadiness(new Map([...playerReadiness, ...possibleUsers.slice(numberOfUsers).map(p => [p.id, p.id !== "0"])]));
-      sendToUI({
-        type: "settingsUpdate",
-        settings,
-        seatCount: n
-      });
-    }
-  }, [numberOfUsers, playerReadiness, settings, sendToUI, players]);
-  (0,react__WEBPACK_IMPORTED_MODULE_1__.useEffect)(() => {
-    if (numberOfUsers === 0) setNumberAndSeat(minPlayers);
-  }, [numberOfUsers, setNumberAndSeat]);
-  const saveCurrentState = (0,react__WEBPACK_IMPORTED_MODULE_1__.useCallback)(async (name, randomSeed, initialState, history, settings, players) => {
-    return fetch(`/states/${encodeURIComponent(name)}`, {
-      headers: {
-        "Content-type": "application/json"
-      },
-      body: JSON.stringify({
-        randomSeed,
-        initialState,
-        history,
-        players,
-        settings
-      }),
-      method: "POST"
-    }).then(() => loadSaveStates());
-  }, [loadSaveStates]);
-  const saveCurrentStateCallback = (0,react__WEBPACK_IMPORTED_MODULE_1__.useCallback)(e => {
-    e.preventDefault();
-    const target = e.target;
-    saveCurrentState(getRandomSeed(), target.name.value, initialState, history, settings, players);
-  }, [saveCurrentState, getRandomSeed, initialState, history, settings, players]);
-  const bootstrap = (0,react__WEBPACK_IMPORTED_MODULE_1__.useCallback)(() => {
-    return JSON.stringify({
-      host: currentUserID === possibleUsers[0].id,
-      userID: currentUserID,
-      minPlayers,
-      maxPlayers,
-      defaultPlayers,
-      dev: true
-    });
-  }, [currentUserID]);
-  const updateUI = (0,react__WEBPACK_IMPORTED_MODULE_1__.useCallback)(async update => {
-    var _update$players$find;
-    if (reprocessing) return;
-    const playerState = (_update$players$find = update.players.find(p => p.position === currentPlayer.position)) === null || _update$players$find === void 0 ? void 0 : _update$players$find.state;
-    switch (update.game.phase) {
-      case "finished":
-        sendToUI({
-          type: "gameFinished",
-          position: currentPlayer.position,
-          state: playerState,
-          winners: update.game.winners
-        });
-        break;
-      case "started":
-        let position = currentPlayer.position;
-        if (autoSwitch && update.game.currentPlayers[0] !== currentPlayer.position && currentUserIDRequested === undefined) {
-          position = update.game.currentPlayers[0];
-          setCurrentUserID(players.find(p => p.position === position).id);
-          return;
+};
+const requestedUserID = new URLSearchParams(document.location.search).get("user");
+const initialUserID = possibleUsers.find((u)=>u.id === requestedUserID) ? requestedUserID : possibleUsers[0].id;
+const colors = [
+    "#d50000",
+    "#00695c",
+    "#304ffe",
+    "#ff6f00",
+    "#7c4dff",
+    "#ffa825",
+    "#f2d330",
+    "#43a047",
+    "#004d40",
+    "#795a4f",
+    "#00838f",
+    "#408074",
+    "#448aff",
+    "#1a237e",
+    "#ff4081",
+    "#bf360c",
+    "#4a148c",
+    "#aa00ff",
+    "#455a64",
+    "#600020"
+];
+const historyPageSize = 100;
+const deepLink = body.getAttribute("deepLink") ? JSON.parse(body.getAttribute("deepLink")) : undefined;
+const deepLinkError = body.getAttribute("deepLinkError");
+const deepLinkStarts = deepLink !== undefined && (deepLink.players !== undefined || deepLink.randomSeed !== undefined || deepLink.settings !== undefined);
+if (deepLink?.randomSeed) sessionStorage.setItem("rseed", deepLink.randomSeed);
+const avatarURL = (userID)=>possibleUsers.find((u)=>u.id === userID)?.avatar ?? `/_profile/${encodeURIComponent(userID)}`;
+const reloadFrame = (id)=>{
+    const frame = document.getElementById(id);
+    if (frame) frame.src = frame.src;
+};
+function App() {
+    const [initialState, setInitialState] = useState();
+    const [numberOfUsers, setNumberOfUsers] = useState(0);
+    const [phase, setPhase] = useState("new");
+    const [currentUserID, setCurrentUserID] = useState(initialUserID);
+    const [currentUserIDRequested, setCurrentUserIDRequested] = useState(undefined);
+    const [players, setPlayers] = useState([]);
+    const [playerReadiness, setPlayerReadiness] = useState(new Map());
+    const [buildError, setBuildError] = useState();
+    const [settings, setSettings] = useState(deepLink?.settings ?? {});
+    const [deepLinkPending, setDeepLinkPending] = useState(deepLinkStarts);
+    const [seatCount, setSeatCount] = useState(0);
+    const [history, setHistory] = useState([]);
+    const [historySource, setHistorySource] = useState(undefined);
+    const loadingHistory = useRef(undefined);
+    const [gameStateVersion, setGameStateVersion] = useState(undefined);
+    const [historyPin, setHistoryPin] = useState(undefined);
+    const [helpOpen, setHelpOpen] = useState(false);
+    const [cspViolations, setCSPViolations] = useState([]);
+    const [cspViolationsOpen, setCSPViolationsOpen] = useState(false);
+    const [saveStatesOpen, setSaveStatesOpen] = useState(false);
+    const [saveStates, setSaveStates] = useState([]);
+    const [historyCollapsed, setHistoryCollapsed] = useState(false);
+    const [fullScreen, setFullScreen] = useState(false);
+    const [autoSwitch, setAutoSwitch] = useState(requestedUserID === null);
+    const [reprocessing, setReprocessing] = useState(false);
+    const [darkMode, setDarkMode] = useState(localStorage.getItem("dark") === "true");
+    const host = currentUserID === possibleUsers[0].id;
+    const setRandomSeed = useCallback((seed)=>{
+        sessionStorage.setItem("rseed", seed);
+    }, []);
+    const getRandomSeed = useCallback(()=>{
+        let randomSeed = sessionStorage.getItem("rseed");
+        if (!randomSeed) {
+            randomSeed = String(Math.random());
+            setRandomSeed(randomSeed);
+        }
+        return randomSeed;
+    }, [
+        setRandomSeed
+    ]);
+    useEffect(()=>{
+        localStorage.setItem("dark", darkMode ? "true" : "false");
+        if (darkMode) {
+            document.documentElement.classList.add("dark");
+        } else {
+            document.documentElement.classList.remove("dark");
         }
-        sendToUI({
-          type: "gameUpdate",
-          position: currentPlayer.position,
-          state: playerState,
-          currentPlayers: update.game.currentPlayers,
-          readOnly: historyPin !== undefined
-        });
-        break;
-    }
-  }, [sendToUI, reprocessing, autoSwitch, players, currentPlayer, currentUserIDRequested, historyPin]);
-  const start = (0,react__WEBPACK_IMPORTED_MODULE_1__.useCallback)(async () => {
-    const randomSeed = getRandomSeed();
-    const initialUpdate = await (0,_game__WEBPACK_IMPORTED_MODULE_8__.sendInitialState)({
-      randomSeed,
-      players,
-      settings
-    });
-    const newInitialState = {
-      state: initialUpdate,
-      players,
-      settings
-    };
-    setInitialState(newInitialState);
-    setPhase("started");
-    await updateUI(initialUpdate);
-  }, [getRandomSeed, players, settings, updateUI]);
-  (0,react__WEBPACK_IMPORTED_MODULE_1__.useEffect)(() => {
-    if (phase === "new" && players.length >= minPlayers && players.length === seatCount && players.every(p => playerReadiness.get(p.id))) start();
-  }, [players, playerReadiness, seatCount, start, phase]);
-  const resetGame = (0,react__WEBPACK_IMPORTED_MODULE_1__.useCallback)(() => {
-    var _document$getElementB2, _document$getElementB3, _document$getElementB4, _document$getElementB5;
-    setPhase("new");
-    setSettings({});
-    setInitialState(undefined);
-    setHistory([]);
-    setPlayers([]);
-    setCurrentUserID(possibleUsers[0].id);
-    (_document$getElementB2 = document.getElementById("ui")) === null || _document$getElementB2 === void 0 ? void 0 : (_document$getElementB3 = _document$getElementB2.contentWindow) === null || _document$getElementB3 === void 0 ? void 0 : _document$getElementB3.location.reload();
-    (_document$getElementB4 = document.getElementById("game")) === null || _document$getElementB4 === void 0 ? void 0 : (_document$getElementB5 = _document$getElementB4.contentWindow) === null || _document$getElementB5 === void 0 ? void 0 : _document$getElementB5.location.reload();
-  }, []);
-  (0,react__WEBPACK_IMPORTED_MODULE_1__.useEffect)(() => {
-    const evtSource = new reconnecting_eventsource__WEBPACK_IMPORTED_MODULE_0__["default"]("/events");
-    evtSource.onmessage = m => {
-      var _document$getElementB6, _document$getElementB7, _document$getElementB8, _document$getElementB9;
-      const e = JSON.parse(m.data);
-      switch (e.type) {
-        case "reload":
-          switch (e.target) {
-            case "ui":
-              (_document$getElementB6 = document.getElementById("ui")) === null || _document$getElementB6 === void 0 ? void 0 : (_document$getElementB7 = _document$getElementB6.contentWindow) === null || _document$getElementB7 === void 0 ? void 0 : _document$getElementB7.location.reload();
-              setBuildError(undefined);
-              react_hot_toast__WEBPACK_IMPORTED_MODULE_4__["default"].success("UI Reloaded!");
-              break;
-            case "game":
-              (_document$getElementB8 = document.getElementById("game")) === null || _document$getElementB8 === void 0 ? void 0 : (_document$getElementB9 = _document$getElementB8.contentWindow) === null || _document$getElementB9 === void 0 ? void 0 : _document$getElementB9.location.reload();
-              setBuildError(undefined);
-              react_hot_toast__WEBPACK_IMPORTED_MODULE_4__["default"].success("Game Reloaded!");
-              break;
-          }
-          break;
-        case "buildError":
-          setBuildError({
-            out: e.out,
-            err: e.err
-          });
-          break;
-        case "ping":
-          break;
-      }
-    };
-    evtSource.onerror = e => {
-      react_hot_toast__WEBPACK_IMPORTED_MODULE_4__["default"].error(`Error from eventsource: ${e.message}`);
-      console.error("eventsource error", e);
-    };
-    return () => evtSource.close();
-  }, []);
-  const users = (0,react__WEBPACK_IMPORTED_MODULE_1__.useMemo)(() => {
-    const users = possibleUsers.slice(0, numberOfUsers).map(u => userWithPlayerDetails(u, players.find(p => u.id === p.id)));
-    players.forEach(p => {
-      if (users.find(u => u.id === p.id)) return;
-      users.push(userWithPlayerDetails(p, p));
-    });
-    return users;
-  }, [userWithPlayerDetails, numberOfUsers, players]);
-  const processKey = (0,react__WEBPACK_IMPORTED_MODULE_1__.useCallback)(code => {
-    var _document$getElementB10, _document$getElementB11, _document$getElementB12, _document$getElementB13;
-    const keys = ["Digit1", "Digit2", "Digit3", "Digit4", "Digit5", "Digit6", "Digit7", "Digit8", "Digit9", "Digit0"];
-    const validKeys = keys.slice(0, players.length);
-    switch (code) {
-      case "KeyS":
-        setSaveStatesOpen(s => !s);
-        return true;
-      case "KeyF":
-        setFullScreen(s => !s);
-        return true;
-      case "KeyR":
-        (_document$getElementB10 = document.getElementById("ui")) === null || _document$getElementB10 === void 0 ? void 0 : (_document$getElementB11 = _document$getElementB10.contentWindow) === null || _document$getElementB11 === void 0 ? void 0 : _document$getElementB11.location.reload();
-        (_document$getElementB12 = document.getElementById("game")) === null || _document$getElementB12 === void 0 ? void 0 : (_document$getElementB13 = _document$getElementB12.contentWindow) === null || _document$getElementB13 === void 0 ? void 0 : _document$getElementB13.location.reload();
-        return true;
-      case "Digit1":
-      case "Digit2":
-      case "Digit3":
-      case "Digit4":
-      case "Digit5":
-      case "Digit6":
-      case "Digit7":
-      case "Digit8":
-      case "Digit9":
-      case "Digit0":
-        const idx = validKeys.indexOf(code);
-        setCurrentUserID(players[idx].id);
-        return true;
-      default:
-        return false;
-    }
-  }, [players]);
-  (0,react__WEBPACK_IMPORTED_MODULE_1__.useEffect)(() => {
-    const listener = async e => {
-      const evt = JSON.parse(JSON.stringify(e.data));
-      switch (evt.type) {
-        case "initialStateResult":
-          (0,_game__WEBPACK_IMPORTED_MODULE_8__.resolveGamePromise)(evt.id, evt.state);
-          break;
-        case "processMoveResult":
-          if (evt.error) {
-            (0,_game__WEBPACK_IMPORTED_MODULE_8__.rejectGamePromise)(evt.id, evt.error);
-          } else {
-            (0,_game__WEBPACK_IMPORTED_MODULE_8__.resolveGamePromise)(evt.id, evt.state);
-          }
-          break;
-        case "reprocessHistoryResult":
-          (0,_game__WEBPACK_IMPORTED_MODULE_8__.resolveGamePromise)(evt.id, {
-            error: evt.error,
-            initialState: evt.initialState,
-            updates: evt.updates
-          });
-          break;
-        case "updateSettings":
-          if (!host) return;
-          setSettings(evt.settings);
-          setNumberAndSeat(evt.seatCount);
-          sendToUI({
-            type: "messageProcessed",
-            id: evt.id,
-            error: undefined
-          });
-          break;
-        case "move":
-          const previousState = history.length === 0 ? initialState.state : history[history.length - 1].state;
-          if (previousState.game.phase === "finished") break;
-          try {
-            const moveUpdate = await (0,_game__WEBPACK_IMPORTED_MODULE_8__.processMove)(previousState.game, {
-              position: currentPlayer.position,
-              data: evt.data
-            });
-            const newHistory = [...history, {
-              position: currentPlayer.position,
-              seq: history.length,
-              state: moveUpdate,
-              data: evt.data
-            }];
-            setHistory(newHistory);
-            setHistoryPin(undefined);
-            sendToUI({
-              type: "messageProcessed",
-              id: evt.id,
-              error: undefined
-            });
-            setCurrentUserIDRequested(undefined);
-            if (moveUpdate.game.phase === "started" && autoSwitch && moveUpdate.game.currentPlayers[0] !== currentPlayer.position) {
-              setCurrentUserID(players.find(p => p.position === moveUpdate.game.currentPlayers[0]).id);
-              return;
+    }, [
+        darkMode
+    ]);
+    const currentPlayer = useMemo(()=>players.find((p)=>p.id === currentUserID), [
+        players,
+        currentUserID
+    ]);
+    const loadSaveStates = useCallback(async ()=>{
+        const response = await fetch("/states");
+        const states = await response.json();
+        setSaveStates(states.entries);
+    }, []);
+    const journalQueue = useRef(Promise.resolve());
+    const journal = useCallback((entry)=>{
+        journalQueue.current = journalQueue.current.then(async ()=>{
+            try {
+                const response = await fetch("/autosave", {
+                    headers: {
+                        "Content-type": "application/json"
+                    },
+                    body: JSON.stringify(entry),
+                    method: "POST"
+                });
+                if (!response.ok) {
+                    toast.error(`Unable to autosave ${entry.type}: ${(await response.text()).trim() || response.statusText}`);
+                }
+            } catch (e) {
+                console.error("unable to write autosave journal", e);
             }
-            await updateUI(moveUpdate);
-          } catch (err) {
-            sendToUI({
-              type: "messageProcessed",
-              id: evt.id,
-              error: String(err)
-            });
-          }
-          break;
-        case "ready":
-          if (!initialState) {
+        });
+        return journalQueue.current;
+    }, []);
+    const getCurrentState = useCallback((history)=>{
+        const start = history?.[0]?.seq ?? 0;
+        const historyItem = (historyPin ?? start + (history?.length ?? 0) - 1) - start;
+        return history && historyItem >= 0 ? history[historyItem].state : initialState.state;
+    }, [
+        initialState,
+        historyPin
+    ]);
+    const sendToUI = useCallback((data)=>{
+        document.getElementById("ui")?.contentWindow.postMessage(JSON.parse(JSON.stringify(data)), frameTargetOrigin);
+    }, []);
+    const userWithPlayerDetails = useCallback((user, player)=>{
+        return {
+            id: user.id,
+            name: player?.name ?? user.name,
+            avatar: avatarURL(user.id),
+            playerDetails: player ? {
+                color: player.color,
+                position: player.position,
+                settings: player.settings,
+                ready: playerReadiness.get(user.id) ?? true,
+                sessionURL: host ? document.location.href : undefined
+            } : undefined
+        };
+    }, [
+        host,
+        playerReadiness
+    ]);
+    useEffect(()=>{
+        loadSaveStates();
+    }, [
+        loadSaveStates
+    ]);
+    useEffect(()=>{
+        if (phase === "new") {
             sendToUI({
-              type: "settingsUpdate",
-              settings,
-              seatCount
+                type: "settingsUpdate",
+                settings,
+                seatCount
             });
+        }
+    }, [
+        phase,
+        sendToUI,
+        settings,
+        seatCount
+    ]);
+    const setNumberAndSeat = useCallback((n)=>{
+        setSeatCount(n);
+        setNumberOfUsers(Math.max(n, numberOfUsers));
+        if (n > players.length) {
+            setPlayers(players.concat(possibleUsers.slice(players.length, n).map((u, i)=>({
+                    id: u.id,
+                    name: u.name,
+                    avatar: avatarURL(u.id),
+                    color: u.color ?? colors[players.length + i],
+                    position: players.length + i + 1,
+                    host: players.length + i === 0
+                }))));
+            setPlayerReadiness(new Map([
+                ...playerReadiness,
+                ...possibleUsers.slice(numberOfUsers).map((p)=>[
+                        p.id,
+                        p.id !== possibleUsers[0].id
+                    ])
+            ]));
             sendToUI({
-              type: "users",
-              users
+                type: "settingsUpdate",
+                settings,
+                seatCount: n
             });
-          } else {
-            await updateUI(getCurrentState(history));
-          }
-          break;
-        case "updatePlayers":
-          let newPlayers = players.slice();
-          let p;
-          for (let op of evt.operations) {
-            switch (op.type) {
-              case "seat":
-                if (host || op.userID === currentUserID) newPlayers.push({
-                  color: op.color,
-                  name: op.name,
-                  avatar: avatarURL(op.userID),
-                  host: op.userID === possibleUsers[0].id,
-                  position: op.position,
-                  id: op.userID
+        }
+    }, [
+        numberOfUsers,
+        playerReadiness,
+        settings,
+        sendToUI,
+        players
+    ]);
+    useEffect(()=>{
+        if (numberOfUsers === 0) setNumberAndSeat(deepLink?.players ?? minPlayers);
+    }, [
+        numberOfUsers,
+        setNumberAndSeat
+    ]);
+    const loadEarlierHistory = useCallback(async (until)=>{
+        const start = history[0]?.seq ?? 0;
+        if (!historySource || start === 0 || until !== undefined && (until < 0 || until >= start)) {
+            return history;
+        }
+        if (loadingHistory.current && until === undefined) {
+            return loadingHistory.current;
+        }
+        loadingHistory.current = (async ()=>{
+            const from = Math.max(0, Math.min(until ?? start - historyPageSize, start - historyPageSize));
+            const page = await fetch(`${historySource}?from=${from}&to=${start - 1}`);
+            const earlier = (await page.json()).history;
+            setHistory((h)=>[
+                    ...earlier.filter((e)=>e.seq < (h[0]?.seq ?? 0)),
+                    ...h
+                ]);
+            if (from === 0) setHistorySource(undefined);
+            return [
+                ...earlier,
+                ...history
+            ];
+        })();
+        try {
+            return await loadingHistory.current;
+        } finally{
+            loadingHistory.current = undefined;
+        }
+    }, [
+        history,
+        historySource
+    ]);
+    const saveCurrentState = useCallback(async (name, randomSeed, initialState, history, settings, players, gameStateVersion)=>{
+        return fetch(`/states/${encodeURIComponent(name)}`, {
+            headers: {
+                "Content-type": "application/json"
+            },
+            body: JSON.stringify({
+                gameStateVersion,
+                randomSeed,
+                initialState,
+                history,
+                players,
+                settings
+            }),
+            method: "POST"
+        }).then(()=>loadSaveStates());
+    }, [
+        loadSaveStates
+    ]);
+    const saveCurrentStateCallback = useCallback((e)=>{
+        e.preventDefault();
+        const target = e.target;
+        loadEarlierHistory(0).then((fullHistory)=>saveCurrentState(getRandomSeed(), target.name.value, initialState, fullHistory, settings, players, gameStateVersion));
+    }, [
+        saveCurrentState,
+        getRandomSeed,
+        initialState,
+        loadEarlierHistory,
+        settings,
+        players,
+        gameStateVersion
+    ]);
+    const bootstrap = useCallback(()=>{
+        return JSON.stringify({
+            host: currentUserID === possibleUsers[0].id,
+            userID: currentUserID,
+            minPlayers,
+            maxPlayers,
+            defaultPlayers,
+            dev: true
+        });
+    }, [
+        currentUserID
+    ]);
+    const updateUI = useCallback(async (update)=>{
+        if (reprocessing) return;
+        const playerState = update.players.find((p)=>p.position === currentPlayer.position)?.state;
+        switch(update.game.phase){
+            case "finished":
+                sendToUI({
+                    type: "gameFinished",
+                    position: currentPlayer.position,
+                    state: playerState,
+                    winners: update.game.winners
                 });
                 break;
-              case "unseat":
-                const unseatOp = op;
-                if (host || op.userID === currentUserID) {
-                  newPlayers = newPlayers.filter(p => p.id !== unseatOp.userID);
-                }
-                break;
-              case "update":
-                const updateOp = op;
-                p = newPlayers.find(p => p.id === updateOp.userID);
-                if (!p || !host && op.userID !== currentUserID) continue;
-                if (op.color) {
-                  p.color = op.color;
-                }
-                if (op.name) {
-                  p.name = op.name;
-                }
-                if (op.ready !== undefined) {
-                  setPlayerReadiness(new Map([...playerReadiness, [p.id, op.ready]]));
-                }
-                if (op.settings) {
-                  p.settings = op.settings;
+            case "started":
+                const next = firstPersonPlaying(players, update.game.currentPlayers);
+                if (autoSwitch && next && next.position !== currentPlayer.position && currentUserIDRequested === undefined) {
+                    setCurrentUserID(next.id);
+                    return;
                 }
+                sendToUI({
+                    type: "gameUpdate",
+                    position: currentPlayer.position,
+                    state: playerState,
+                    currentPlayers: update.game.currentPlayers,
+                    readOnly: historyPin !== undefined
+                });
                 break;
+        }
+    }, [
+        sendToUI,
+        reprocessing,
+        autoSwitch,
+        players,
+        currentPlayer,
+        currentUserIDRequested,
+        historyPin
+    ]);
+    const start = useCallback(async ()=>{
+        const randomSeed = getRandomSeed();
+        const initialUpdate = await sendInitialState({
+            randomSeed,
+            players,
+            settings
+        });
+        const newInitialState = {
+            state: initialUpdate,
+            players,
+            settings
+        };
+        setInitialState(newInitialState);
+        setGameStateVersion(undefined);
+        setPhase("started");
+        journal({
+            type: "setup",
+            randomSeed,
+            settings,
+            players,
+            initialState: newInitialState
+        });
+        await updateUI(initialUpdate);
+    }, [
+        getRandomSeed,
+        players,
+        settings,
+        updateUI,
+        journal
+    ]);
+    useEffect(()=>{
+        if (phase === "new" && players.length >= minPlayers && players.length === seatCount && (deepLinkPending || players.every((p)=>playerReadiness.get(p.id)))) {
+            setDeepLinkPending(false);
+            start();
+        }
+    }, [
+        players,
+        playerReadiness,
+        seatCount,
+        start,
+        phase,
+        deepLinkPending
+    ]);
+    const copyLink = useCallback(()=>{
+        const params = new URLSearchParams();
+        const token = new URLSearchParams(document.location.search).get("token");
+        if (token) params.set("token", token);
+        params.set("players", String(seatCount));
+        params.set("seed", getRandomSeed());
+        params.set("settings", JSON.stringify(settings));
+        params.set("user", currentUserID);
+        navigator.clipboard.writeText(`${window.location.origin}/?${params}`);
+        toast.success("Link to this setup copied");
+    }, [
+        seatCount,
+        getRandomSeed,
+        settings,
+        currentUserID
+    ]);
+    const resetGame = useCallback(()=>{
+        setPhase("new");
+        setSettings({});
+        setInitialState(undefined);
+        setHistory([]);
+        setHistorySource(undefined);
+        setGameStateVersion(undefined);
+        setPlayers([]);
+        setCurrentUserID(possibleUsers[0].id);
+        journal({
+            type: "end"
+        });
+        reloadFrame("ui");
+        reloadFrame("game");
+    }, [
+        journal
+    ]);
+    const applySaveState = useCallback((state, source)=>{
+        const partial = (state.history[0]?.seq ?? 0) > 0;
+        setRandomSeed(state.randomSeed);
+        setInitialState(state.initialState);
+        setHistory(state.history);
+        setHistorySource(partial ? source : undefined);
+        setHistoryPin(undefined);
+        setGameStateVersion(state.gameStateVersion);
+        setSettings(state.settings);
+        setPlayers(state.players);
+        if (partial) {
+            reloadFrame("ui");
+        } else {
+            reloadFrame("game");
+        }
+    }, [
+        setRandomSeed
+    ]);
+    useEffect(()=>{
+        const evtSource = new ReconnectingEventSource(`/events?client=${encodeURIComponent(clientID)}`);
+        evtSource.onmessage = (m)=>{
+            const e = JSON.parse(m.data);
+            switch(e.type){
+                case "reload":
+                    switch(e.target){
+                        case "ui":
+                            reloadFrame("ui");
+                            setBuildError(undefined);
+                            toast.success("UI Reloaded!");
+                            break;
+                        case "game":
+                            reloadFrame("game");
+                            setBuildError(undefined);
+                            toast.success("Game Reloaded!");
+                            break;
+                    }
+                    break;
+                case "buildError":
+                    setBuildError({
+                        out: e.out,
+                        err: e.err
+                    });
+                    break;
+                case "userOnline":
+                    sendToUI({
+                        type: "userOnline",
+                        id: e.id,
+                        online: e.online
+                    });
+                    break;
+                case "sessionUpdated":
+                    fetch("/autosave").then((res)=>res.json()).then((state)=>{
+                        applySaveState(state);
+                        setPhase("started");
+                        setNumberOfUsers((n)=>Math.max(n, state.players.length));
+                        setSeatCount(state.players.length);
+                    });
+                    break;
+                case "invariantViolation":
+                    for (const v of e.violations){
+                        const where = e.move === undefined ? "the initial state" : `move ${e.seq + 1} by position ${e.position}`;
+                        toast.error(`Invariant ${v.invariant} broken by ${where}: ${v.detail}`);
+                    }
+                    break;
+                case "stateSizeWarning":
+                    for (const w of e.warnings){
+                        const where = e.move === undefined ? "in the initial state" : `after move ${e.seq + 1} by position ${e.position}`;
+                        const what = w.budget === "playerView" ? `Position ${w.position}'s view` : w.budget === "history" ? "The history" : "The GameUpdate";
+                        const largest = w.paths[0] ? `, mostly ${w.paths[0].path}` : "";
+                        toast(`${what} is over its size budget ${where}${largest}`, {
+                            icon: "📦"
+                        });
+                    }
+                    break;
+                case "protocolViolation":
+                    for (const v of e.violations){
+                        toast.error(`UI sent an invalid ${v.type} message: ${v.problem}`);
+                    }
+                    break;
+                case "cspViolation":
+                    setCSPViolations((v)=>[
+                            ...v,
+                            e.violation
+                        ]);
+                    toast.error(`CSP blocked ${e.violation.blockedURI} by ${e.violation.directive}`);
+                    break;
+                case "ping":
+                    break;
             }
-            setPlayers(newPlayers);
-          }
-          sendToUI({
-            type: "messageProcessed",
-            id: evt.id,
-            error: undefined
-          });
-          break;
-        // special event for player switching
-        case "key":
-          processKey(evt.code);
-          break;
-        case "sendDark":
-          sendToUI({
+        };
+        evtSource.onerror = (e)=>{
+            toast.error(`Error from eventsource: ${e.message}`);
+            console.error("eventsource error", e);
+        };
+        return ()=>evtSource.close();
+    }, [
+        sendToUI,
+        applySaveState
+    ]);
+    const users = useMemo(()=>{
+        const users = possibleUsers.slice(0, numberOfUsers).map((u)=>userWithPlayerDetails(u, players.find((p)=>u.id === p.id)));
+        players.forEach((p)=>{
+            if (users.find((u)=>u.id === p.id)) return;
+            users.push(userWithPlayerDetails(p, p));
+        });
+        return users;
+    }, [
+        userWithPlayerDetails,
+        numberOfUsers,
+        players
+    ]);
+    const processKey = useCallback((code1)=>{
+        const keys = [
+            "Digit1",
+            "Digit2",
+            "Digit3",
+            "Digit4",
+            "Digit5",
+            "Digit6",
+            "Digit7",
+            "Digit8",
+            "Digit9",
+            "Digit0"
+        ];
+        const validKeys = keys.slice(0, players.length);
+        switch(code1){
+            case "KeyS":
+                setSaveStatesOpen((s)=>!s);
+                return true;
+            case "KeyF":
+                setFullScreen((s)=>!s);
+                return true;
+            case "KeyR":
+                reloadFrame("ui");
+                reloadFrame("game");
+                return true;
+            case "Digit1":
+            case "Digit2":
+            case "Digit3":
+            case "Digit4":
+            case "Digit5":
+            case "Digit6":
+            case "Digit7":
+            case "Digit8":
+            case "Digit9":
+            case "Digit0":
+                const idx = validKeys.indexOf(code1);
+                setCurrentUserID(players[idx].id);
+                return true;
+            default:
+                return false;
+        }
+    }, [
+        players
+    ]);
+    useEffect(()=>{
+        const listener = async (e)=>{
+            const frames = [
+                "ui",
+                "game"
+            ].map((id)=>document.getElementById(id)?.contentWindow);
+            if (!e.source || !frames.includes(e.source)) return;
+            const evt = JSON.parse(JSON.stringify(e.data));
+            if (e.source === frames[0]) {
+                const latest = history.length === 0 ? initialState?.state : history[history.length - 1].state;
+                fetch("/_protocol", {
+                    headers: {
+                        "Content-type": "application/json"
+                    },
+                    body: JSON.stringify({
+                        message: evt,
+                        phase: phase === "new" ? "new" : latest?.game.phase ?? "started",
+                        userID: currentUserID,
+                        host,
+                        seatCount
+                    }),
+                    method: "POST"
+                }).catch((err)=>console.error("unable to check message", err));
+            }
+            switch(evt.type){
+                case "initialStateResult":
+                    resolveGamePromise(evt.id, evt.state);
+                    break;
+                case "processMoveResult":
+                    if (evt.error) {
+                        rejectGamePromise(evt.id, evt.error);
+                    } else {
+                        resolveGamePromise(evt.id, evt.state);
+                    }
+                    break;
+                case "reprocessHistoryResult":
+                    resolveGamePromise(evt.id, {
+                        error: evt.error,
+                        initialState: evt.initialState,
+                        updates: evt.updates
+                    });
+                    break;
+                case "timing":
+                    if (e.source !== frames[1]) return;
+                    fetch("/_timings", {
+                        headers: {
+                            "Content-type": "application/json"
+                        },
+                        body: JSON.stringify(evt),
+                        method: "POST"
+                    }).catch((err)=>console.error("unable to record timing", err));
+                    break;
+                case "updateSettings":
+                    if (!host) return;
+                    setSettings(evt.settings);
+                    setNumberAndSeat(evt.seatCount);
+                    sendToUI({
+                        type: "messageProcessed",
+                        id: evt.id,
+                        error: undefined
+                    });
+                    break;
+                case "move":
+                    const previousState = history.length === 0 ? initialState.state : history[history.length - 1].state;
+                    if (previousState.game.phase === "finished") break;
+                    try {
+                        const moveUpdate = await processMove(previousState.game, {
+                            position: currentPlayer.position,
+                            data: evt.data
+                        });
+                        const newHistoryItem = {
+                            position: currentPlayer.position,
+                            seq: (history[history.length - 1]?.seq ?? -1) + 1,
+                            state: moveUpdate,
+                            data: evt.data
+                        };
+                        const newHistory = [
+                            ...history,
+                            newHistoryItem
+                        ];
+                        setHistory(newHistory);
+                        journal({
+                            type: "move",
+                            move: newHistoryItem
+                        });
+                        setHistoryPin(undefined);
+                        sendToUI({
+                            type: "messageProcessed",
+                            id: evt.id,
+                            error: undefined
+                        });
+                        setCurrentUserIDRequested(undefined);
+                        const next = moveUpdate.game.phase === "started" ? firstPersonPlaying(players, moveUpdate.game.currentPlayers) : undefined;
+                        if (autoSwitch && next && next.position !== currentPlayer.position) {
+                            setCurrentUserID(next.id);
+                            return;
+                        }
+                        await updateUI(moveUpdate);
+                    } catch (err) {
+                        sendToUI({
+                            type: "messageProcessed",
+                            id: evt.id,
+                            error: String(err)
+                        });
+                    }
+                    break;
+                case "ready":
+                    if (!initialState) {
+                        sendToUI({
+                            type: "settingsUpdate",
+                            settings,
+                            seatCount
+                        });
+                        sendToUI({
+                            type: "users",
+                            users
+                        });
+                    } else {
+                        await updateUI(getCurrentState(history));
+                    }
+                    break;
+                case "updatePlayers":
+                    let newPlayers = players.slice();
+                    let p;
+                    for (let op of evt.operations){
+                        switch(op.type){
+                            case "seat":
+                                if (host || op.userID === currentUserID) newPlayers.push({
+                                    color: op.color,
+                                    name: op.name,
+                                    avatar: avatarURL(op.userID),
+                                    host: op.userID === possibleUsers[0].id,
+                                    position: op.position,
+                                    id: op.userID
+                                });
+                                break;
+                            case "unseat":
+                                const unseatOp = op;
+                                if (host || op.userID === currentUserID) {
+                                    newPlayers = newPlayers.filter((p)=>p.id !== unseatOp.userID);
+                                }
+                                break;
+                            case "update":
+                                const updateOp = op;
+                                p = newPlayers.find((p)=>p.id === updateOp.userID);
+                                if (!p || !host && op.userID !== currentUserID) continue;
+                                if (op.color) {
+                                    p.color = op.color;
+                                }
+                                if (op.name) {
+                                    p.name = op.name;
+                                }
+                                if (op.ready !== undefined) {
+                                    setPlayerReadiness(new Map([
+                                        ...playerReadiness,
+                                        [
+                                            p.id,
+                                            op.ready
+                                        ]
+                                    ]));
+                                }
+                                if (op.settings) {
+                                    p.settings = op.settings;
+                                }
+                                break;
+                        }
+                        setPlayers(newPlayers);
+                    }
+                    sendToUI({
+                        type: "messageProcessed",
+                        id: evt.id,
+                        error: undefined
+                    });
+                    break;
+                case "key":
+                    processKey(evt.code);
+                    break;
+                case "sendDark":
+                    sendToUI({
+                        type: "darkSetting",
+                        dark: document.documentElement.classList.contains("dark")
+                    });
+                    break;
+                case "crossOriginAccess":
+                    toast.error(`UI tried to access ${evt.kind}, which is blocked in production`);
+                    fetch("/_crossorigin", {
+                        method: "POST",
+                        headers: {
+                            "Content-Type": "application/json"
+                        },
+                        body: JSON.stringify({
+                            kind: evt.kind,
+                            detail: evt.detail
+                        })
+                    });
+                    break;
+            }
+        };
+        window.addEventListener("message", listener);
+        return ()=>window.removeEventListener("message", listener);
+    }, [
+        currentPlayer,
+        host,
+        history,
+        initialState,
+        numberOfUsers,
+        phase,
+        players,
+        sendToUI,
+        updateUI,
+        settings,
+        seatCount,
+        getCurrentState,
+        processKey,
+        autoSwitch,
+        currentUserID,
+        users,
+        playerReadiness,
+        setNumberAndSeat,
+        journal
+    ]);
+    useEffect(()=>{
+        const l = (e)=>{
+            if (!e.shiftKey) return;
+            if (processKey(e.code)) {
+                e.stopPropagation();
+            }
+        };
+        window.addEventListener("keyup", l);
+        return ()=>window.removeEventListener("keyup", l);
+    }, [
+        players,
+        processKey
+    ]);
+    useEffect(()=>{
+        players.forEach((p)=>{
+            sendToUI({
+                type: "userOnline",
+                id: p.id,
+                online: true
+            });
+        });
+    }, [
+        players,
+        sendToUI
+    ]);
+    useEffect(()=>{
+        sendToUI({
+            type: "users",
+            users
+        });
+    }, [
+        users,
+        sendToUI
+    ]);
+    useEffect(()=>{
+        sendToUI({
             type: "darkSetting",
-            dark: document.documentElement.classList.contains("dark")
-          });
-          break;
-      }
-    };
-    window.addEventListener("message", listener);
-    return () => window.removeEventListener("message", listener);
-  }, [currentPlayer, host, history, initialState, numberOfUsers, phase, players, sendToUI, updateUI, settings, seatCount, getCurrentState, processKey, autoSwitch, currentUserID, users, playerReadiness, setNumberAndSeat]);
-  (0,react__WEBPACK_IMPORTED_MODULE_1__.useEffect)(() => {
-    const l = e => {
-      if (!e.shiftKey) return;
-      if (processKey(e.code)) {
-        e.stopPropagation();
-      }
-    };
-    window.addEventListener("keyup", l);
-    return () => window.removeEventListener("keyup", l);
-  }, [players, processKey]);
-  (0,react__WEBPACK_IMPORTED_MODULE_1__.useEffect)(() => {
-    players.forEach(p => {
-      sendToUI({
-        type: "userOnline",
-        id: p.id,
-        online: true
-      });
-    });
-  }, [players, sendToUI]);
-  (0,react__WEBPACK_IMPORTED_MODULE_1__.useEffect)(() => {
-    sendToUI({
-      type: "users",
-      users
-    });
-  }, [users, sendToUI]);
-  (0,react__WEBPACK_IMPORTED_MODULE_1__.useEffect)(() => {
-    sendToUI({
-      type: "darkSetting",
-      dark: darkMode !== false
-    });
-  }, [darkMode, sendToUI]);
-  const loadState = (0,react__WEBPACK_IMPORTED_MODULE_1__.useCallback)(async name => {
-    var _document$getElementB14, _document$getElementB15;
-    const response = await fetch(`/states/${encodeURIComponent(name)}`);
-    const state = await response.json();
-    setRandomSeed(state.randomSeed);
-    setInitialState(state.initialState);
-    setHistory(state.history);
-    setSettings(state.settings);
-    setPlayers(state.players);
-    (_document$getElementB14 = document.getElementById("game")) === null || _document$getElementB14 === void 0 ? void 0 : (_document$getElementB15 = _document$getElementB14.contentWindow) === null || _document$getElementB15 === void 0 ? void 0 : _document$getElementB15.location.reload();
-  }, [setRandomSeed]);
-  const deleteState = (0,react__WEBPACK_IMPORTED_MODULE_1__.useCallback)(async name => {
-    await fetch(`/states/${encodeURIComponent(name)}`, {
-      method: "DELETE"
-    });
-    await loadSaveStates();
-  }, [loadSaveStates]);
-  const viewHistory = (0,react__WEBPACK_IMPORTED_MODULE_1__.useCallback)(idx => {
-    setHistoryPin(() => idx === history.length - 1 ? undefined : idx);
-    updateUI(idx === -1 ? initialState.state : history[idx].state);
-  }, [updateUI, initialState, history]);
-  const revertTo = (0,react__WEBPACK_IMPORTED_MODULE_1__.useCallback)(idx => {
-    setHistory(history.slice(0, idx + 1));
-    setHistoryPin(undefined);
-    updateUI(idx === -1 ? initialState.state : history[idx].state);
-  }, [updateUI, initialState, history]);
-  const resetRandomSeed = (0,react__WEBPACK_IMPORTED_MODULE_1__.useCallback)(() => {
-    sessionStorage.setItem("rseed", crypto.randomUUID());
-    resetGame();
-  }, [resetGame]);
-  const reprocessCurrentHistory = (0,react__WEBPACK_IMPORTED_MODULE_1__.useCallback)(async () => {
-    if (!initialState) {
-      return;
-    }
-    setReprocessing(true);
-    const randomSeed = getRandomSeed();
-    const reprocessResult = await (0,_game__WEBPACK_IMPORTED_MODULE_8__.reprocessHistory)({
-      randomSeed,
-      players,
-      settings
-    }, history.map(h => ({
-      position: h.position,
-      data: h.data
-    })));
-    if (reprocessResult.error) {
-      react_hot_toast__WEBPACK_IMPORTED_MODULE_4__["default"].error(`Error from reprocessing: ${reprocessResult.error}`);
-    }
-    const newHistory = reprocessResult.updates.map((update, i) => ({
-      seq: i,
-      state: update,
-      data: history[i].data,
-      position: history[i].position
-    }));
-    setReprocessing(false);
-    setRandomSeed(randomSeed);
-    setInitialState({
-      ...initialState,
-      state: reprocessResult.initialState
-    });
-    setHistory(newHistory);
-    setReprocessing(false);
-  }, [getRandomSeed, history, initialState, players, setRandomSeed, settings]);
-  return /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)(react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.Fragment, {
-    children: [/*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)(react_hot_toast__WEBPACK_IMPORTED_MODULE_4__.Toaster, {}, void 0, false, {
-      fileName: _jsxFileName,
-      lineNumber: 771,
-      columnNumber: 7
-    }, this), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("div", {
-      className: fullScreen || navigator.userAgent.match(/Mobi/) ? "fullscreen" : "",
-      style: {
+            dark: darkMode !== false
+        });
+    }, [
+        darkMode,
+        sendToUI
+    ]);
+    const loadState = useCallback(async (name)=>{
+        const stateURL = `/states/${encodeURIComponent(name)}`;
+        const response = await fetch(`${stateURL}?meta=true`);
+        const state = await response.json();
+        const from = Math.max(0, state.historyLength - historyPageSize);
+        const page = await fetch(`${stateURL}?from=${from}`);
+        const history = (await page.json()).history;
+        applySaveState({
+            ...state,
+            history
+        }, stateURL);
+        journal({
+            type: "setup",
+            saveState: name
+        });
+    }, [
+        applySaveState,
+        journal
+    ]);
+    useEffect(()=>{
+        if (deepLinkError) toast.error(`Ignoring link: ${deepLinkError}`);
+        if (deepLink?.state) loadState(deepLink.state);
+    }, [
+        loadState
+    ]);
+    useEffect(()=>{
+        (async ()=>{
+            if (deepLinkStarts || deepLink?.state) return;
+            const response = await fetch("/autosave");
+            if (response.status !== 200) return;
+            const state = await response.json();
+            toast((t)=>/*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("span", {children: ["Restore previous session (", state.history.length, " moves)?", " ", /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("button", {onClick: ()=>{
+                    applySaveState(state);
+                    journal({
+                        type: "setup",
+                        randomSeed: state.randomSeed,
+                        settings: state.settings,
+                        players: state.players,
+                        initialState: state.initialState,
+                        history: state.history,
+                        gameStateVersion: state.gameStateVersion
+                    });
+                    toast.dismiss(t.id);
+                }, children: "Restore"}, void 0, false, void 0, this), " ", /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("button", {onClick: ()=>toast.dismiss(t.id), children: "Dismiss"}, void 0, false, void 0, this)]}, void 0, true, void 0, this), {
+                duration: 15000
+            });
+        })();
+    }, [
+        applySaveState,
+        journal
+    ]);
+    const deleteState = useCallback(async (name)=>{
+        await fetch(`/states/${encodeURIComponent(name)}`, {
+            method: "DELETE"
+        });
+        await loadSaveStates();
+    }, [
+        loadSaveStates
+    ]);
+    const viewHistory = useCallback(async (idx)=>{
+        const loaded = await loadEarlierHistory(idx);
+        const start = loaded[0]?.seq ?? 0;
+        setHistoryPin(()=>idx === start + loaded.length - 1 ? undefined : idx);
+        updateUI(idx === -1 ? initialState.state : loaded[idx - start].state);
+    }, [
+        updateUI,
+        initialState,
+        loadEarlierHistory
+    ]);
+    const revertTo = useCallback(async (idx)=>{
+        const loaded = await loadEarlierHistory(idx);
+        const start = loaded[0]?.seq ?? 0;
+        setHistory(loaded.filter((h)=>h.seq <= idx));
+        if (idx < start) setHistorySource(undefined);
+        setHistoryPin(undefined);
+        journal({
+            type: "revert",
+            seq: idx
+        });
+        updateUI(idx === -1 ? initialState.state : loaded[idx - start].state);
+    }, [
+        updateUI,
+        initialState,
+        loadEarlierHistory,
+        journal
+    ]);
+    const resetRandomSeed = useCallback(()=>{
+        sessionStorage.setItem("rseed", crypto.randomUUID());
+        resetGame();
+    }, [
+        resetGame
+    ]);
+    const reprocessCurrentHistory = useCallback(async ()=>{
+        if (!initialState) {
+            return;
+        }
+        const fullHistory = await loadEarlierHistory(0);
+        setReprocessing(true);
+        const randomSeed = getRandomSeed();
+        const reprocessResult = await reprocessHistory({
+            randomSeed,
+            players,
+            settings
+        }, fullHistory.map((h)=>({
+                position: h.position,
+                data: h.data
+            })));
+        if (reprocessResult.error) {
+            toast.error(`Error from reprocessing: ${reprocessResult.error}`);
+        }
+        const newHistory = reprocessResult.updates.map((update, i)=>({
+                seq: i,
+                state: update,
+                data: fullHistory[i].data,
+                position: fullHistory[i].position
+            }));
+        setReprocessing(false);
+        const newInitialState = {
+            ...initialState,
+            state: reprocessResult.initialState
+        };
+        setRandomSeed(randomSeed);
+        setInitialState(newInitialState);
+        setHistory(newHistory);
+        setGameStateVersion(undefined);
+        journal({
+            type: "setup",
+            randomSeed,
+            settings,
+            players,
+            initialState: newInitialState,
+            history: newHistory
+        });
+        setReprocessing(false);
+    }, [
+        getRandomSeed,
+        loadEarlierHistory,
+        initialState,
+        players,
+        setRandomSeed,
+        settings,
+        journal
+    ]);
+    return /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)(react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.Fragment, {children: [/*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)(Toaster, {}, void 0, false, void 0, this), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("div", {className: fullScreen || navigator.userAgent.match(/Mobi/) ? "fullscreen" : "", style: {
         display: "flex",
         flexDirection: "row"
-      },
-      children: [/*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)(react_responsive_modal__WEBPACK_IMPORTED_MODULE_3__.Modal, {
-        open: !!buildError,
-        onClose: () => setBuildError(undefined),
-        center: true,
-        children: [/*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("h2", {
-          children: "BUILD ERROR!"
-        }, void 0, false, {
-          fileName: _jsxFileName,
-          lineNumber: 783,
-          columnNumber: 11
-        }, this), (buildError === null || buildError === void 0 ? void 0 : buildError.out) && /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)(react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.Fragment, {
-          children: [/*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("h4", {
-            children: "OUT"
-          }, void 0, false, {
-            fileName: _jsxFileName,
-            lineNumber: 786,
-            columnNumber: 15
-          }, this), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("pre", {
-            children: buildError === null || buildError === void 0 ? void 0 : buildError.out
-          }, void 0, false, {
-            fileName: _jsxFileName,
-            lineNumber: 787,
-            columnNumber: 15
-          }, this)]
-        }, void 0, true), (buildError === null || buildError === void 0 ? void 0 : buildError.err) && /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)(react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.Fragment, {
-          children: [/*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("h4", {
-            children: "ERR"
-          }, void 0, false, {
-            fileName: _jsxFileName,
-            lineNumber: 792,
-            columnNumber: 15
-          }, this), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("pre", {
-            children: buildError === null || buildError === void 0 ? void 0 : buildError.err
-          }, void 0, false, {
-            fileName: _jsxFileName,
-            lineNumber: 793,
-            columnNumber: 15
-          }, this)]
-        }, void 0, true)]
-      }, void 0, true, {
-        fileName: _jsxFileName,
-        lineNumber: 778,
-        columnNumber: 9
-      }, this), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)(react_responsive_modal__WEBPACK_IMPORTED_MODULE_3__.Modal, {
-        open: helpOpen,
-        onClose: () => setHelpOpen(false),
-        center: true,
-        children: [/*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("h2", {
-          children: "Help"
-        }, void 0, false, {
-          fileName: _jsxFileName,
-          lineNumber: 799,
-          columnNumber: 11
-        }, this), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("dl", {
-          children: [/*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("dt", {
-            children: [/*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("kbd", {
-              children: "Shift"
-            }, void 0, false, {
-              fileName: _jsxFileName,
-              lineNumber: 802,
-              columnNumber: 15
-            }, this), " + ", /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("kbd", {
-              children: "1"
-            }, void 0, false, {
-              fileName: _jsxFileName,
-              lineNumber: 802,
-              columnNumber: 34
-            }, this), ", ", /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("kbd", {
-              children: "Shift"
-            }, void 0, false, {
-              fileName: _jsxFileName,
-              lineNumber: 802,
-              columnNumber: 48
-            }, this), " + ", /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("kbd", {
-              children: "2"
-            }, void 0, false, {
-              fileName: _jsxFileName,
-              lineNumber: 802,
-              columnNumber: 67
-            }, this)]
-          }, void 0, true, {
-            fileName: _jsxFileName,
-            lineNumber: 801,
-            columnNumber: 13
-          }, this), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("dd", {
-            children: "Switch between users"
-          }, void 0, false, {
-            fileName: _jsxFileName,
-            lineNumber: 804,
-            columnNumber: 13
-          }, this), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("dt", {
-            children: [/*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("kbd", {
-              children: "Shift"
-            }, void 0, false, {
-              fileName: _jsxFileName,
-              lineNumber: 806,
-              columnNumber: 15
-            }, this), " + ", /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("kbd", {
-              children: "R"
-            }, void 0, false, {
-              fileName: _jsxFileName,
-              lineNumber: 806,
-              columnNumber: 34
-            }, this)]
-          }, void 0, true, {
-            fileName: _jsxFileName,
-            lineNumber: 805,
-            columnNumber: 13
-          }, this), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("dd", {
-            children: "Manually reload UI/Game iframes"
-          }, void 0, false, {
-            fileName: _jsxFileName,
-            lineNumber: 808,
-            columnNumber: 13
-          }, this), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("dt", {
-            children: [/*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("kbd", {
-              children: "Shift"
-            }, void 0, false, {
-              fileName: _jsxFileName,
-              lineNumber: 810,
-              columnNumber: 15
-            }, this), " + ", /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("kbd", {
-              children: "F"
-            }, void 0, false, {
-              fileName: _jsxFileName,
-              lineNumber: 810,
-              columnNumber: 34
-            }, this)]
-          }, void 0, true, {
-            fileName: _jsxFileName,
-            lineNumber: 809,
-            columnNumber: 13
-          }, this), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("dd", {
-            children: "Toggle full screen"
-          }, void 0, false, {
-            fileName: _jsxFileName,
-            lineNumber: 812,
-            columnNumber: 13
-          }, this), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("dt", {
-            children: [/*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("kbd", {
-              children: "Shift"
-            }, void 0, false, {
-              fileName: _jsxFileName,
-              lineNumber: 814,
-              columnNumber: 15
-            }, this), " + ", /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("kbd", {
-              children: "S"
-            }, void 0, false, {
-              fileName: _jsxFileName,
-              lineNumber: 814,
-              columnNumber: 34
-            }, this)]
-          }, void 0, true, {
-            fileName: _jsxFileName,
-            lineNumber: 813,
-            columnNumber: 13
-          }, this), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("dd", {
-            children: "Toggle save state model open"
-          }, void 0, false, {
-            fileName: _jsxFileName,
-            lineNumber: 816,
-            columnNumber: 13
-          }, this)]
-        }, void 0, true, {
-          fileName: _jsxFileName,
-          lineNumber: 800,
-          columnNumber: 11
-        }, this)]
-      }, void 0, true, {
-        fileName: _jsxFileName,
-        lineNumber: 798,
-        columnNumber: 9
-      }, this), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)(react_responsive_modal__WEBPACK_IMPORTED_MODULE_3__.Modal, {
-        open: saveStatesOpen,
-        onClose: () => setSaveStatesOpen(false),
-        center: true,
-        children: /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("div", {
-          children: [/*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("h2", {
-            children: "Save states"
-          }, void 0, false, {
-            fileName: _jsxFileName,
-            lineNumber: 826,
-            columnNumber: 13
-          }, this), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("div", {
-            style: {
-              overflowY: "auto",
-              height: "80vh",
-              width: "50vw"
-            },
-            children: saveStates.map(s => /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("div", {
-              children: [s.name, /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("br", {}, void 0, false, {
-                fileName: _jsxFileName,
-                lineNumber: 831,
-                columnNumber: 19
-              }, this), new Date(s.ctime).toString(), " ", /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("button", {
-                onClick: () => loadState(s.name),
-                children: "Open"
-              }, void 0, false, {
-                fileName: _jsxFileName,
-                lineNumber: 833,
-                columnNumber: 19
-              }, this), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("button", {
-                onClick: () => deleteState(s.name),
-                children: "Delete"
-              }, void 0, false, {
-                fileName: _jsxFileName,
-                lineNumber: 834,
-                columnNumber: 19
-              }, this)]
-            }, s.name, true, {
-              fileName: _jsxFileName,
-              lineNumber: 829,
-              columnNumber: 17
-            }, this))
-          }, void 0, false, {
-            fileName: _jsxFileName,
-            lineNumber: 827,
-            columnNumber: 13
-          }, this), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("form", {
-            onSubmit: e => saveCurrentStateCallback(e),
-            children: ["Name", " ", /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("input", {
-              type: "text",
-              name: "name",
-              onKeyUp: e => {
-                e.stopPropagation();
-              }
-            }, void 0, false, {
-              fileName: _jsxFileName,
-              lineNumber: 840,
-              columnNumber: 15
-            }, this), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("br", {}, void 0, false, {
-              fileName: _jsxFileName,
-              lineNumber: 847,
-              columnNumber: 15
-            }, this), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("input", {
-              type: "submit",
-              disabled: !initialState,
-              value: "Save new state"
-            }, void 0, false, {
-              fileName: _jsxFileName,
-              lineNumber: 848,
-              columnNumber: 15
-            }, this)]
-          }, void 0, true, {
-            fileName: _jsxFileName,
-            lineNumber: 838,
-            columnNumber: 13
-          }, this)]
-        }, void 0, true, {
-          fileName: _jsxFileName,
-          lineNumber: 825,
-          columnNumber: 11
-        }, this)
-      }, void 0, false, {
-        fileName: _jsxFileName,
-        lineNumber: 820,
-        columnNumber: 9
-      }, this), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("div", {
-        style: {
-          display: "flex",
-          flexDirection: "column",
-          flexGrow: 1
-        },
-        children: [/*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("div", {
-          className: "header",
-          children: [/*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("span", {
-            style: {
-              marginRight: "0.5em"
-            },
-            children: /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)(react_switch__WEBPACK_IMPORTED_MODULE_5__["default"], {
-              onChange: v => setAutoSwitch(v),
-              checked: autoSwitch,
-              uncheckedIcon: false,
-              checkedIcon: false
-            }, void 0, false, {
-              fileName: _jsxFileName,
-              lineNumber: 860,
-              columnNumber: 15
-            }, this)
-          }, void 0, false, {
-            fileName: _jsxFileName,
-            lineNumber: 859,
-            columnNumber: 13
-          }, this), " ", /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("span", {
-            style: {
-              marginRight: "3em"
-            },
-            children: "Autoswitch players"
-          }, void 0, false, {
-            fileName: _jsxFileName,
-            lineNumber: 867,
-            columnNumber: 13
-          }, this), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("span", {
-            style: {
-              flexGrow: 1
-            },
-            children: users.filter(u => phase === "new" || u.playerDetails).map(u => {
-              var _u$playerDetails;
-              return /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("button", {
-                className: "player",
-                onClick: () => {
-                  setCurrentUserIDRequested(u.id);
-                  setCurrentUserID(u.id);
-                },
-                style: {
-                  backgroundColor: ((_u$playerDetails = u.playerDetails) === null || _u$playerDetails === void 0 ? void 0 : _u$playerDetails.color) || "#666",
-                  opacity: currentUserID !== u.id ? 0.4 : 1,
-                  border: currentUserID !== u.id ? "2px transparent solid" : "2px black solid"
-                },
-                children: u.name
-              }, u.id, false, {
-                fileName: _jsxFileName,
-                lineNumber: 872,
-                columnNumber: 19
-              }, this);
-            })
-          }, void 0, false, {
-            fileName: _jsxFileName,
-            lineNumber: 868,
-            columnNumber: 13
-          }, this), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("span", {
-            style: {
-              marginRight: "0.5em"
-            },
-            children: "\uD83C\uDF1E"
-          }, void 0, false, {
-            fileName: _jsxFileName,
-            lineNumber: 892,
-            columnNumber: 13
-          }, this), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)(react_switch__WEBPACK_IMPORTED_MODULE_5__["default"], {
-            onChange: v => setDarkMode(v),
-            checked: darkMode,
-            uncheckedIcon: false,
-            checkedIcon: false
-          }, void 0, false, {
-            fileName: _jsxFileName,
-            lineNumber: 893,
-            columnNumber: 13
-          }, this), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("span", {
-            style: {
-              marginLeft: "0.5em"
-            },
-            children: "\uD83C\uDF1A"
-          }, void 0, false, {
-            fileName: _jsxFileName,
-            lineNumber: 899,
-            columnNumber: 13
-          }, this), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("button", {
-            style: {
-              marginLeft: "1em"
-            },
-            onClick: () => resetRandomSeed(),
-            children: "Reset seed"
-          }, void 0, false, {
-            fileName: _jsxFileName,
-            lineNumber: 900,
-            columnNumber: 13
-          }, this), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("button", {
-            style: {
-              marginLeft: "0.5em",
-              fontSize: "20pt"
-            },
-            className: "button-link",
-            onClick: () => setHelpOpen(true),
-            children: "\u24D8"
-          }, void 0, false, {
-            fileName: _jsxFileName,
-            lineNumber: 906,
-            columnNumber: 13
-          }, this)]
-        }, void 0, true, {
-          fileName: _jsxFileName,
-          lineNumber: 858,
-          columnNumber: 11
-        }, this), reprocessing && /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("div", {
-          style: {
-            height: "100vh",
-            width: "100vw"
-          },
-          children: "REPROCESSING HISTORY"
-        }, void 0, false, {
-          fileName: _jsxFileName,
-          lineNumber: 915,
-          columnNumber: 13
-        }, this), !reprocessing && /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("iframe", {
-          seamless: true,
-          style: {
-            border: 1,
-            flexGrow: 4
-          },
-          id: "ui",
-          title: "ui",
-          src: `/ui.html?bootstrap=${encodeURIComponent(bootstrap())}`
-        }, void 0, false, {
-          fileName: _jsxFileName,
-          lineNumber: 920,
-          columnNumber: 13
-        }, this), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("iframe", {
-          onLoad: () => reprocessCurrentHistory(),
-          style: {
-            height: "0",
-            width: "0"
-          },
-          id: "game",
-          title: "game",
-          src: "/game.html"
-        }, void 0, false, {
-          fileName: _jsxFileName,
-          lineNumber: 928,
-          columnNumber: 11
-        }, this)]
-      }, void 0, true, {
-        fileName: _jsxFileName,
-        lineNumber: 857,
-        columnNumber: 9
-      }, this), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("div", {
-        id: "history",
-        className: historyCollapsed ? "collapsed" : "",
-        children: [/*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("h2", {
-          children: [/*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("svg", {
-            onClick: () => setHistoryCollapsed(!historyCollapsed),
-            className: "arrow",
-            viewBox: "0 0 1024 1024",
-            version: "1.1",
-            xmlns: "http://www.w3.org/2000/svg",
-            children: [/*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("path", {
-              d: "M721.833102 597.433606l-60.943176 60.943176-211.189226-211.189225L510.643877 386.244381z",
-              fill: darkMode ? "#bbb" : "#444"
-            }, void 0, false, {
-              fileName: _jsxFileName,
-              lineNumber: 945,
-              columnNumber: 15
-            }, this), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("path", {
-              d: "M299.323503 597.30514l60.943176 60.943176 211.189226-211.189225L510.512728 386.115915z",
-              fill: darkMode ? "#bbb" : "#444"
-            }, void 0, false, {
-              fileName: _jsxFileName,
-              lineNumber: 949,
-              columnNumber: 15
-            }, this)]
-          }, void 0, true, {
-            fileName: _jsxFileName,
-            lineNumber: 938,
-            columnNumber: 13
-          }, this), historyCollapsed || /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("span", {
-            children: ["History ", /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("button", {
-              onClick: () => resetGame(),
-              children: "Reset game"
-            }, void 0, false, {
-              fileName: _jsxFileName,
-              lineNumber: 956,
-              columnNumber: 25
-            }, this)]
-          }, void 0, true, {
-            fileName: _jsxFileName,
-            lineNumber: 955,
-            columnNumber: 15
-          }, this)]
-        }, void 0, true, {
-          fileName: _jsxFileName,
-          lineNumber: 937,
-          columnNumber: 11
-        }, this), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)(_History__WEBPACK_IMPORTED_MODULE_2__["default"], {
-          players: players,
-          view: n => viewHistory(n),
-          revertTo: n => revertTo(n),
-          initialState: initialState,
-          items: history,
-          collapsed: historyCollapsed,
-          darkMode: darkMode
-        }, void 0, false, {
-          fileName: _jsxFileName,
-          lineNumber: 960,
-          columnNumber: 11
-        }, this)]
-      }, void 0, true, {
-        fileName: _jsxFileName,
-        lineNumber: 936,
-        columnNumber: 9
-      }, this)]
-    }, void 0, true, {
-      fileName: _jsxFileName,
-      lineNumber: 772,
-      columnNumber: 7
-    }, this)]
-  }, void 0, true);
+    }, children: [/*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)(Modal, {open: !!buildError, onClose: ()=>setBuildError(undefined), center: true, children: [/*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("h2", {children: "BUILD ERROR!"}, void 0, false, void 0, this), buildError?.out && /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)(react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.Fragment, {children: [/*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("h4", {children: "OUT"}, void 0, false, void 0, this), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("pre", {children: buildError?.out}, void 0, false, void 0, this)]}, void 0, true, void 0, this), buildError?.err && /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)(react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.Fragment, {children: [/*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("h4", {children: "ERR"}, void 0, false, void 0, this), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("pre", {children: buildError?.err}, void 0, false, void 0, this)]}, void 0, true, void 0, this)]}, void 0, true, void 0, this), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)(Modal, {open: cspViolationsOpen, onClose: ()=>setCSPViolationsOpen(false), center: true, children: [/*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("h2", {children: "Content Security Policy violations"}, void 0, false, void 0, this), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("button", {onClick: async ()=>{
+        const res = await fetch("/_csp-report");
+        setCSPViolations((await res.json()).violations);
+    }, children: "Refresh counts"}, void 0, false, void 0, this), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("dl", {children: cspViolations.map((v)=>/*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)(React.Fragment, {children: [/*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("dt", {children: [/*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("code", {children: v.blockedURI}, void 0, false, void 0, this), " blocked by", " ", /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("code", {children: v.directive}, void 0, false, void 0, this)]}, void 0, true, void 0, this), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("dd", {children: [v.sourceFile && `${v.sourceFile}:${v.lineNumber} `, v.count > 1 && `(${v.count} times)`]}, void 0, true, void 0, this)]}, `${v.directive} ${v.blockedURI} ${v.sourceFile}:${v.lineNumber}`, true, void 0, this))}, void 0, false, void 0, this)]}, void 0, true, void 0, this), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)(Modal, {open: helpOpen, onClose: ()=>setHelpOpen(false), center: true, children: [/*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("h2", {children: "Help"}, void 0, false, void 0, this), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("dl", {children: [/*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("dt", {children: [/*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("kbd", {children: "Shift"}, void 0, false, void 0, this), " + ", /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("kbd", {children: "1"}, void 0, false, void 0, this), ", ", /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("kbd", {children: "Shift"}, void 0, false, void 0, this), " + ", /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("kbd", {children: "2"}, void 0, false, void 0, this)]}, void 0, true, void 0, this), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("dd", {children: "Switch between users"}, void 0, false, void 0, this), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("dt", {children: [/*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("kbd", {children: "Shift"}, void 0, false, void 0, this), " + ", /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("kbd", {children: "R"}, void 0, false, void 0, this)]}, void 0, true, void 0, this), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("dd", {children: "Manually reload UI/Game iframes"}, void 0, false, void 0, this), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("dt", {children: [/*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("kbd", {children: "Shift"}, void 0, false, void 0, this), " + ", /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("kbd", {children: "F"}, void 0, false, void 0, this)]}, void 0, true, void 0, this), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("dd", {children: "Toggle full screen"}, void 0, false, void 0, this), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("dt", {children: [/*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("kbd", {children: "Shift"}, void 0, false, void 0, this), " + ", /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("kbd", {children: "S"}, void 0, false, void 0, this)]}, void 0, true, void 0, this), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("dd", {children: "Toggle save state model open"}, void 0, false, void 0, this)]}, void 0, true, void 0, this)]}, void 0, true, void 0, this), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)(Modal, {open: saveStatesOpen, onClose: ()=>setSaveStatesOpen(false), center: true, children: /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("div", {children: [/*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("h2", {children: "Save states"}, void 0, false, void 0, this), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("div", {style: {
+        overflowY: "auto",
+        height: "80vh",
+        width: "50vw"
+    }, children: saveStates.map((s)=>/*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("div", {children: [s.name, /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("br", {}, void 0, false, void 0, this), new Date(s.ctime).toString(), " ", /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("button", {onClick: ()=>loadState(s.name), children: "Open"}, void 0, false, void 0, this), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("button", {onClick: ()=>deleteState(s.name), children: "Delete"}, void 0, false, void 0, this)]}, s.name, true, void 0, this))}, void 0, false, void 0, this), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("form", {onSubmit: (e)=>saveCurrentStateCallback(e), children: ["Name", " ", /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("input", {type: "text", name: "name", onKeyUp: (e)=>{
+        e.stopPropagation();
+    }}, void 0, false, void 0, this), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("br", {}, void 0, false, void 0, this), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("input", {type: "submit", disabled: !initialState, value: "Save new state"}, void 0, false, void 0, this)]}, void 0, true, void 0, this)]}, void 0, true, void 0, this)}, void 0, false, void 0, this), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("div", {style: {
+        display: "flex",
+        flexDirection: "column",
+        flexGrow: 1
+    }, children: [/*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("div", {className: "header", children: [/*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("span", {style: {
+        marginRight: "0.5em"
+    }, children: /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)(Switch, {onChange: (v)=>setAutoSwitch(v), checked: autoSwitch, uncheckedIcon: false, checkedIcon: false}, void 0, false, void 0, this)}, void 0, false, void 0, this), " ", /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("span", {style: {
+        marginRight: "3em"
+    }, children: "Autoswitch players"}, void 0, false, void 0, this), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("span", {style: {
+        flexGrow: 1
+    }, children: users.filter((u)=>phase === "new" || u.playerDetails).map((u)=>/*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("button", {className: "player", onClick: ()=>{
+            setCurrentUserIDRequested(u.id);
+            setCurrentUserID(u.id);
+        }, style: {
+            backgroundColor: u.playerDetails?.color || "#666",
+            opacity: currentUserID !== u.id ? 0.4 : 1,
+            border: currentUserID !== u.id ? "2px transparent solid" : "2px black solid"
+        }, children: [isBot(u.id) && "🤖 ", u.name]}, u.id, true, void 0, this))}, void 0, false, void 0, this), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("span", {style: {
+        marginRight: "0.5em"
+    }, children: "🌞"}, void 0, false, void 0, this), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)(Switch, {onChange: (v)=>setDarkMode(v), checked: darkMode, uncheckedIcon: false, checkedIcon: false}, void 0, false, void 0, this), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("span", {style: {
+        marginLeft: "0.5em"
+    }, children: "🌚"}, void 0, false, void 0, this), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("button", {style: {
+        marginLeft: "1em"
+    }, onClick: ()=>resetRandomSeed(), children: "Reset seed"}, void 0, false, void 0, this), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("button", {style: {
+        marginLeft: "0.5em"
+    }, title: "Copy a link that starts a game with these players, seed and settings", onClick: ()=>copyLink(), children: "Copy link"}, void 0, false, void 0, this), cspViolations.length > 0 && /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("button", {style: {
+        marginLeft: "0.5em"
+    }, className: "button-link", title: "Content Security Policy violations", onClick: ()=>setCSPViolationsOpen(true), children: ["🛡 ", cspViolations.length]}, void 0, true, void 0, this), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("button", {style: {
+        marginLeft: "0.5em",
+        fontSize: "20pt"
+    }, className: "button-link", onClick: ()=>setHelpOpen(true), children: "ⓘ"}, void 0, false, void 0, this)]}, void 0, true, void 0, this), reprocessing && /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("div", {style: {
+        height: "100vh",
+        width: "100vw"
+    }, children: "REPROCESSING HISTORY"}, void 0, false, void 0, this), !reprocessing && /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("iframe", {seamless: true, style: {
+        border: 1,
+        flexGrow: 4
+    }, id: "ui", title: "ui", src: `${uiOrigin}/ui.html?bootstrap=${encodeURIComponent(bootstrap())}`}, void 0, false, void 0, this), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("iframe", {onLoad: ()=>reprocessCurrentHistory(), style: {
+        height: "0",
+        width: "0"
+    }, id: "game", title: "game", src: `${gameOrigin}/game.html`}, void 0, false, void 0, this)]}, void 0, true, void 0, this), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("div", {id: "history", className: historyCollapsed ? "collapsed" : "", children: [/*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("h2", {children: [/*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("svg", {onClick: ()=>setHistoryCollapsed(!historyCollapsed), className: "arrow", viewBox: "0 0 1024 1024", version: "1.1", xmlns: "http://www.w3.org/2000/svg", children: [/*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("path", {d: "M721.833102 597.433606l-60.943176 60.943176-211.189226-211.189225L510.643877 386.244381z", fill: darkMode ? "#bbb" : "#444"}, void 0, false, void 0, this), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("path", {d: "M299.323503 597.30514l60.943176 60.943176 211.189226-211.189225L510.512728 386.115915z", fill: darkMode ? "#bbb" : "#444"}, void 0, false, void 0, this)]}, void 0, true, void 0, this), historyCollapsed || /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("span", {children: ["History ", /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)("button", {onClick: ()=>resetGame(), children: "Reset game"}, void 0, false, void 0, this)]}, void 0, true, void 0, this)]}, void 0, true, void 0, this), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_9__.jsxDEV)(History, {players: players, view: (n)=>viewHistory(n), revertTo: (n)=>revertTo(n), initialState: initialState, items: history, loadEarlier: historySource ? ()=>loadEarlierHistory() : undefined, collapsed: historyCollapsed, darkMode: darkMode}, void 0, false, void 0, this)]}, void 0, true, void 0, this)]}, void 0, true, void 0, this)]}, void 0, true, void 0, this);
 }
-_s(App, "Ms4HQ5DD0K7RfakLE5RZLa2eFFo=");
-_c = App;
 /* harmony default export */ const __WEBPACK_DEFAULT_EXPORT__ = (App);
+_c = App;
 var _c;
 __webpack_require__.$Refresh$.register(_c, "App");
 
@@ -1127,173 +1095,70 @@ __webpack_require__.r(__webpack_exports__);
 /* provided dependency */ var __react_refresh_utils__ = __webpack_require__(/*! ./node_modules/@pmmmwh/react-refresh-webpack-plugin/lib/runtime/RefreshUtils.js */ "./node_modules/@pmmmwh/react-refresh-webpack-plugin/lib/runtime/RefreshUtils.js");
 __webpack_require__.$Refresh$.runtime = __webpack_require__(/*! ./node_modules/react-refresh/runtime.js */ "./node_modules/react-refresh/runtime.js");
 
-var _jsxFileName = "/src/History.tsx",
-  _s = __webpack_require__.$Refresh$.signature();
-
-
-
-
-
-
-
-function History({
-  items,
-  initialState,
-  revertTo,
-  view,
-  players,
-  collapsed,
-  darkMode
-}) {
-  _s();
-  const historyEndRef = (0,react__WEBPACK_IMPORTED_MODULE_0__.useRef)(null);
-  const player = (0,react__WEBPACK_IMPORTED_MODULE_0__.useCallback)(pos => {
-    const p = players.find(p => p.position === pos);
-    if (!p) {
-      throw new Error("cannot find player");
-    }
-    return p;
-  }, [players]);
-  (0,react__WEBPACK_IMPORTED_MODULE_0__.useEffect)(() => {
-    var _historyEndRef$curren;
-    if (!collapsed) (_historyEndRef$curren = historyEndRef.current) === null || _historyEndRef$curren === void 0 ? void 0 : _historyEndRef$curren.scrollIntoView({
-      behavior: "smooth"
+var _jsxFileName = "/src/History.tsx";
+
+const { useCallback, useRef, useEffect, useLayoutEffect, UIEvent } = react__WEBPACK_IMPORTED_MODULE_0__;
+const JsonView = _uiw_react_json_view__WEBPACK_IMPORTED_MODULE_1__["default"];
+const { lightTheme } = _uiw_react_json_view_light__WEBPACK_IMPORTED_MODULE_2__;
+const { darkTheme } = _uiw_react_json_view_dark__WEBPACK_IMPORTED_MODULE_3__;
+function History({ items, initialState, revertTo, view, loadEarlier, players, collapsed, darkMode }) {
+    const historyEndRef = useRef(null);
+    const player = useCallback((pos)=>{
+        const p = players.find((p)=>p.position === pos);
+        if (!p) {
+            throw new Error("cannot find player");
+        }
+        return p;
+    }, [
+        players
+    ]);
+    const lastSeq = items[items.length - 1]?.seq;
+    useEffect(()=>{
+        if (!collapsed) historyEndRef.current?.scrollIntoView({
+            behavior: "smooth"
+        });
+    }, [
+        lastSeq,
+        collapsed
+    ]);
+    const listRef = useRef(null);
+    const scrollHeight = useRef(0);
+    const firstSeq = items[0]?.seq;
+    useLayoutEffect(()=>{
+        const list = listRef.current;
+        if (list && scrollHeight.current) list.scrollTop += list.scrollHeight - scrollHeight.current;
+    }, [
+        firstSeq
+    ]);
+    useLayoutEffect(()=>{
+        scrollHeight.current = listRef.current?.scrollHeight ?? 0;
     });
-  }, [items, collapsed]);
-  return /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_5__.jsxDEV)("div", {
-    className: "history-list",
-    style: {
-      overflowY: "scroll"
-    },
-    children: [initialState && !collapsed && /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_5__.jsxDEV)(react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_5__.Fragment, {
-      children: ["Initial state", /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_5__.jsxDEV)("button", {
-        onClick: () => view(-1),
-        children: "View"
-      }, void 0, false, {
-        fileName: _jsxFileName,
-        lineNumber: 51,
-        columnNumber: 11
-      }, this), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_5__.jsxDEV)("button", {
-        onClick: () => revertTo(-1),
-        children: "Revert"
-      }, void 0, false, {
-        fileName: _jsxFileName,
-        lineNumber: 52,
-        columnNumber: 11
-      }, this), Object.entries(initialState.state.messages || []).map(([key, m]) => /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_5__.jsxDEV)("div", {
-        dangerouslySetInnerHTML: {
-          __html: m.body.replace(/\[\[[^|]*\|(.*?)\]\]/g, "<b>$1</b>")
-        }
-      }, key, false, {
-        fileName: _jsxFileName,
-        lineNumber: 54,
-        columnNumber: 13
-      }, this)), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_5__.jsxDEV)(_uiw_react_json_view__WEBPACK_IMPORTED_MODULE_1__["default"], {
-        value: initialState,
-        style: darkMode ? _uiw_react_json_view_dark__WEBPACK_IMPORTED_MODULE_3__.darkTheme : _uiw_react_json_view_light__WEBPACK_IMPORTED_MODULE_2__.lightTheme,
-        collapsed: 1
-      }, void 0, false, {
-        fileName: _jsxFileName,
-        lineNumber: 61,
-        columnNumber: 11
-      }, this)]
-    }, void 0, true), initialState && collapsed && /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_5__.jsxDEV)("button", {
-      onClick: () => view(-1),
-      style: {
+    const scrollTop = useRef(0);
+    const onScroll = useCallback((e)=>{
+        const top = e.currentTarget.scrollTop;
+        if (loadEarlier && top < scrollTop.current && top < 100) loadEarlier();
+        scrollTop.current = top;
+    }, [
+        loadEarlier
+    ]);
+    return /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_5__.jsxDEV)("div", {className: "history-list", ref: listRef, style: {
+        overflowY: "scroll"
+    }, onScroll: onScroll, children: [initialState && !collapsed && /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_5__.jsxDEV)(react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_5__.Fragment, {children: ["Initial state", /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_5__.jsxDEV)("button", {onClick: ()=>view(-1), children: "View"}, void 0, false, void 0, this), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_5__.jsxDEV)("button", {onClick: ()=>revertTo(-1), children: "Revert"}, void 0, false, void 0, this), Object.entries(initialState.state.messages || []).map(([key, m])=>/*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_5__.jsxDEV)("div", {dangerouslySetInnerHTML: {
+            __html: m.body.replace(/\[\[[^|]*\|(.*?)\]\]/g, "<b>$1</b>")
+        }}, key, false, void 0, this)), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_5__.jsxDEV)(JsonView, {value: initialState, style: darkMode ? darkTheme : lightTheme, collapsed: 1}, void 0, false, void 0, this)]}, void 0, true, void 0, this), initialState && collapsed && /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_5__.jsxDEV)("button", {onClick: ()=>view(-1), style: {
         background: "#999"
-      },
-      children: "-"
-    }, "-1", false, {
-      fileName: _jsxFileName,
-      lineNumber: 69,
-      columnNumber: 9
-    }, this), items.map((item, i) => {
-      var _item$state, _item$state2;
-      return collapsed ? /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_5__.jsxDEV)("button", {
-        onClick: () => view(item.seq),
-        style: {
-          background: player(item.position).color
-        },
-        children: player(item.position).name.slice(0, 1)
-      }, item.seq, false, {
-        fileName: _jsxFileName,
-        lineNumber: 79,
-        columnNumber: 11
-      }, this) : /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_5__.jsxDEV)("div", {
-        children: /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_5__.jsxDEV)(react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_5__.Fragment, {
-          children: [item.seq, /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_5__.jsxDEV)("span", {
-            style: {
-              marginLeft: "3px",
-              padding: "1px",
-              border: `2px ${player(item.position).color} solid`
-            },
-            children: player(item.position).name
-          }, void 0, false, {
-            fileName: _jsxFileName,
-            lineNumber: 90,
-            columnNumber: 15
-          }, this), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_5__.jsxDEV)("button", {
-            onClick: () => view(item.seq),
-            children: "View"
-          }, void 0, false, {
-            fileName: _jsxFileName,
-            lineNumber: 99,
-            columnNumber: 15
-          }, this), i !== items.length - 1 && /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_5__.jsxDEV)("button", {
-            onClick: () => revertTo(item.seq),
-            children: "Revert"
-          }, void 0, false, {
-            fileName: _jsxFileName,
-            lineNumber: 101,
-            columnNumber: 17
-          }, this), (item.data instanceof Array ? item.data : [item.data]).filter(m => "name" in m).map((move, i) => /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_5__.jsxDEV)("div", {
-            children: /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_5__.jsxDEV)("code", {
-              children: [move.name, "(", Object.entries(move.args).map(([k, v]) => `${k}: ${v}`).join(", "), ")"]
-            }, void 0, true, {
-              fileName: _jsxFileName,
-              lineNumber: 107,
-              columnNumber: 21
-            }, this)
-          }, i, false, {
-            fileName: _jsxFileName,
-            lineNumber: 106,
-            columnNumber: 19
-          }, this)), Object.entries(item.state.messages || []).map(([key, m]) => /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_5__.jsxDEV)("div", {
-            dangerouslySetInnerHTML: {
-              __html: m.body.replace(/\[\[[^|]*\|(.*?)\]\]/g, "<b>$1</b>")
-            }
-          }, key, false, {
-            fileName: _jsxFileName,
-            lineNumber: 117,
-            columnNumber: 17
-          }, this)), ((_item$state = item.state) === null || _item$state === void 0 ? void 0 : _item$state.game.state.board) && /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_5__.jsxDEV)(_uiw_react_json_view__WEBPACK_IMPORTED_MODULE_1__["default"], {
-            value: (_item$state2 = item.state) === null || _item$state2 === void 0 ? void 0 : _item$state2.game.state.board,
-            collapsed: 0
-          }, void 0, false, {
-            fileName: _jsxFileName,
-            lineNumber: 128,
-            columnNumber: 17
-          }, this)]
-        }, void 0, true)
-      }, item.seq, false, {
-        fileName: _jsxFileName,
-        lineNumber: 87,
-        columnNumber: 11
-      }, this);
-    }), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_5__.jsxDEV)("div", {
-      ref: historyEndRef
-    }, void 0, false, {
-      fileName: _jsxFileName,
-      lineNumber: 134,
-      columnNumber: 7
-    }, this)]
-  }, void 0, true, {
-    fileName: _jsxFileName,
-    lineNumber: 47,
-    columnNumber: 5
-  }, this);
+    }, children: "-"}, "-1", false, void 0, this), loadEarlier && !collapsed && /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_5__.jsxDEV)("div", {children: /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_5__.jsxDEV)("button", {onClick: ()=>loadEarlier(), children: "Load earlier moves"}, void 0, false, void 0, this)}, void 0, false, void 0, this), items.map((item, i)=>collapsed ? /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_5__.jsxDEV)("button", {onClick: ()=>view(item.seq), style: {
+            background: player(item.position).color
+        }, children: player(item.position).name.slice(0, 1)}, item.seq, false, void 0, this) : /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_5__.jsxDEV)("div", {children: /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_5__.jsxDEV)(react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_5__.Fragment, {children: [item.seq, /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_5__.jsxDEV)("span", {style: {
+            marginLeft: "3px",
+            padding: "1px",
+            border: `2px ${player(item.position).color} solid`
+        }, children: player(item.position).name}, void 0, false, void 0, this), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_5__.jsxDEV)("button", {onClick: ()=>view(item.seq), children: "View"}, void 0, false, void 0, this), i !== items.length - 1 && /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_5__.jsxDEV)("button", {onClick: ()=>revertTo(item.seq), children: "Revert"}, void 0, false, void 0, this), (item.data instanceof Array ? item.data : [
+            item.data
+        ]).filter((m)=>"name" in m).map((move, i)=>/*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_5__.jsxDEV)("div", {children: /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_5__.jsxDEV)("code", {children: [move.name, "(", Object.entries(move.args).map(([k, v])=>`${k}: ${v}`).join(", "), ")"]}, void 0, true, void 0, this)}, i, false, void 0, this)), Object.entries(item.state.messages || []).map(([key, m])=>/*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_5__.jsxDEV)("div", {dangerouslySetInnerHTML: {
+                __html: m.body.replace(/\[\[[^|]*\|(.*?)\]\]/g, "<b>$1</b>")
+            }}, key, false, void 0, this)), item.state?.game.state.board && /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_5__.jsxDEV)(JsonView, {value: item.state?.game.state.board, collapsed: 0}, void 0, false, void 0, this)]}, void 0, true, void 0, this)}, item.seq, false, void 0, this)), /*#__PURE__*/(0,react_jsx_dev_runtime__WEBPACK_IMPORTED_MODULE_5__.jsxDEV)("div", {ref: historyEndRef}, void 0, false, void 0, this)]}, void 0, true, void 0, this);
 }
-_s(History, "Htdc7b/GKGVSsPWMeePVqnQX2LM=");
 _c = History;
 var _c;
 __webpack_require__.$Refresh$.register(_c, "History");
@@ -1324,6 +1189,7 @@ if (typeof Promise !== 'undefined' && $ReactRefreshCurrentExports$ instanceof Pr
 "use strict";
 __webpack_require__.r(__webpack_exports__);
 /* harmony export */ __webpack_require__.d(__webpack_exports__, {
+/* harmony export */   frameTargetOrigin: () => (/* binding */ frameTargetOrigin),
 /* harmony export */   getPlayerState: () => (/* binding */ getPlayerState),
 /* harmony export */   processMove: () => (/* binding */ processMove),
 /* harmony export */   rejectGamePromise: () => (/* binding */ rejectGamePromise),
@@ -1334,48 +1200,50 @@ __webpack_require__.r(__webpack_exports__);
 /* provided dependency */ var __react_refresh_utils__ = __webpack_require__(/*! ./node_modules/@pmmmwh/react-refresh-webpack-plugin/lib/runtime/RefreshUtils.js */ "./node_modules/@pmmmwh/react-refresh-webpack-plugin/lib/runtime/RefreshUtils.js");
 __webpack_require__.$Refresh$.runtime = __webpack_require__(/*! ./node_modules/react-refresh/runtime.js */ "./node_modules/react-refresh/runtime.js");
 
+
+const frameTargetOrigin = document.body.getAttribute("gameOrigin") ? "*" : window.location.origin;
 let pendingPromises = new Map();
 let promiseSequence = 0;
-const resolveGamePromise = (id, result) => pendingPromises.get(id).resolve(result);
-const rejectGamePromise = (id, result) => pendingPromises.get(id).reject(result);
-const sendToGame = async data => {
-  const id = String(promiseSequence++);
-  return await new Promise((resolve, reject) => {
-    pendingPromises.set(id, {
-      resolve,
-      reject
+const resolveGamePromise = (id, result)=>pendingPromises.get(id).resolve(result);
+const rejectGamePromise = (id, result)=>pendingPromises.get(id).reject(result);
+const sendToGame = async (data)=>{
+    const id = String(promiseSequence++);
+    return await new Promise((resolve, reject)=>{
+        pendingPromises.set(id, {
+            resolve,
+            reject
+        });
+        document.getElementById("game").contentWindow.postMessage(Object.assign(data, {
+            id
+        }), frameTargetOrigin);
     });
-    document.getElementById("game").contentWindow.postMessage(Object.assign(data, {
-      id
-    }));
-  });
 };
-const sendInitialState = setup => {
-  return sendToGame({
-    type: "initialState",
-    setup
-  });
+const sendInitialState = (setup)=>{
+    return sendToGame({
+        type: "initialState",
+        setup
+    });
 };
-const processMove = (previousState, move) => {
-  return sendToGame({
-    type: "processMove",
-    previousState,
-    move
-  });
+const processMove = (previousState, move)=>{
+    return sendToGame({
+        type: "processMove",
+        previousState,
+        move
+    });
 };
-const getPlayerState = (state, position) => {
-  return sendToGame({
-    type: "getPlayerState",
-    state,
-    position
-  });
+const getPlayerState = (state, position)=>{
+    return sendToGame({
+        type: "getPlayerState",
+        state,
+        position
+    });
 };
-const reprocessHistory = (setup, moves) => {
-  return sendToGame({
-    type: "reprocessHistory",
-    setup,
-    moves
-  });
+const reprocessHistory = (setup, moves)=>{
+    return sendToGame({
+        type: "reprocessHistory",
+        setup,
+        moves
+    });
 };
 
 const $ReactRefreshModuleId$ = __webpack_require__.$Refresh$.moduleId;
@@ -32354,8 +32222,8 @@ var FocusTrap = function FocusTrap(_ref) {
   var container = _ref.container,
     initialFocusRef = _ref.initialFocusRef;
   var refLastFocus = (0,react__WEBPACK_IMPORTED_MODULE_0__.useRef)();
-  /**
-   * Handle focus lock on the modal
+  /**
+   * Handle focus lock on the modal
    */
 
   (0,react__WEBPACK_IMPORTED_MODULE_0__.useEffect)(function () {
@@ -32406,28 +32274,28 @@ var FocusTrap = function FocusTrap(_ref) {
   return null;
 };
 var modals = [];
-/**
- * Handle the order of the modals.
- * Inspired by the material-ui implementation.
+/**
+ * Handle the order of the modals.
+ * Inspired by the material-ui implementation.
  */
 
 var modalManager = {
-  /**
-   * Register a new modal
+  /**
+   * Register a new modal
    */
   add: function add(newModal) {
     modals.push(newModal);
   },
-  /**
-   * Remove a modal
+  /**
+   * Remove a modal
    */
   remove: function remove(oldModal) {
     modals = modals.filter(function (modal) {
       return modal !== oldModal;
     });
   },
-  /**
-   * When multiple modals are rendered will return true if current modal is the last one
+  /**
+   * When multiple modals are rendered will return true if current modal is the last one
    */
   isTopModal: function isTopModal(modal) {
     return !!modals.length && modals[modals.length - 1] === modal;
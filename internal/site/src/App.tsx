@@ -1,5 +1,11 @@
 import ReconnectingEventSource from "reconnecting-eventsource";
-import React, { useCallback, useEffect, useState, useMemo } from "react";
+import React, {
+  useCallback,
+  useEffect,
+  useState,
+  useMemo,
+  useRef,
+} from "react";
 import History from "./History";
 import { HistoryItem, InitialStateHistoryItem } from "./types";
 import { Modal } from "react-responsive-modal";
@@ -16,24 +22,49 @@ import {
   resolveGamePromise,
   rejectGamePromise,
   reprocessHistory,
+  frameTargetOrigin,
 } from "./game";
 
 const body = document.getElementsByTagName("body")[0];
 const maxPlayers = parseInt(body.getAttribute("maxPlayers")!);
 const minPlayers = parseInt(body.getAttribute("minPlayers")!);
 const defaultPlayers = parseInt(body.getAttribute("defaultPlayers")!);
-const possibleUsers = [
-  { id: "0", name: "Evelyn" },
-  { id: "1", name: "Jennifer" },
-  { id: "2", name: "Kateryna" },
-  { id: "3", name: "Logan" },
-  { id: "4", name: "Liubika" },
-  { id: "5", name: "Aischa" },
-  { id: "6", name: "Leilani" },
-  { id: "7", name: "Avery" },
-  { id: "8", name: "Guadalupe" },
-  { id: "9", name: "Zvezdelina" },
-];
+// set when the ui and game are served from their own origins like they are
+// in production, empty to serve them from this one
+const uiOrigin = body.getAttribute("uiOrigin") ?? "";
+const gameOrigin = body.getAttribute("gameOrigin") ?? "";
+// names this page's event stream, fresh on every load so a duplicated tab
+// doesn't take over the stream of the one it was copied from
+const clientID = crypto.randomUUID();
+// the dev users from the manifest or .bz/devusers.json, host first
+const possibleUsers: {
+  id: string;
+  name: string;
+  avatar: string;
+  color?: string;
+  host?: boolean;
+  bot?: string[];
+}[] = JSON.parse(body.getAttribute("devUsers")!);
+// bots play their own seats from the server, so autoswitch passes them by
+const isBot = (id?: string) => !!possibleUsers.find((u) => u.id === id)?.bot;
+const firstPersonPlaying = (
+  players: Game.Player[],
+  currentPlayers: number[]
+) => {
+  for (const position of currentPlayers) {
+    const player = players.find((p) => p.position === position);
+    if (player && !isBot(player.id)) return player;
+  }
+};
+// a user id in the URL picks the dev user this browser plays as, which is
+// how each device on the network becomes a different player
+const requestedUserID = new URLSearchParams(document.location.search).get(
+  "user"
+);
+const initialUserID = possibleUsers.find((u) => u.id === requestedUserID)
+  ? requestedUserID!
+  : possibleUsers[0].id;
+
 const colors = [
   "#d50000",
   "#00695c",
@@ -57,7 +88,38 @@ const colors = [
   "#600020",
 ];
 
-const avatarURL = (userID: string): string => `/_profile/${userID}.jpg`;
+const historyPageSize = 100;
+
+// a configuration requested in the URL, checked by the dev server
+type DeepLink = {
+  players?: number;
+  randomSeed?: string;
+  settings?: Game.GameSettings;
+  state?: string;
+  userID?: string;
+};
+const deepLink: DeepLink | undefined = body.getAttribute("deepLink")
+  ? JSON.parse(body.getAttribute("deepLink")!)
+  : undefined;
+const deepLinkError = body.getAttribute("deepLinkError");
+// a link with a setup starts the game as soon as the seats are filled
+const deepLinkStarts =
+  deepLink !== undefined &&
+  (deepLink.players !== undefined ||
+    deepLink.randomSeed !== undefined ||
+    deepLink.settings !== undefined);
+if (deepLink?.randomSeed) sessionStorage.setItem("rseed", deepLink.randomSeed);
+
+const avatarURL = (userID: string): string =>
+  possibleUsers.find((u) => u.id === userID)?.avatar ??
+  `/_profile/${encodeURIComponent(userID)}`;
+
+// reloads by resetting src since a cross-origin frame's location can't be
+// touched
+const reloadFrame = (id: "ui" | "game") => {
+  const frame = document.getElementById(id) as HTMLIFrameElement | null;
+  if (frame) frame.src = frame.src;
+};
 
 type BuildError = {
   out: string;
@@ -69,7 +131,16 @@ type SaveState = {
   ctime: number;
 };
 
+type CSPViolation = {
+  directive: string;
+  blockedURI: string;
+  sourceFile?: string;
+  lineNumber?: number;
+  count: number;
+};
+
 type SaveStateData = {
+  gameStateVersion: number;
   randomSeed: string;
   settings: Game.GameSettings;
   players: UI.UserPlayer[];
@@ -81,12 +152,14 @@ type MessageType =
   | Game.InitialStateResultMessage
   | Game.ProcessMoveResultMessage
   | Game.ReprocessHistoryResultMessage
+  | Game.TimingMessage
   | UI.UpdateSettingsMessage
   | UI.UpdatePlayersMessage
   | UI.ReadyMessage
   | UI.MoveMessage
   | UI.KeyMessage
-  | UI.SendDarkMessage;
+  | UI.SendDarkMessage
+  | UI.CrossOriginAccessMessage;
 
 function App() {
   const [initialState, setInitialState] = useState<
@@ -94,7 +167,7 @@ function App() {
   >();
   const [numberOfUsers, setNumberOfUsers] = useState(0);
   const [phase, setPhase] = useState<"new" | "started">("new");
-  const [currentUserID, setCurrentUserID] = useState(possibleUsers[0].id);
+  const [currentUserID, setCurrentUserID] = useState(initialUserID);
   const [currentUserIDRequested, setCurrentUserIDRequested] = useState<
     string | undefined
   >(undefined);
@@ -103,16 +176,31 @@ function App() {
     new Map()
   );
   const [buildError, setBuildError] = useState<BuildError | undefined>();
-  const [settings, setSettings] = useState<Game.GameSettings>({});
+  const [settings, setSettings] = useState<Game.GameSettings>(
+    deepLink?.settings ?? {}
+  );
+  const [deepLinkPending, setDeepLinkPending] = useState(deepLinkStarts);
   const [seatCount, setSeatCount] = useState(0);
   const [history, setHistory] = useState<HistoryItem[]>([]);
+  // the save state earlier history entries are still to be loaded from,
+  // when only the latest were loaded
+  const [historySource, setHistorySource] = useState<string | undefined>(
+    undefined
+  );
+  const loadingHistory = useRef<Promise<HistoryItem[]> | undefined>(undefined);
+  // the state version of a loaded game, undefined when this build made it
+  const [gameStateVersion, setGameStateVersion] = useState<
+    number | undefined
+  >(undefined);
   const [historyPin, setHistoryPin] = useState<number | undefined>(undefined);
   const [helpOpen, setHelpOpen] = useState(false);
+  const [cspViolations, setCSPViolations] = useState<CSPViolation[]>([]);
+  const [cspViolationsOpen, setCSPViolationsOpen] = useState(false);
   const [saveStatesOpen, setSaveStatesOpen] = useState(false);
   const [saveStates, setSaveStates] = useState<SaveState[]>([]);
   const [historyCollapsed, setHistoryCollapsed] = useState(false);
   const [fullScreen, setFullScreen] = useState(false);
-  const [autoSwitch, setAutoSwitch] = useState(true);
+  const [autoSwitch, setAutoSwitch] = useState(requestedUserID === null);
   const [reprocessing, setReprocessing] = useState(false);
   const [darkMode, setDarkMode] = useState(
     localStorage.getItem("dark") === "true"
@@ -153,9 +241,38 @@ function App() {
     setSaveStates((states as { entries: SaveState[] }).entries);
   }, []);
 
+  // entries are posted one after another so a setup is recorded before the
+  // moves that follow it
+  const journalQueue = useRef<Promise<void>>(Promise.resolve());
+  const journal = useCallback((entry: any): Promise<void> => {
+    journalQueue.current = journalQueue.current.then(async () => {
+      try {
+        const response = await fetch("/autosave", {
+          headers: {
+            "Content-type": "application/json",
+          },
+          body: JSON.stringify(entry),
+          method: "POST",
+        });
+        if (!response.ok) {
+          toast.error(
+            `Unable to autosave ${entry.type}: ${
+              (await response.text()).trim() || response.statusText
+            }`
+          );
+        }
+      } catch (e) {
+        console.error("unable to write autosave journal", e);
+      }
+    });
+    return journalQueue.current;
+  }, []);
+
   const getCurrentState = useCallback(
     (history?: HistoryItem[]): Game.GameUpdate => {
-      const historyItem = historyPin ?? (history?.length ?? 0) - 1;
+      const start = history?.[0]?.seq ?? 0;
+      const historyItem =
+        (historyPin ?? start + (history?.length ?? 0) - 1) - start;
       return history && historyItem >= 0
         ? history[historyItem].state
         : initialState!.state;
@@ -176,7 +293,10 @@ function App() {
     ) => {
       (
         document.getElementById("ui") as HTMLIFrameElement
-      )?.contentWindow!.postMessage(JSON.parse(JSON.stringify(data)));
+      )?.contentWindow!.postMessage(
+        JSON.parse(JSON.stringify(data)),
+        frameTargetOrigin
+      );
     },
     []
   );
@@ -222,7 +342,7 @@ function App() {
               id: u.id,
               name: u.name,
               avatar: avatarURL(u.id),
-              color: colors[players.length + i],
+              color: u.color ?? colors[players.length + i],
               position: players.length + i + 1,
               host: players.length + i === 0,
             }))
@@ -233,7 +353,9 @@ function App() {
             ...playerReadiness,
             ...possibleUsers
               .slice(numberOfUsers)
-              .map((p) => [p.id, p.id !== "0"] as [string, boolean]),
+              .map(
+                (p) => [p.id, p.id !== possibleUsers[0].id] as [string, boolean]
+              ),
           ])
         );
         sendToUI({ type: "settingsUpdate", settings, seatCount: n });
@@ -243,9 +365,51 @@ function App() {
   );
 
   useEffect(() => {
-    if (numberOfUsers === 0) setNumberAndSeat(minPlayers);
+    if (numberOfUsers === 0) setNumberAndSeat(deepLink?.players ?? minPlayers);
   }, [numberOfUsers, setNumberAndSeat]);
 
+  // loadEarlierHistory loads the history entries from seq until up to the
+  // first one loaded, or the page before it if until is undefined, and
+  // returns the whole history loaded. Seq -1, the initial state, needs none.
+  const loadEarlierHistory = useCallback(
+    async (until?: number): Promise<HistoryItem[]> => {
+      const start = history[0]?.seq ?? 0;
+      if (
+        !historySource ||
+        start === 0 ||
+        (until !== undefined && (until < 0 || until >= start))
+      ) {
+        return history;
+      }
+      // scrolling asks again while a page loads
+      if (loadingHistory.current && until === undefined) {
+        return loadingHistory.current;
+      }
+      loadingHistory.current = (async () => {
+        const from = Math.max(
+          0,
+          Math.min(until ?? start - historyPageSize, start - historyPageSize)
+        );
+        const page = await fetch(
+          `${historySource}?from=${from}&to=${start - 1}`
+        );
+        const earlier = ((await page.json()) as SaveStateData).history;
+        setHistory((h) => [
+          ...earlier.filter((e) => e.seq < (h[0]?.seq ?? 0)),
+          ...h,
+        ]);
+        if (from === 0) setHistorySource(undefined);
+        return [...earlier, ...history];
+      })();
+      try {
+        return await loadingHistory.current;
+      } finally {
+        loadingHistory.current = undefined;
+      }
+    },
+    [history, historySource]
+  );
+
   const saveCurrentState = useCallback(
     async (
       name: string,
@@ -253,13 +417,15 @@ function App() {
       initialState: InitialStateHistoryItem,
       history: HistoryItem[],
       settings: Game.GameSettings,
-      players: UI.UserPlayer[]
+      players: UI.UserPlayer[],
+      gameStateVersion: number | undefined
     ): Promise<void> => {
       return fetch(`/states/${encodeURIComponent(name)}`, {
         headers: {
           "Content-type": "application/json",
         },
         body: JSON.stringify({
+          gameStateVersion,
           randomSeed,
           initialState,
           history,
@@ -278,16 +444,27 @@ function App() {
       const target = e.target as typeof e.target & {
         name: { value: string };
       };
-      saveCurrentState(
-        getRandomSeed(),
-        target.name.value,
-        initialState!,
-        history,
-        settings,
-        players
+      loadEarlierHistory(0).then((fullHistory) =>
+        saveCurrentState(
+          getRandomSeed(),
+          target.name.value,
+          initialState!,
+          fullHistory,
+          settings,
+          players,
+          gameStateVersion
+        )
       );
     },
-    [saveCurrentState, getRandomSeed, initialState, history, settings, players]
+    [
+      saveCurrentState,
+      getRandomSeed,
+      initialState,
+      loadEarlierHistory,
+      settings,
+      players,
+      gameStateVersion,
+    ]
   );
 
   const bootstrap = useCallback((): string => {
@@ -317,14 +494,14 @@ function App() {
           });
           break;
         case "started":
-          let position = currentPlayer.position;
+          const next = firstPersonPlaying(players, update.game.currentPlayers);
           if (
             autoSwitch &&
-            update.game.currentPlayers[0] !== currentPlayer.position &&
+            next &&
+            next.position !== currentPlayer.position &&
             currentUserIDRequested === undefined
           ) {
-            position = update.game.currentPlayers[0];
-            setCurrentUserID(players.find((p) => p.position === position)!.id!);
+            setCurrentUserID(next.id!);
             return;
           }
           sendToUI({
@@ -361,53 +538,96 @@ function App() {
       settings,
     };
     setInitialState(newInitialState);
+    setGameStateVersion(undefined);
     setPhase("started");
+    journal({
+      type: "setup",
+      randomSeed,
+      settings,
+      players,
+      initialState: newInitialState,
+    });
     await updateUI(initialUpdate);
-  }, [getRandomSeed, players, settings, updateUI]);
+  }, [getRandomSeed, players, settings, updateUI, journal]);
 
   useEffect(() => {
     if (
       phase === "new" &&
       players.length >= minPlayers &&
       players.length === seatCount &&
-      players.every((p) => playerReadiness.get(p.id))
-    )
+      (deepLinkPending || players.every((p) => playerReadiness.get(p.id)))
+    ) {
+      setDeepLinkPending(false);
       start();
-  }, [players, playerReadiness, seatCount, start, phase]);
+    }
+  }, [players, playerReadiness, seatCount, start, phase, deepLinkPending]);
+
+  const copyLink = useCallback(() => {
+    const params = new URLSearchParams();
+    const token = new URLSearchParams(document.location.search).get("token");
+    if (token) params.set("token", token);
+    params.set("players", String(seatCount));
+    params.set("seed", getRandomSeed());
+    params.set("settings", JSON.stringify(settings));
+    params.set("user", currentUserID);
+    navigator.clipboard.writeText(`${window.location.origin}/?${params}`);
+    toast.success("Link to this setup copied");
+  }, [seatCount, getRandomSeed, settings, currentUserID]);
 
   const resetGame = useCallback(() => {
     setPhase("new");
     setSettings({});
     setInitialState(undefined);
     setHistory([]);
+    setHistorySource(undefined);
+    setGameStateVersion(undefined);
     setPlayers([]);
     setCurrentUserID(possibleUsers[0].id);
-    (
-      document.getElementById("ui") as HTMLIFrameElement
-    )?.contentWindow?.location.reload();
-    (
-      document.getElementById("game") as HTMLIFrameElement
-    )?.contentWindow?.location.reload();
-  }, []);
+    journal({ type: "end" });
+    reloadFrame("ui");
+    reloadFrame("game");
+  }, [journal]);
+
+  // applySaveState shows state, whose history may be only the latest
+  // entries of the one at source
+  const applySaveState = useCallback(
+    (state: SaveStateData, source?: string) => {
+      const partial = (state.history[0]?.seq ?? 0) > 0;
+      setRandomSeed(state.randomSeed);
+      setInitialState(state.initialState);
+      setHistory(state.history);
+      setHistorySource(partial ? source : undefined);
+      setHistoryPin(undefined);
+      setGameStateVersion(state.gameStateVersion);
+      setSettings(state.settings);
+      setPlayers(state.players);
+      if (partial) {
+        // reprocessing needs every move, so a partly loaded history is shown
+        // as saved until the rest is needed
+        reloadFrame("ui");
+      } else {
+        reloadFrame("game");
+      }
+    },
+    [setRandomSeed]
+  );
 
   useEffect(() => {
-    const evtSource = new ReconnectingEventSource("/events");
+    const evtSource = new ReconnectingEventSource(
+      `/events?client=${encodeURIComponent(clientID)}`
+    );
     evtSource!.onmessage = (m) => {
       const e = JSON.parse(m.data);
       switch (e.type) {
         case "reload":
           switch (e.target) {
             case "ui":
-              (
-                document.getElementById("ui") as HTMLIFrameElement
-              )?.contentWindow?.location.reload();
+              reloadFrame("ui");
               setBuildError(undefined);
               toast.success("UI Reloaded!");
               break;
             case "game":
-              (
-                document.getElementById("game") as HTMLIFrameElement
-              )?.contentWindow?.location.reload();
+              reloadFrame("game");
               setBuildError(undefined);
               toast.success("Game Reloaded!");
               break;
@@ -416,6 +636,60 @@ function App() {
         case "buildError":
           setBuildError({ out: e.out, err: e.err });
           break;
+        case "userOnline":
+          sendToUI({ type: "userOnline", id: e.id, online: e.online });
+          break;
+        case "sessionUpdated":
+          // the automation API changed the session, catch up with it
+          fetch("/autosave")
+            .then((res) => res.json())
+            .then((state: SaveStateData) => {
+              applySaveState(state);
+              setPhase("started");
+              setNumberOfUsers((n) => Math.max(n, state.players.length));
+              setSeatCount(state.players.length);
+            });
+          break;
+        case "invariantViolation":
+          for (const v of e.violations) {
+            const where =
+              e.move === undefined
+                ? "the initial state"
+                : `move ${e.seq + 1} by position ${e.position}`;
+            toast.error(
+              `Invariant ${v.invariant} broken by ${where}: ${v.detail}`
+            );
+          }
+          break;
+        case "stateSizeWarning":
+          for (const w of e.warnings) {
+            const where =
+              e.move === undefined
+                ? "in the initial state"
+                : `after move ${e.seq + 1} by position ${e.position}`;
+            const what =
+              w.budget === "playerView"
+                ? `Position ${w.position}'s view`
+                : w.budget === "history"
+                  ? "The history"
+                  : "The GameUpdate";
+            const largest = w.paths[0] ? `, mostly ${w.paths[0].path}` : "";
+            toast(`${what} is over its size budget ${where}${largest}`, {
+              icon: "📦",
+            });
+          }
+          break;
+        case "protocolViolation":
+          for (const v of e.violations) {
+            toast.error(`UI sent an invalid ${v.type} message: ${v.problem}`);
+          }
+          break;
+        case "cspViolation":
+          setCSPViolations((v) => [...v, e.violation]);
+          toast.error(
+            `CSP blocked ${e.violation.blockedURI} by ${e.violation.directive}`
+          );
+          break;
         case "ping":
           break;
       }
@@ -426,7 +700,7 @@ function App() {
     };
 
     return () => evtSource.close();
-  }, []);
+  }, [sendToUI, applySaveState]);
 
   const users = useMemo((): UI.User[] => {
     const users = possibleUsers.slice(0, numberOfUsers).map((u) =>
@@ -468,12 +742,8 @@ function App() {
           setFullScreen((s) => !s);
           return true;
         case "KeyR":
-          (
-            document.getElementById("ui") as HTMLIFrameElement
-          )?.contentWindow?.location.reload();
-          (
-            document.getElementById("game") as HTMLIFrameElement
-          )?.contentWindow?.location.reload();
+          reloadFrame("ui");
+          reloadFrame("game");
           return true;
         case "Digit1":
         case "Digit2":
@@ -497,8 +767,34 @@ function App() {
 
   useEffect(() => {
     const listener = async (e: MessageEvent<MessageType>) => {
+      const frames = ["ui", "game"].map(
+        (id) =>
+          (document.getElementById(id) as HTMLIFrameElement | null)
+            ?.contentWindow
+      );
+      if (!e.source || !frames.includes(e.source as Window)) return;
       const evt = JSON.parse(JSON.stringify(e.data)) as MessageType;
 
+      if (e.source === frames[0]) {
+        const latest =
+          history.length === 0
+            ? initialState?.state
+            : history[history.length - 1].state;
+        fetch("/_protocol", {
+          headers: {
+            "Content-type": "application/json",
+          },
+          body: JSON.stringify({
+            message: evt,
+            phase: phase === "new" ? "new" : latest?.game.phase ?? "started",
+            userID: currentUserID,
+            host,
+            seatCount,
+          }),
+          method: "POST",
+        }).catch((err) => console.error("unable to check message", err));
+      }
+
       switch (evt.type) {
         case "initialStateResult":
           resolveGamePromise(evt.id, evt.state);
@@ -517,6 +813,16 @@ function App() {
             updates: evt.updates,
           });
           break;
+        case "timing":
+          if (e.source !== frames[1]) return;
+          fetch("/_timings", {
+            headers: {
+              "Content-type": "application/json",
+            },
+            body: JSON.stringify(evt),
+            method: "POST",
+          }).catch((err) => console.error("unable to record timing", err));
+          break;
         case "updateSettings":
           if (!host) return;
           setSettings(evt.settings);
@@ -534,16 +840,15 @@ function App() {
               position: currentPlayer.position,
               data: evt.data,
             });
-            const newHistory = [
-              ...history,
-              {
-                position: currentPlayer.position,
-                seq: history.length,
-                state: moveUpdate,
-                data: evt.data,
-              },
-            ];
+            const newHistoryItem = {
+              position: currentPlayer.position,
+              seq: (history[history.length - 1]?.seq ?? -1) + 1,
+              state: moveUpdate,
+              data: evt.data,
+            };
+            const newHistory = [...history, newHistoryItem];
             setHistory(newHistory);
+            journal({ type: "move", move: newHistoryItem });
             setHistoryPin(undefined);
             sendToUI({
               type: "messageProcessed",
@@ -551,18 +856,16 @@ function App() {
               error: undefined,
             });
             setCurrentUserIDRequested(undefined);
+            const next =
+              moveUpdate.game.phase === "started"
+                ? firstPersonPlaying(players, moveUpdate.game.currentPlayers)
+                : undefined;
             if (
-              moveUpdate.game.phase === "started" &&
               autoSwitch &&
-              moveUpdate.game.currentPlayers[0] !== currentPlayer.position
+              next &&
+              next.position !== currentPlayer.position
             ) {
-              setCurrentUserID(
-                players.find(
-                  (p) =>
-                    p.position ===
-                    (moveUpdate.game as Game.GameStartedState).currentPlayers[0]
-                )!.id!
-              );
+              setCurrentUserID(next.id!);
               return;
             }
             await updateUI(moveUpdate);
@@ -640,6 +943,16 @@ function App() {
             dark: document.documentElement.classList.contains("dark"),
           });
           break;
+        case "crossOriginAccess":
+          toast.error(
+            `UI tried to access ${evt.kind}, which is blocked in production`
+          );
+          fetch("/_crossorigin", {
+            method: "POST",
+            headers: { "Content-Type": "application/json" },
+            body: JSON.stringify({ kind: evt.kind, detail: evt.detail }),
+          });
+          break;
       }
     };
 
@@ -664,6 +977,7 @@ function App() {
     users,
     playerReadiness,
     setNumberAndSeat,
+    journal,
   ]);
 
   useEffect(() => {
@@ -693,20 +1007,63 @@ function App() {
 
   const loadState = useCallback(
     async (name: string) => {
-      const response = await fetch(`/states/${encodeURIComponent(name)}`);
-      const state = (await response.json()) as SaveStateData;
-      setRandomSeed(state.randomSeed);
-      setInitialState(state.initialState);
-      setHistory(state.history);
-      setSettings(state.settings);
-      setPlayers(state.players);
-      (
-        document.getElementById("game") as HTMLIFrameElement
-      )?.contentWindow?.location.reload();
+      const stateURL = `/states/${encodeURIComponent(name)}`;
+      const response = await fetch(`${stateURL}?meta=true`);
+      const state = (await response.json()) as SaveStateData & {
+        historyLength: number;
+      };
+      // the latest page is enough to show the game, earlier ones are loaded
+      // as the history panel scrolls back
+      const from = Math.max(0, state.historyLength - historyPageSize);
+      const page = await fetch(`${stateURL}?from=${from}`);
+      const history = ((await page.json()) as SaveStateData).history;
+      applySaveState({ ...state, history }, stateURL);
+      // the server adopts the whole save, not just the page shown
+      journal({ type: "setup", saveState: name });
     },
-    [setRandomSeed]
+    [applySaveState, journal]
   );
 
+  useEffect(() => {
+    if (deepLinkError) toast.error(`Ignoring link: ${deepLinkError}`);
+    if (deepLink?.state) loadState(deepLink.state);
+  }, [loadState]);
+
+  useEffect(() => {
+    (async () => {
+      if (deepLinkStarts || deepLink?.state) return;
+      const response = await fetch("/autosave");
+      if (response.status !== 200) return;
+      const state = (await response.json()) as SaveStateData;
+      toast(
+        (t) => (
+          <span>
+            Restore previous session ({state.history.length} moves)?{" "}
+            <button
+              onClick={() => {
+                applySaveState(state);
+                journal({
+                  type: "setup",
+                  randomSeed: state.randomSeed,
+                  settings: state.settings,
+                  players: state.players,
+                  initialState: state.initialState,
+                  history: state.history,
+                  gameStateVersion: state.gameStateVersion,
+                });
+                toast.dismiss(t.id);
+              }}
+            >
+              Restore
+            </button>{" "}
+            <button onClick={() => toast.dismiss(t.id)}>Dismiss</button>
+          </span>
+        ),
+        { duration: 15000 }
+      );
+    })();
+  }, [applySaveState, journal]);
+
   const deleteState = useCallback(
     async (name: string) => {
       await fetch(`/states/${encodeURIComponent(name)}`, { method: "DELETE" });
@@ -716,20 +1073,28 @@ function App() {
   );
 
   const viewHistory = useCallback(
-    (idx: number) => {
-      setHistoryPin(() => (idx === history.length - 1 ? undefined : idx));
-      updateUI(idx === -1 ? initialState!.state : history[idx].state);
+    async (idx: number) => {
+      const loaded = await loadEarlierHistory(idx);
+      const start = loaded[0]?.seq ?? 0;
+      setHistoryPin(() =>
+        idx === start + loaded.length - 1 ? undefined : idx
+      );
+      updateUI(idx === -1 ? initialState!.state : loaded[idx - start].state);
     },
-    [updateUI, initialState, history]
+    [updateUI, initialState, loadEarlierHistory]
   );
 
   const revertTo = useCallback(
-    (idx: number) => {
-      setHistory(history.slice(0, idx + 1));
+    async (idx: number) => {
+      const loaded = await loadEarlierHistory(idx);
+      const start = loaded[0]?.seq ?? 0;
+      setHistory(loaded.filter((h) => h.seq <= idx));
+      if (idx < start) setHistorySource(undefined);
       setHistoryPin(undefined);
-      updateUI(idx === -1 ? initialState!.state : history[idx].state);
+      journal({ type: "revert", seq: idx });
+      updateUI(idx === -1 ? initialState!.state : loaded[idx - start].state);
     },
-    [updateUI, initialState, history]
+    [updateUI, initialState, loadEarlierHistory, journal]
   );
 
   const resetRandomSeed = useCallback(() => {
@@ -741,11 +1106,12 @@ function App() {
     if (!initialState) {
       return;
     }
+    const fullHistory = await loadEarlierHistory(0);
     setReprocessing(true);
     const randomSeed = getRandomSeed();
     const reprocessResult = await reprocessHistory(
       { randomSeed, players, settings },
-      history.map((h) => ({ position: h.position, data: h.data }))
+      fullHistory.map((h) => ({ position: h.position, data: h.data }))
     );
 
     if (reprocessResult.error) {
@@ -755,16 +1121,37 @@ function App() {
     const newHistory = reprocessResult.updates.map((update, i) => ({
       seq: i,
       state: update,
-      data: history[i].data,
-      position: history[i].position,
+      data: fullHistory[i].data,
+      position: fullHistory[i].position,
     }));
 
     setReprocessing(false);
+    const newInitialState = {
+      ...initialState,
+      state: reprocessResult.initialState,
+    };
     setRandomSeed(randomSeed);
-    setInitialState({ ...initialState, state: reprocessResult.initialState });
+    setInitialState(newInitialState);
     setHistory(newHistory);
+    setGameStateVersion(undefined);
+    journal({
+      type: "setup",
+      randomSeed,
+      settings,
+      players,
+      initialState: newInitialState,
+      history: newHistory,
+    });
     setReprocessing(false);
-  }, [getRandomSeed, history, initialState, players, setRandomSeed, settings]);
+  }, [
+    getRandomSeed,
+    loadEarlierHistory,
+    initialState,
+    players,
+    setRandomSeed,
+    settings,
+    journal,
+  ]);
 
   return (
     <>
@@ -795,6 +1182,37 @@ function App() {
           )}
         </Modal>
 
+        <Modal
+          open={cspViolationsOpen}
+          onClose={() => setCSPViolationsOpen(false)}
+          center
+        >
+          <h2>Content Security Policy violations</h2>
+          <button
+            onClick={async () => {
+              const res = await fetch("/_csp-report");
+              setCSPViolations((await res.json()).violations);
+            }}
+          >
+            Refresh counts
+          </button>
+          <dl>
+            {cspViolations.map((v) => (
+              <React.Fragment
+                key={`${v.directive} ${v.blockedURI} ${v.sourceFile}:${v.lineNumber}`}
+              >
+                <dt>
+                  <code>{v.blockedURI}</code> blocked by{" "}
+                  <code>{v.directive}</code>
+                </dt>
+                <dd>
+                  {v.sourceFile && `${v.sourceFile}:${v.lineNumber} `}
+                  {v.count > 1 && `(${v.count} times)`}
+                </dd>
+              </React.Fragment>
+            ))}
+          </dl>
+        </Modal>
         <Modal open={helpOpen} onClose={() => setHelpOpen(false)} center>
           <h2>Help</h2>
           <dl>
@@ -885,6 +1303,7 @@ function App() {
                           : "2px black solid",
                     }}
                   >
+                    {isBot(u.id) && "🤖 "}
                     {u.name}
                   </button>
                 ))}
@@ -903,6 +1322,23 @@ function App() {
             >
               Reset seed
             </button>
+            <button
+              style={{ marginLeft: "0.5em" }}
+              title="Copy a link that starts a game with these players, seed and settings"
+              onClick={() => copyLink()}
+            >
+              Copy link
+            </button>
+            {cspViolations.length > 0 && (
+              <button
+                style={{ marginLeft: "0.5em" }}
+                className="button-link"
+                title="Content Security Policy violations"
+                onClick={() => setCSPViolationsOpen(true)}
+              >
+                🛡 {cspViolations.length}
+              </button>
+            )}
             <button
               style={{ marginLeft: "0.5em", fontSize: "20pt" }}
               className="button-link"
@@ -922,7 +1358,9 @@ function App() {
               style={{ border: 1, flexGrow: 4 }}
               id="ui"
               title="ui"
-              src={`/ui.html?bootstrap=${encodeURIComponent(bootstrap())}`}
+              src={`${uiOrigin}/ui.html?bootstrap=${encodeURIComponent(
+                bootstrap()
+              )}`}
             />
           )}
           <iframe
@@ -930,7 +1368,7 @@ function App() {
             style={{ height: "0", width: "0" }}
             id="game"
             title="game"
-            src="/game.html"
+            src={`${gameOrigin}/game.html`}
           ></iframe>
         </div>
         <div id="history" className={historyCollapsed ? "collapsed" : ""}>
@@ -963,6 +1401,9 @@ function App() {
             revertTo={(n) => revertTo(n)}
             initialState={initialState}
             items={history}
+            loadEarlier={
+              historySource ? () => loadEarlierHistory() : undefined
+            }
             collapsed={historyCollapsed}
             darkMode={darkMode}
           />
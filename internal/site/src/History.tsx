@@ -1,4 +1,10 @@
-import { useCallback, useRef, useEffect } from "react";
+import {
+  useCallback,
+  useRef,
+  useEffect,
+  useLayoutEffect,
+  UIEvent,
+} from "react";
 import { HistoryItem, InitialStateHistoryItem } from "./types";
 import * as Game from "./types/game";
 import JsonView from "@uiw/react-json-view";
@@ -11,6 +17,8 @@ type HistoryProps = {
   initialState?: InitialStateHistoryItem;
   revertTo: (n: number) => void;
   view: (n: number) => void;
+  // loadEarlier is set while there are earlier items still to be loaded
+  loadEarlier?: () => void;
   players: Game.Player[];
   collapsed: boolean;
   darkMode: boolean;
@@ -21,6 +29,7 @@ export default function History({
   initialState,
   revertTo,
   view,
+  loadEarlier,
   players,
   collapsed,
   darkMode,
@@ -38,13 +47,44 @@ export default function History({
     [players]
   );
 
+  // earlier items being loaded don't move the list to the end
+  const lastSeq = items[items.length - 1]?.seq;
   useEffect(() => {
     if (!collapsed)
       historyEndRef.current?.scrollIntoView({ behavior: "smooth" });
-  }, [items, collapsed]);
+  }, [lastSeq, collapsed]);
+
+  // prepended items keep the ones on screen in place, so the list doesn't
+  // jump and its top isn't reached again before the user scrolls up
+  const listRef = useRef<HTMLDivElement>(null);
+  const scrollHeight = useRef(0);
+  const firstSeq = items[0]?.seq;
+  useLayoutEffect(() => {
+    const list = listRef.current;
+    if (list && scrollHeight.current)
+      list.scrollTop += list.scrollHeight - scrollHeight.current;
+  }, [firstSeq]);
+  useLayoutEffect(() => {
+    scrollHeight.current = listRef.current?.scrollHeight ?? 0;
+  });
+
+  const scrollTop = useRef(0);
+  const onScroll = useCallback(
+    (e: UIEvent<HTMLDivElement>) => {
+      const top = e.currentTarget.scrollTop;
+      if (loadEarlier && top < scrollTop.current && top < 100) loadEarlier();
+      scrollTop.current = top;
+    },
+    [loadEarlier]
+  );
 
   return (
-    <div className="history-list" style={{ overflowY: "scroll" }}>
+    <div
+      className="history-list"
+      ref={listRef}
+      style={{ overflowY: "scroll" }}
+      onScroll={onScroll}
+    >
       {initialState && !collapsed && (
         <>
           Initial state
@@ -74,6 +114,11 @@ export default function History({
           -
         </button>
       )}
+      {loadEarlier && !collapsed && (
+        <div>
+          <button onClick={() => loadEarlier()}>Load earlier moves</button>
+        </div>
+      )}
       {items.map((item, i) =>
         collapsed ? (
           <button
@@ -4,6 +4,12 @@ type pendingPromise = {
   resolve: (d: any) => void;
   reject: (e: Error) => void;
 };
+// frames served from their own origin are sandboxed into an opaque origin,
+// which can only be targeted with "*"
+export const frameTargetOrigin = document.body.getAttribute("gameOrigin")
+  ? "*"
+  : window.location.origin;
+
 let pendingPromises = new Map<string, pendingPromise>();
 let promiseSequence = 0;
 
@@ -18,7 +24,10 @@ const sendToGame = async <T>(data: any) => {
     pendingPromises.set(id, { resolve, reject });
     (
       document.getElementById("game") as HTMLIFrameElement
-    ).contentWindow!.postMessage(Object.assign(data, { id }));
+    ).contentWindow!.postMessage(
+      Object.assign(data, { id }),
+      frameTargetOrigin
+    );
   });
 };
 
@@ -84,6 +84,14 @@ export type GetPlayerStateMessage = {
   state: InternalPlayerState;
 };
 
+// how long a call into the game took, sent after its result
+export type TimingMessage = {
+  type: "timing";
+  method: string;
+  duration: number;
+  size: number;
+};
+
 export type ReprocessHistoryResult = {
   initialState: GameUpdate;
   updates: GameUpdate[];
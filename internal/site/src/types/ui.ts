@@ -136,3 +136,11 @@ export type UserOnlineEvent = {
   id: string;
   online: boolean;
 };
+
+// sent by the dev tools' ui.html when the UI tries something the
+// production sandbox blocks
+export type CrossOriginAccessMessage = {
+  type: "crossOriginAccess";
+  kind: string;
+  detail: string;
+};
@@ -0,0 +1,236 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Snapshot is what each position sees at every step of a game: the initial
+// state, then the state after each move.
+type Snapshot struct {
+	Source string          `json:"source"`
+	Steps  []*SnapshotStep `json:"steps"`
+}
+
+type SnapshotStep struct {
+	// Move is the move that led to this step, nil for the initial state.
+	Move *Move `json:"move,omitempty"`
+	// Players is each position's view, GameUpdate.players[n].state.
+	Players map[string]json.RawMessage `json:"players"`
+}
+
+// Replay plays moves from setup and returns the initial GameUpdate and one
+// after each move.
+func Replay(runner *Runner, setup *SetupState, moves []*Move) ([]json.RawMessage, error) {
+	update, err := runner.InitialState(setup)
+	if err != nil {
+		return nil, fmt.Errorf("initialState: %w", err)
+	}
+	if err := runner.CheckInvariants(update); err != nil {
+		return nil, fmt.Errorf("initialState: %w", err)
+	}
+	updates := []json.RawMessage{update}
+	for i, m := range moves {
+		var previous struct {
+			Game json.RawMessage `json:"game"`
+		}
+		if err := json.Unmarshal(update, &previous); err != nil {
+			return nil, err
+		}
+		update, err = runner.ProcessMove(previous.Game, m)
+		if err == nil {
+			err = runner.CheckInvariants(update)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("move %d by position %d: %w", i+1, m.Position, err)
+		}
+		updates = append(updates, update)
+	}
+	return updates, nil
+}
+
+// NewSnapshot records the player views in updates, the initial GameUpdate
+// then one after each of moves.
+func NewSnapshot(source string, moves []*Move, updates []json.RawMessage) (*Snapshot, error) {
+	snapshot := &Snapshot{Source: source, Steps: []*SnapshotStep{}}
+	for i, u := range updates {
+		views, err := PlayerViews(u)
+		if err != nil {
+			return nil, err
+		}
+		step := &SnapshotStep{Players: views}
+		if i > 0 {
+			step.Move = moves[i-1]
+		}
+		snapshot.Steps = append(snapshot.Steps, step)
+	}
+	return snapshot, nil
+}
+
+// SnapshotScenario replays a scenario's moves, leaving out those it
+// expects the game to reject.
+func SnapshotScenario(runner *Runner, manifest *ManifestV1, devUsers []*DevUser, file string) (*Snapshot, error) {
+	scenario, err := LoadScenario(file)
+	if err != nil {
+		return nil, err
+	}
+	setup, err := scenario.Setup(manifest, devUsers)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	moves := []*Move{}
+	for _, m := range scenario.Moves {
+		if m.Error == "" {
+			moves = append(moves, &Move{Position: m.Position, Data: m.Data})
+		}
+	}
+	updates, err := Replay(runner, setup, moves)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return NewSnapshot(filepath.Base(file), moves, updates)
+}
+
+// SnapshotSaveState replays the moves in a save state from its setup.
+func SnapshotSaveState(runner *Runner, file string) (*Snapshot, error) {
+	saveState, err := ReadSaveStateFile(file)
+	if err != nil {
+		return nil, err
+	}
+	moves := []*Move{}
+	for _, h := range saveState.History {
+		moves = append(moves, &Move{Position: h.Position, Data: h.Data})
+	}
+	setup := &SetupState{RandomSeed: saveState.RandomSeed, Players: saveState.Players, Settings: saveState.Settings}
+	updates, err := Replay(runner, setup, moves)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return NewSnapshot(filepath.Base(file), moves, updates)
+}
+
+// Marshal encodes the snapshot with sorted keys and indentation so golden
+// files diff cleanly in version control.
+func (s *Snapshot) Marshal() ([]byte, error) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	v, err := decodeJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(out, '\n'), nil
+}
+
+func decodeJSON(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// DiffSnapshots lists every difference between two encoded snapshots as a
+// path and the expected and actual values.
+func DiffSnapshots(golden, current []byte) ([]string, error) {
+	want, err := decodeJSON(golden)
+	if err != nil {
+		return nil, fmt.Errorf("reading snapshot: %w", err)
+	}
+	got, err := decodeJSON(current)
+	if err != nil {
+		return nil, err
+	}
+	return DiffJSON("", want, got), nil
+}
+
+// DiffJSON compares two decoded JSON values, describing each difference at
+// its gjson style path.
+func DiffJSON(path string, want, got interface{}) []string {
+	join := func(key string) string {
+		if path == "" {
+			return key
+		}
+		return path + "." + key
+	}
+	switch w := want.(type) {
+	case map[string]interface{}:
+		g, ok := got.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := []string{}
+		for k := range w {
+			keys = append(keys, k)
+		}
+		for k := range g {
+			if _, ok := w[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		diffs := []string{}
+		for _, k := range keys {
+			wv, inWant := w[k]
+			gv, inGot := g[k]
+			switch {
+			case !inGot:
+				diffs = append(diffs, fmt.Sprintf("%s: removed, was %s", join(k), compactJSON(wv)))
+			case !inWant:
+				diffs = append(diffs, fmt.Sprintf("%s: added %s", join(k), compactJSON(gv)))
+			default:
+				diffs = append(diffs, DiffJSON(join(k), wv, gv)...)
+			}
+		}
+		return diffs
+	case []interface{}:
+		g, ok := got.([]interface{})
+		if !ok {
+			break
+		}
+		diffs := []string{}
+		for i := 0; i < max(len(w), len(g)); i++ {
+			switch {
+			case i >= len(g):
+				diffs = append(diffs, fmt.Sprintf("%s: removed, was %s", join(strconv.Itoa(i)), compactJSON(w[i])))
+			case i >= len(w):
+				diffs = append(diffs, fmt.Sprintf("%s: added %s", join(strconv.Itoa(i)), compactJSON(g[i])))
+			default:
+				diffs = append(diffs, DiffJSON(join(strconv.Itoa(i)), w[i], g[i])...)
+			}
+		}
+		return diffs
+	}
+	if reflect.DeepEqual(want, got) {
+		return nil
+	}
+	if path == "" {
+		path = "(root)"
+	}
+	return []string{fmt.Sprintf("%s: %s → %s", path, compactJSON(want), compactJSON(got))}
+}
+
+func compactJSON(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	s := strings.TrimSpace(string(b))
+	if runes := []rune(s); len(runes) > 80 {
+		s = string(runes[:77]) + "..."
+	}
+	return s
+}
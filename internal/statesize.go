@@ -0,0 +1,330 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gookit/color"
+	"github.com/tidwall/gjson"
+)
+
+// largestPathsShown is how many sub-paths a state size warning names.
+const largestPathsShown = 5
+
+// StateBudgets are the largest the serialized JSON of a game's state may
+// be. A budget of 0 is not checked.
+type StateBudgets struct {
+	// Update is the budget for each GameUpdate, with every player's view.
+	Update ByteSize `json:"update,omitempty"`
+	// PlayerView is the budget for each PlayerState.state.
+	PlayerView ByteSize `json:"playerView,omitempty"`
+	// History is the budget for the initial state and every move's
+	// history item together, as the platform stores them.
+	History ByteSize `json:"history,omitempty"`
+}
+
+// ByteSize is a number of bytes, written in JSON as a number or a string
+// like "256KB" or "1.5MB".
+type ByteSize int
+
+var byteSizePattern = regexp.MustCompile(`^([0-9.]+)\s*(B|KB|MB)?$`)
+
+func (b *ByteSize) UnmarshalJSON(data []byte) error {
+	var n int
+	if err := json.Unmarshal(data, &n); err == nil {
+		*b = ByteSize(n)
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("size must be a number of bytes or a string like \"256KB\"")
+	}
+	m := byteSizePattern.FindStringSubmatch(strings.ToUpper(strings.TrimSpace(s)))
+	if m == nil {
+		return fmt.Errorf("size %q is not like \"256KB\"", s)
+	}
+	f, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return fmt.Errorf("size %q: %w", s, err)
+	}
+	switch m[2] {
+	case "KB":
+		f *= 1024
+	case "MB":
+		f *= 1024 * 1024
+	}
+	*b = ByteSize(f)
+	return nil
+}
+
+// PathSize is the serialized size of the value at a gjson path.
+type PathSize struct {
+	Path string `json:"path"`
+	Size int    `json:"size"`
+}
+
+// LargestPaths finds what makes doc large: the gjson paths of at most n
+// values that are each over a tenth of doc's size, largest first. It goes
+// as deep as the size does, so a big array is named rather than its
+// elements, and a small object holding a big string names the string.
+// An object or array is also named alongside its large values when the
+// rest of it is over a tenth of doc too.
+func LargestPaths(doc json.RawMessage, n int) []*PathSize {
+	total := len(doc)
+	paths := []*PathSize{}
+	var walk func(path string, v gjson.Result)
+	walk = func(path string, v gjson.Result) {
+		rest := len(v.Raw)
+		i := 0
+		v.ForEach(func(key, value gjson.Result) bool {
+			if len(value.Raw)*10 >= total {
+				childPath := strconv.Itoa(i)
+				if v.IsObject() {
+					childPath = escapePathKey(key.String())
+				}
+				if path != "" {
+					childPath = path + "." + childPath
+				}
+				walk(childPath, value)
+				rest -= len(value.Raw)
+			}
+			i++
+			return true
+		})
+		if path != "" && (rest == len(v.Raw) || rest*10 >= total) {
+			paths = append(paths, &PathSize{Path: path, Size: len(v.Raw)})
+		}
+	}
+	if root := gjson.ParseBytes(doc); root.IsObject() || root.IsArray() {
+		walk("", root)
+	}
+	sort.SliceStable(paths, func(i, j int) bool { return paths[i].Size > paths[j].Size })
+	if len(paths) > n {
+		paths = paths[:n]
+	}
+	return paths
+}
+
+// escapePathKey escapes the characters gjson gives meaning to in a key.
+func escapePathKey(key string) string {
+	var b strings.Builder
+	for _, c := range key {
+		if strings.ContainsRune(`\.*?|#@!=<>%`, c) {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// StateSizeWarning is a budget the game's state is over.
+type StateSizeWarning struct {
+	// Budget is "update", "playerView" or "history".
+	Budget string `json:"budget"`
+	// Position is whose view is over budget, for "playerView".
+	Position int `json:"position,omitempty"`
+	Size     int `json:"size"`
+	Limit    int `json:"limit"`
+	// Paths are the largest sub-paths of the update or view. For "history"
+	// they are those of the latest update, which each move stores again.
+	Paths []*PathSize `json:"paths"`
+}
+
+func (w *StateSizeWarning) String() string {
+	what := "The GameUpdate"
+	switch w.Budget {
+	case "playerView":
+		what = fmt.Sprintf("Position %d's view", w.Position)
+	case "history":
+		what = "The history"
+	}
+	return fmt.Sprintf("%s is %s, over its %s budget", what, FormatBytes(w.Size), FormatBytes(w.Limit))
+}
+
+// CheckStateSize lists the budgets that update, and a history of
+// historySize bytes that ends in it, are over.
+func CheckStateSize(budgets *StateBudgets, update json.RawMessage, historySize int) ([]*StateSizeWarning, error) {
+	warnings := []*StateSizeWarning{}
+	if budgets == nil {
+		return warnings, nil
+	}
+	if budgets.Update != 0 && len(update) > int(budgets.Update) {
+		warnings = append(warnings, &StateSizeWarning{Budget: "update", Size: len(update), Limit: int(budgets.Update), Paths: LargestPaths(update, largestPathsShown)})
+	}
+	if budgets.PlayerView != 0 {
+		views, err := PlayerViews(update)
+		if err != nil {
+			return nil, err
+		}
+		positions := []int{}
+		for p := range views {
+			position, err := strconv.Atoi(p)
+			if err != nil {
+				return nil, err
+			}
+			positions = append(positions, position)
+		}
+		sort.Ints(positions)
+		for _, position := range positions {
+			view := views[strconv.Itoa(position)]
+			if len(view) > int(budgets.PlayerView) {
+				warnings = append(warnings, &StateSizeWarning{Budget: "playerView", Position: position, Size: len(view), Limit: int(budgets.PlayerView), Paths: LargestPaths(view, largestPathsShown)})
+			}
+		}
+	}
+	if budgets.History != 0 && historySize > int(budgets.History) {
+		warnings = append(warnings, &StateSizeWarning{Budget: "history", Size: historySize, Limit: int(budgets.History), Paths: LargestPaths(update, largestPathsShown)})
+	}
+	return warnings, nil
+}
+
+// stateSizes follows the size of the current session's history as it is
+// journaled, and which budgets it is over so each is only warned about
+// when it is first exceeded.
+type stateSizes struct {
+	// history is the size of the initial state, then of each move, or nil
+	// until the session is read from the journal.
+	history []int
+	over    map[string]bool
+	lock    sync.Mutex
+}
+
+// record adds entry to the history, returning its size.
+func (z *stateSizes) record(entry *JournalEntry, journal *Journal) (int, error) {
+	z.lock.Lock()
+	defer z.lock.Unlock()
+	switch {
+	case entry.Type == "setup":
+		z.history = []int{}
+		z.over = map[string]bool{}
+		if err := z.add(entry.InitialState); err != nil {
+			return 0, err
+		}
+		for _, h := range entry.History {
+			if err := z.add(h); err != nil {
+				return 0, err
+			}
+		}
+	case z.history == nil:
+		// the session began before the server started, and the journal
+		// already includes entry
+		saveState, err := journal.Latest()
+		if err != nil {
+			return 0, err
+		}
+		z.history = []int{}
+		z.over = map[string]bool{}
+		if err := z.add(saveState.InitialState); err != nil {
+			return 0, err
+		}
+		for _, h := range saveState.History {
+			if err := z.add(h); err != nil {
+				return 0, err
+			}
+		}
+	case entry.Type == "move":
+		z.history = z.history[:min(entry.Move.Seq+1, len(z.history))]
+		if err := z.add(entry.Move); err != nil {
+			return 0, err
+		}
+	case entry.Type == "revert":
+		z.history = z.history[:min(max(entry.Seq+2, 1), len(z.history))]
+	}
+	total := 0
+	for _, n := range z.history {
+		total += n
+	}
+	return total, nil
+}
+
+func (z *stateSizes) add(item interface{}) error {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	z.history = append(z.history, len(data))
+	return nil
+}
+
+// exceeded keeps the warnings for budgets that were not already exceeded,
+// and forgets the budgets no longer exceeded.
+func (z *stateSizes) exceeded(warnings []*StateSizeWarning) []*StateSizeWarning {
+	z.lock.Lock()
+	defer z.lock.Unlock()
+	over := map[string]bool{}
+	fresh := []*StateSizeWarning{}
+	for _, w := range warnings {
+		key := w.Budget + ":" + strconv.Itoa(w.Position)
+		over[key] = true
+		if !z.over[key] {
+			fresh = append(fresh, w)
+		}
+	}
+	z.over = over
+	return fresh
+}
+
+// checkStateSize measures the game's state after it reaches the dev server,
+// reporting budgets in the manifest it grows over in the shell and to the
+// dev UI.
+func (s *Server) checkStateSize(entry *JournalEntry) {
+	if s.manifest.StateBudgets == nil {
+		return
+	}
+	historySize, err := s.stateSizes.record(entry, s.journal)
+	if err != nil {
+		fmt.Printf("error: %#v\n", err)
+		return
+	}
+	event := &stateSizeWarningEvent{Type: "stateSizeWarning"}
+	var update json.RawMessage
+	switch {
+	case entry.Move != nil:
+		update = entry.Move.State
+		event.Move = entry.Move.Data
+		event.Position = entry.Move.Position
+		event.Seq = entry.Move.Seq
+	case entry.InitialState != nil:
+		update = entry.InitialState.State
+	default:
+		return
+	}
+	if len(update) == 0 {
+		return
+	}
+	warnings, err := CheckStateSize(s.manifest.StateBudgets, update, historySize)
+	if err != nil {
+		fmt.Printf("error: %#v\n", err)
+		return
+	}
+	event.Warnings = s.stateSizes.exceeded(warnings)
+	if len(event.Warnings) == 0 {
+		return
+	}
+	where := "in the initial state"
+	if event.Move != nil {
+		where = fmt.Sprintf("after move %d by position %d", event.Seq+1, event.Position)
+	}
+	for _, w := range event.Warnings {
+		color.Printf("<yellow>📦 %s %s</>\n", w, where)
+		for _, p := range w.Paths {
+			color.Printf("   %8s <bold>%s</>\n", FormatBytes(p.Size), p.Path)
+		}
+	}
+	s.broadcast(event)
+}
+
+type stateSizeWarningEvent struct {
+	Type     string              `json:"type"`
+	Warnings []*StateSizeWarning `json:"warnings"`
+	// Move is the move that led to the update, absent for the initial
+	// state.
+	Move     json.RawMessage `json:"move,omitempty"`
+	Position int             `json:"position,omitempty"`
+	Seq      int             `json:"seq"`
+}
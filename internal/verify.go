@@ -0,0 +1,367 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// requiredExports are the functions game.html calls.
+var requiredExports = []string{"initialState", "processMove", "reprocessHistory", "getPlayerState"}
+
+// VerifyCheck is one thing checked about the game artifact. It failed if
+// it has problems.
+type VerifyCheck struct {
+	Name     string
+	Problems []string
+	// Skipped says why the check could not be made.
+	Skipped string
+}
+
+func (c *VerifyCheck) Passed() bool {
+	return len(c.Problems) == 0
+}
+
+// VerifyExports checks that the artifact defines game with the functions
+// game.html calls, directly or on game.default. Runner errors, such as the
+// artifact failing to load, are returned as problems too.
+func VerifyExports(runner *Runner) (*GameExports, *VerifyCheck) {
+	check := &VerifyCheck{Name: "exports"}
+	exports, err := runner.Describe()
+	if err != nil {
+		lines := []string{}
+		for _, line := range strings.Split(strings.TrimSpace(err.Error()), "\n") {
+			if strings.HasPrefix(strings.TrimSpace(line), "at ") {
+				// the rest is the headless runner loading it
+				break
+			}
+			lines = append(lines, line)
+		}
+		check.Problems = append(check.Problems, fmt.Sprintf("game.js could not be loaded: %s", strings.TrimSpace(strings.Join(lines, "\n"))))
+		return nil, check
+	}
+	if exports.Global != "object" && exports.Global != "function" {
+		check.Problems = append(check.Problems, fmt.Sprintf("game.js must define a global game, it is %s; bundle it as an IIFE or UMD named game", exports.Global))
+		return exports, check
+	}
+	for _, name := range requiredExports {
+		if !exports.has(name) {
+			where := "game"
+			if exports.ViaDefault {
+				where = "game.default"
+			}
+			check.Problems = append(check.Problems, fmt.Sprintf("%s has no function %s", where, name))
+		}
+	}
+	return exports, check
+}
+
+func (e *GameExports) has(name string) bool {
+	for _, export := range e.Exports {
+		if export == name {
+			return true
+		}
+	}
+	return false
+}
+
+// VerifySetup calls the game's functions starting from setup, and checks
+// what they return against the GameUpdate contract in interface.md. A
+// move is made if the game exports enumerateMoves to find one.
+func VerifySetup(runner *Runner, exports *GameExports, setup *SetupState) ([]*VerifyCheck, error) {
+	initialCheck := &VerifyCheck{Name: "initialState"}
+	playerStateCheck := &VerifyCheck{Name: "getPlayerState"}
+	moveCheck := &VerifyCheck{Name: "processMove"}
+	reprocessCheck := &VerifyCheck{Name: "reprocessHistory"}
+	checks := []*VerifyCheck{initialCheck, playerStateCheck, moveCheck, reprocessCheck}
+	for _, c := range checks {
+		if !exports.has(c.Name) {
+			c.Skipped = "not exported"
+		}
+	}
+	if initialCheck.Skipped != "" {
+		for _, c := range checks[1:] {
+			c.Skipped = "needs initialState"
+		}
+		return checks, nil
+	}
+
+	initial, err := callGame(func() (json.RawMessage, error) { return runner.InitialState(setup) })
+	if err != nil {
+		initialCheck.Problems = append(initialCheck.Problems, err.Error())
+		for _, c := range checks[1:] {
+			c.Skipped = "needs initialState"
+		}
+		return checks, nil
+	}
+	invalid := ValidateGameUpdate(initial, setup)
+	initialCheck.Problems = append(initialCheck.Problems, invalid...)
+	if again, err := callGame(func() (json.RawMessage, error) { return runner.InitialState(setup) }); err != nil {
+		initialCheck.Problems = append(initialCheck.Problems, fmt.Sprintf("called again with the same setup: %s", err))
+	} else if !sameJSON(initial, again) {
+		initialCheck.Problems = append(initialCheck.Problems, "returned a different GameUpdate when called again with the same setup, so reloading would change the game")
+	}
+	var u struct {
+		Game struct {
+			State          json.RawMessage `json:"state"`
+			CurrentPlayers []int           `json:"currentPlayers"`
+		} `json:"game"`
+	}
+	if err := json.Unmarshal(initial, &u); err != nil || len(invalid) != 0 {
+		for _, c := range checks[1:] {
+			if c.Skipped == "" {
+				c.Skipped = "needs a valid initialState"
+			}
+		}
+		return checks, nil
+	}
+
+	if playerStateCheck.Skipped == "" {
+		views, err := PlayerViews(initial)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range setup.Players {
+			position := p.Position
+			view, err := callGame(func() (json.RawMessage, error) { return runner.GetPlayerState(u.Game.State, position) })
+			if err != nil {
+				playerStateCheck.Problems = append(playerStateCheck.Problems, fmt.Sprintf("position %d: %s", position, err))
+				continue
+			}
+			if !sameJSON(view, views[fmt.Sprint(position)]) {
+				playerStateCheck.Problems = append(playerStateCheck.Problems, fmt.Sprintf("position %d: differs from the state for position %d in the initial GameUpdate", position, position))
+			}
+		}
+	}
+
+	moves := []*Move{}
+	var moved json.RawMessage
+	canEnumerate := exports.has("enumerateMoves")
+	switch {
+	case moveCheck.Skipped != "":
+	case !canEnumerate:
+		moveCheck.Skipped = "export enumerateMoves to check a move"
+	case len(u.Game.CurrentPlayers) == 0:
+		moveCheck.Skipped = "no current players to move"
+	default:
+		var previous struct {
+			Game json.RawMessage `json:"game"`
+		}
+		if err := json.Unmarshal(initial, &previous); err != nil {
+			return nil, err
+		}
+		position := u.Game.CurrentPlayers[0]
+		candidates, err := runner.EnumerateMoves(previous.Game, position)
+		if err != nil {
+			moveCheck.Problems = append(moveCheck.Problems, fmt.Sprintf("enumerateMoves for position %d: %s", position, firstLine(err.Error())))
+			break
+		}
+		for _, data := range candidates {
+			move := &Move{Position: position, Data: data}
+			update, err := runner.ProcessMove(previous.Game, move)
+			var gameError *GameError
+			if errors.As(err, &gameError) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			moves = append(moves, move)
+			moved = update
+			break
+		}
+		if moved == nil {
+			moveCheck.Skipped = fmt.Sprintf("none of the %d moves enumerated for position %d were accepted", len(candidates), position)
+			break
+		}
+		for _, problem := range ValidateGameUpdate(moved, setup) {
+			moveCheck.Problems = append(moveCheck.Problems, fmt.Sprintf("after position %d moves %s: %s", position, string(moves[0].Data), problem))
+		}
+	}
+
+	if reprocessCheck.Skipped == "" {
+		result, err := runner.ReprocessHistory(setup, moves)
+		var gameError *GameError
+		switch {
+		case errors.As(err, &gameError):
+			reprocessCheck.Problems = append(reprocessCheck.Problems, firstLine(err.Error()))
+		case err != nil:
+			return nil, err
+		default:
+			reprocessCheck.Problems = append(reprocessCheck.Problems, verifyReprocessed(result, initial, moved, setup)...)
+		}
+	}
+	return checks, nil
+}
+
+// verifyReprocessed checks reprocessHistory returned the same GameUpdates
+// as initialState and processMove, given at most one move.
+func verifyReprocessed(result *ReprocessResponse, initial, moved json.RawMessage, setup *SetupState) []string {
+	problems := []string{}
+	if result.Error != "" {
+		problems = append(problems, fmt.Sprintf("returned error %q", result.Error))
+	}
+	for _, problem := range ValidateGameUpdate(result.InitialState, setup) {
+		problems = append(problems, "initialState: "+problem)
+	}
+	if !sameJSON(result.InitialState, initial) {
+		problems = append(problems, "initialState differs from what initialState returned")
+	}
+	expected := 0
+	if moved != nil {
+		expected = 1
+	}
+	if len(result.Updates) != expected {
+		return append(problems, fmt.Sprintf("returned %d updates for %d moves", len(result.Updates), expected))
+	}
+	if moved != nil {
+		for _, problem := range ValidateGameUpdate(result.Updates[0], setup) {
+			problems = append(problems, "updates.0: "+problem)
+		}
+		if !sameJSON(result.Updates[0], moved) {
+			problems = append(problems, "updates.0 differs from what processMove returned")
+		}
+	}
+	return problems
+}
+
+// callGame makes a call into the game, shortening an error the game throws
+// to its first line. Other errors are returned whole.
+func callGame(call func() (json.RawMessage, error)) (json.RawMessage, error) {
+	res, err := call()
+	var gameError *GameError
+	if errors.As(err, &gameError) {
+		return nil, fmt.Errorf("threw %s", firstLine(err.Error()))
+	}
+	return res, err
+}
+
+func sameJSON(a, b json.RawMessage) bool {
+	return normalizeJSON(gjson.ParseBytes(a)) == normalizeJSON(gjson.ParseBytes(b))
+}
+
+// CheckUpdate checks a GameUpdate has the shape the platform relies on for
+// the players seated in setup, failing with the first problem found.
+func CheckUpdate(update json.RawMessage, setup *SetupState) error {
+	if problems := ValidateGameUpdate(update, setup); len(problems) != 0 {
+		return fmt.Errorf("invalid GameUpdate: %s", problems[0])
+	}
+	return nil
+}
+
+// ValidateGameUpdate lists the ways update breaks the GameUpdate contract
+// in interface.md for the players seated in setup.
+func ValidateGameUpdate(update json.RawMessage, setup *SetupState) []string {
+	if !gjson.ValidBytes(update) {
+		return []string{"not valid JSON"}
+	}
+	u := gjson.ParseBytes(update)
+	if !u.IsObject() {
+		return []string{"not an object"}
+	}
+	problems := []string{}
+	seated := map[int]bool{}
+	for _, p := range setup.Players {
+		seated[p.Position] = true
+	}
+	positions := func(path string) {
+		v := u.Get(path)
+		if !v.IsArray() {
+			problems = append(problems, fmt.Sprintf("%s must be an array of positions", path))
+			return
+		}
+		for i, p := range v.Array() {
+			switch {
+			case !isInteger(p):
+				problems = append(problems, fmt.Sprintf("%s.%d is %s, not a position", path, i, p.Raw))
+			case !seated[int(p.Num)]:
+				problems = append(problems, fmt.Sprintf("%s has position %d, which is not seated", path, int(p.Num)))
+			}
+		}
+	}
+
+	game := u.Get("game")
+	switch phase := game.Get("phase"); {
+	case !game.IsObject():
+		problems = append(problems, "game must be an object")
+	case phase.String() == "started" && phase.Type == gjson.String:
+		positions("game.currentPlayers")
+		if v := u.Get("game.currentPlayers"); v.IsArray() && len(v.Array()) == 0 {
+			problems = append(problems, "game is started with no currentPlayers")
+		}
+	case phase.String() == "finished" && phase.Type == gjson.String:
+		positions("game.winners")
+	case !phase.Exists():
+		problems = append(problems, "game.phase is missing")
+	default:
+		problems = append(problems, fmt.Sprintf("game.phase is %s, not \"started\" or \"finished\"", phase.Raw))
+	}
+	if game.IsObject() && !game.Get("state").Exists() {
+		problems = append(problems, "game.state is missing")
+	}
+
+	players := u.Get("players")
+	if !players.IsArray() {
+		problems = append(problems, "players must be an array of player states")
+	} else {
+		seen := map[int]bool{}
+		for i, p := range players.Array() {
+			position := p.Get("position")
+			switch {
+			case !p.IsObject():
+				problems = append(problems, fmt.Sprintf("players.%d must be an object", i))
+				continue
+			case !isInteger(position):
+				problems = append(problems, fmt.Sprintf("players.%d.position must be a position", i))
+			case !seated[int(position.Num)]:
+				problems = append(problems, fmt.Sprintf("players.%d is for position %d, which is not seated", i, int(position.Num)))
+			case seen[int(position.Num)]:
+				problems = append(problems, fmt.Sprintf("players has more than one state for position %d", int(position.Num)))
+			default:
+				seen[int(position.Num)] = true
+			}
+			if !p.Get("state").Exists() {
+				problems = append(problems, fmt.Sprintf("players.%d.state is missing", i))
+			}
+			if s := p.Get("summary"); s.Exists() && s.Type != gjson.String {
+				problems = append(problems, fmt.Sprintf("players.%d.summary must be a string", i))
+			}
+			if s := p.Get("score"); s.Exists() && s.Type != gjson.Number {
+				problems = append(problems, fmt.Sprintf("players.%d.score must be a number", i))
+			}
+		}
+		missing := []int{}
+		for p := range seated {
+			if !seen[p] {
+				missing = append(missing, p)
+			}
+		}
+		sort.Ints(missing)
+		for _, p := range missing {
+			problems = append(problems, fmt.Sprintf("players has no state for position %d", p))
+		}
+	}
+
+	messages := u.Get("messages")
+	if !messages.IsArray() {
+		problems = append(problems, "messages must be an array")
+	} else {
+		for i, m := range messages.Array() {
+			if m.Get("body").Type != gjson.String {
+				problems = append(problems, fmt.Sprintf("messages.%d.body must be a string", i))
+			}
+			if p := m.Get("position"); p.Exists() && !isInteger(p) {
+				problems = append(problems, fmt.Sprintf("messages.%d.position must be a position", i))
+			}
+		}
+	}
+	return problems
+}
+
+func isInteger(v gjson.Result) bool {
+	return v.Type == gjson.Number && v.Num == float64(int(v.Num))
+}